@@ -0,0 +1,636 @@
+// The decision API answers the same questions as the /auth endpoints over gRPC.
+// Regenerate the Go code from the api directory with `buf generate`.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: decision/decision.proto
+
+package decision
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CheckRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	SystemCode string                 `protobuf:"bytes,1,opt,name=system_code,json=systemCode,proto3" json:"system_code,omitempty"`
+	UserCode   string                 `protobuf:"bytes,2,opt,name=user_code,json=userCode,proto3" json:"user_code,omitempty"`
+	// Resource index relative to the system, e.g. "/resource_a/resource_b".
+	ResourceIndex string `protobuf:"bytes,3,opt,name=resource_index,json=resourceIndex,proto3" json:"resource_index,omitempty"`
+	// One of view, download, edit and manage.
+	Action        string `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_decision_decision_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CheckRequest) GetSystemCode() string {
+	if x != nil {
+		return x.SystemCode
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetUserCode() string {
+	if x != nil {
+		return x.UserCode
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetResourceIndex() string {
+	if x != nil {
+		return x.ResourceIndex
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+type CheckResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Authorized    bool                   `protobuf:"varint,1,opt,name=authorized,proto3" json:"authorized,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_decision_decision_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CheckResponse) GetAuthorized() bool {
+	if x != nil {
+		return x.Authorized
+	}
+	return false
+}
+
+type BatchCheckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Checks        []*CheckRequest        `protobuf:"bytes,1,rep,name=checks,proto3" json:"checks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCheckRequest) Reset() {
+	*x = BatchCheckRequest{}
+	mi := &file_decision_decision_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCheckRequest) ProtoMessage() {}
+
+func (x *BatchCheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCheckRequest.ProtoReflect.Descriptor instead.
+func (*BatchCheckRequest) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BatchCheckRequest) GetChecks() []*CheckRequest {
+	if x != nil {
+		return x.Checks
+	}
+	return nil
+}
+
+type BatchCheckResult struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Request    *CheckRequest          `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	Authorized bool                   `protobuf:"varint,2,opt,name=authorized,proto3" json:"authorized,omitempty"`
+	// Set when the request itself is invalid, e.g. an unknown user code.
+	Error         string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCheckResult) Reset() {
+	*x = BatchCheckResult{}
+	mi := &file_decision_decision_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCheckResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCheckResult) ProtoMessage() {}
+
+func (x *BatchCheckResult) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCheckResult.ProtoReflect.Descriptor instead.
+func (*BatchCheckResult) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchCheckResult) GetRequest() *CheckRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *BatchCheckResult) GetAuthorized() bool {
+	if x != nil {
+		return x.Authorized
+	}
+	return false
+}
+
+func (x *BatchCheckResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type BatchCheckResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*BatchCheckResult    `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchCheckResponse) Reset() {
+	*x = BatchCheckResponse{}
+	mi := &file_decision_decision_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchCheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchCheckResponse) ProtoMessage() {}
+
+func (x *BatchCheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchCheckResponse.ProtoReflect.Descriptor instead.
+func (*BatchCheckResponse) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchCheckResponse) GetResults() []*BatchCheckResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type ListPermissionsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SystemCode    string                 `protobuf:"bytes,1,opt,name=system_code,json=systemCode,proto3" json:"system_code,omitempty"`
+	UserCode      string                 `protobuf:"bytes,2,opt,name=user_code,json=userCode,proto3" json:"user_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPermissionsRequest) Reset() {
+	*x = ListPermissionsRequest{}
+	mi := &file_decision_decision_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPermissionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPermissionsRequest) ProtoMessage() {}
+
+func (x *ListPermissionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPermissionsRequest.ProtoReflect.Descriptor instead.
+func (*ListPermissionsRequest) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListPermissionsRequest) GetSystemCode() string {
+	if x != nil {
+		return x.SystemCode
+	}
+	return ""
+}
+
+func (x *ListPermissionsRequest) GetUserCode() string {
+	if x != nil {
+		return x.UserCode
+	}
+	return ""
+}
+
+type Permission struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The user or the role holding the policy.
+	FromCode      string `protobuf:"bytes,1,opt,name=from_code,json=fromCode,proto3" json:"from_code,omitempty"`
+	ResourceIndex string `protobuf:"bytes,2,opt,name=resource_index,json=resourceIndex,proto3" json:"resource_index,omitempty"`
+	Action        string `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	// RFC 3339 timestamps bounding the validity of the policy.
+	BeginTime     string `protobuf:"bytes,4,opt,name=begin_time,json=beginTime,proto3" json:"begin_time,omitempty"`
+	EndTime       string `protobuf:"bytes,5,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Permission) Reset() {
+	*x = Permission{}
+	mi := &file_decision_decision_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Permission) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Permission) ProtoMessage() {}
+
+func (x *Permission) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Permission.ProtoReflect.Descriptor instead.
+func (*Permission) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Permission) GetFromCode() string {
+	if x != nil {
+		return x.FromCode
+	}
+	return ""
+}
+
+func (x *Permission) GetResourceIndex() string {
+	if x != nil {
+		return x.ResourceIndex
+	}
+	return ""
+}
+
+func (x *Permission) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Permission) GetBeginTime() string {
+	if x != nil {
+		return x.BeginTime
+	}
+	return ""
+}
+
+func (x *Permission) GetEndTime() string {
+	if x != nil {
+		return x.EndTime
+	}
+	return ""
+}
+
+type ListPermissionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Permissions   []*Permission          `protobuf:"bytes,1,rep,name=permissions,proto3" json:"permissions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPermissionsResponse) Reset() {
+	*x = ListPermissionsResponse{}
+	mi := &file_decision_decision_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPermissionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPermissionsResponse) ProtoMessage() {}
+
+func (x *ListPermissionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPermissionsResponse.ProtoReflect.Descriptor instead.
+func (*ListPermissionsResponse) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListPermissionsResponse) GetPermissions() []*Permission {
+	if x != nil {
+		return x.Permissions
+	}
+	return nil
+}
+
+type ExplainResponse struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Authorized bool                   `protobuf:"varint,1,opt,name=authorized,proto3" json:"authorized,omitempty"`
+	// Unset when the request is denied.
+	MatchedPermission *Permission `protobuf:"bytes,2,opt,name=matched_permission,json=matchedPermission,proto3" json:"matched_permission,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ExplainResponse) Reset() {
+	*x = ExplainResponse{}
+	mi := &file_decision_decision_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExplainResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExplainResponse) ProtoMessage() {}
+
+func (x *ExplainResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_decision_decision_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExplainResponse.ProtoReflect.Descriptor instead.
+func (*ExplainResponse) Descriptor() ([]byte, []int) {
+	return file_decision_decision_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ExplainResponse) GetAuthorized() bool {
+	if x != nil {
+		return x.Authorized
+	}
+	return false
+}
+
+func (x *ExplainResponse) GetMatchedPermission() *Permission {
+	if x != nil {
+		return x.MatchedPermission
+	}
+	return nil
+}
+
+var File_decision_decision_proto protoreflect.FileDescriptor
+
+const file_decision_decision_proto_rawDesc = "" +
+	"\n" +
+	"\x17decision/decision.proto\x12\x0eac.decision.v1\"\x8b\x01\n" +
+	"\fCheckRequest\x12\x1f\n" +
+	"\vsystem_code\x18\x01 \x01(\tR\n" +
+	"systemCode\x12\x1b\n" +
+	"\tuser_code\x18\x02 \x01(\tR\buserCode\x12%\n" +
+	"\x0eresource_index\x18\x03 \x01(\tR\rresourceIndex\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\"/\n" +
+	"\rCheckResponse\x12\x1e\n" +
+	"\n" +
+	"authorized\x18\x01 \x01(\bR\n" +
+	"authorized\"I\n" +
+	"\x11BatchCheckRequest\x124\n" +
+	"\x06checks\x18\x01 \x03(\v2\x1c.ac.decision.v1.CheckRequestR\x06checks\"\x80\x01\n" +
+	"\x10BatchCheckResult\x126\n" +
+	"\arequest\x18\x01 \x01(\v2\x1c.ac.decision.v1.CheckRequestR\arequest\x12\x1e\n" +
+	"\n" +
+	"authorized\x18\x02 \x01(\bR\n" +
+	"authorized\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"P\n" +
+	"\x12BatchCheckResponse\x12:\n" +
+	"\aresults\x18\x01 \x03(\v2 .ac.decision.v1.BatchCheckResultR\aresults\"V\n" +
+	"\x16ListPermissionsRequest\x12\x1f\n" +
+	"\vsystem_code\x18\x01 \x01(\tR\n" +
+	"systemCode\x12\x1b\n" +
+	"\tuser_code\x18\x02 \x01(\tR\buserCode\"\xa2\x01\n" +
+	"\n" +
+	"Permission\x12\x1b\n" +
+	"\tfrom_code\x18\x01 \x01(\tR\bfromCode\x12%\n" +
+	"\x0eresource_index\x18\x02 \x01(\tR\rresourceIndex\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\x12\x1d\n" +
+	"\n" +
+	"begin_time\x18\x04 \x01(\tR\tbeginTime\x12\x19\n" +
+	"\bend_time\x18\x05 \x01(\tR\aendTime\"W\n" +
+	"\x17ListPermissionsResponse\x12<\n" +
+	"\vpermissions\x18\x01 \x03(\v2\x1a.ac.decision.v1.PermissionR\vpermissions\"|\n" +
+	"\x0fExplainResponse\x12\x1e\n" +
+	"\n" +
+	"authorized\x18\x01 \x01(\bR\n" +
+	"authorized\x12I\n" +
+	"\x12matched_permission\x18\x02 \x01(\v2\x1a.ac.decision.v1.PermissionR\x11matchedPermission2\xda\x02\n" +
+	"\x0fDecisionService\x12D\n" +
+	"\x05Check\x12\x1c.ac.decision.v1.CheckRequest\x1a\x1d.ac.decision.v1.CheckResponse\x12S\n" +
+	"\n" +
+	"BatchCheck\x12!.ac.decision.v1.BatchCheckRequest\x1a\".ac.decision.v1.BatchCheckResponse\x12b\n" +
+	"\x0fListPermissions\x12&.ac.decision.v1.ListPermissionsRequest\x1a'.ac.decision.v1.ListPermissionsResponse\x12H\n" +
+	"\aExplain\x12\x1c.ac.decision.v1.CheckRequest\x1a\x1f.ac.decision.v1.ExplainResponseB,\n" +
+	"\x0eac.decision.v1P\x01Z\x18ac/api/decision;decisionb\x06proto3"
+
+var (
+	file_decision_decision_proto_rawDescOnce sync.Once
+	file_decision_decision_proto_rawDescData []byte
+)
+
+func file_decision_decision_proto_rawDescGZIP() []byte {
+	file_decision_decision_proto_rawDescOnce.Do(func() {
+		file_decision_decision_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_decision_decision_proto_rawDesc), len(file_decision_decision_proto_rawDesc)))
+	})
+	return file_decision_decision_proto_rawDescData
+}
+
+var file_decision_decision_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_decision_decision_proto_goTypes = []any{
+	(*CheckRequest)(nil),            // 0: ac.decision.v1.CheckRequest
+	(*CheckResponse)(nil),           // 1: ac.decision.v1.CheckResponse
+	(*BatchCheckRequest)(nil),       // 2: ac.decision.v1.BatchCheckRequest
+	(*BatchCheckResult)(nil),        // 3: ac.decision.v1.BatchCheckResult
+	(*BatchCheckResponse)(nil),      // 4: ac.decision.v1.BatchCheckResponse
+	(*ListPermissionsRequest)(nil),  // 5: ac.decision.v1.ListPermissionsRequest
+	(*Permission)(nil),              // 6: ac.decision.v1.Permission
+	(*ListPermissionsResponse)(nil), // 7: ac.decision.v1.ListPermissionsResponse
+	(*ExplainResponse)(nil),         // 8: ac.decision.v1.ExplainResponse
+}
+var file_decision_decision_proto_depIdxs = []int32{
+	0, // 0: ac.decision.v1.BatchCheckRequest.checks:type_name -> ac.decision.v1.CheckRequest
+	0, // 1: ac.decision.v1.BatchCheckResult.request:type_name -> ac.decision.v1.CheckRequest
+	3, // 2: ac.decision.v1.BatchCheckResponse.results:type_name -> ac.decision.v1.BatchCheckResult
+	6, // 3: ac.decision.v1.ListPermissionsResponse.permissions:type_name -> ac.decision.v1.Permission
+	6, // 4: ac.decision.v1.ExplainResponse.matched_permission:type_name -> ac.decision.v1.Permission
+	0, // 5: ac.decision.v1.DecisionService.Check:input_type -> ac.decision.v1.CheckRequest
+	2, // 6: ac.decision.v1.DecisionService.BatchCheck:input_type -> ac.decision.v1.BatchCheckRequest
+	5, // 7: ac.decision.v1.DecisionService.ListPermissions:input_type -> ac.decision.v1.ListPermissionsRequest
+	0, // 8: ac.decision.v1.DecisionService.Explain:input_type -> ac.decision.v1.CheckRequest
+	1, // 9: ac.decision.v1.DecisionService.Check:output_type -> ac.decision.v1.CheckResponse
+	4, // 10: ac.decision.v1.DecisionService.BatchCheck:output_type -> ac.decision.v1.BatchCheckResponse
+	7, // 11: ac.decision.v1.DecisionService.ListPermissions:output_type -> ac.decision.v1.ListPermissionsResponse
+	8, // 12: ac.decision.v1.DecisionService.Explain:output_type -> ac.decision.v1.ExplainResponse
+	9, // [9:13] is the sub-list for method output_type
+	5, // [5:9] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_decision_decision_proto_init() }
+func file_decision_decision_proto_init() {
+	if File_decision_decision_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_decision_decision_proto_rawDesc), len(file_decision_decision_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_decision_decision_proto_goTypes,
+		DependencyIndexes: file_decision_decision_proto_depIdxs,
+		MessageInfos:      file_decision_decision_proto_msgTypes,
+	}.Build()
+	File_decision_decision_proto = out.File
+	file_decision_decision_proto_goTypes = nil
+	file_decision_decision_proto_depIdxs = nil
+}
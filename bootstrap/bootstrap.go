@@ -3,16 +3,36 @@ package bootstrap
 import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
+	"ac/custom/tracing"
+	"context"
+	"errors"
 	"fmt"
 )
 
-// Initialize initializes all necessary components (config, MySQL, logger)
+// Version of the build, set with -ldflags "-X ac/bootstrap.Version=<version>".
+var Version = "dev"
+
+// Initialize initializes all necessary components (config, MySQL, logger, tracing)
 func Initialize() error {
 	if err := logger.InitLogger(); err != nil {
 		return fmt.Errorf("failed to initialize logger, err: %w", err)
 	}
+	if err := tracing.Init(context.Background()); err != nil {
+		return fmt.Errorf("failed to initialize tracing, err: %w", err)
+	}
 	if err := database.InitMySQL(); err != nil {
 		return fmt.Errorf("failed to initialize MySQL, err: %w", err)
 	}
 	return nil
 }
+
+// Shutdown releases the components of Initialize in the reverse order: it closes MySQL, exports
+// the spans still buffered and flushes the logs.
+func Shutdown(ctx context.Context) error {
+	errList := []error{database.Close()}
+	if err := tracing.Shutdown(ctx); err != nil {
+		errList = append(errList, err)
+	}
+	logger.Sync()
+	return errors.Join(errList...)
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"ac/custom/tracing"
 	"fmt"
 	"sync"
 
@@ -39,12 +40,47 @@ func InitMySQL() error {
 			config.Database,
 		)
 
-		db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+		// TranslateError turns the duplicate key errors into gorm.ErrDuplicatedKey, see dal.ErrDuplicateKey
+		db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 		if err != nil {
 			initErr = fmt.Errorf("failed to connect to MySQL, err: %w", err)
 			return
 		}
+		if err := db.Use(tracing.GormPlugin()); err != nil {
+			initErr = fmt.Errorf("failed to register tracing plugin, err: %w", err)
+			return
+		}
 		DB = db
 	})
 	return initErr
 }
+
+// Close closes the connection pool, once the service no longer queries the database.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get sql.DB, err: %w", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		return fmt.Errorf("failed to close MySQL, err: %w", err)
+	}
+	return nil
+}
+
+// Summary describes the connection settings for diagnostics, with the password redacted.
+func Summary() map[string]string {
+	password := ""
+	if config.Password != "" {
+		password = "[redacted]"
+	}
+	return map[string]string{
+		"user":     config.User,
+		"password": password,
+		"host":     config.Host,
+		"port":     config.Port,
+		"database": config.Database,
+	}
+}
@@ -0,0 +1,169 @@
+// Package lifecycle starts the components of the service in order, runs them until the process
+// is told to stop or one of them fails, then stops them in the reverse order within a drain
+// timeout.
+package lifecycle
+
+import (
+	"ac/bootstrap/logger"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// EnvShutdownTimeout overrides the time given to the components to stop, as a Go duration
+	EnvShutdownTimeout = "AC_SHUTDOWN_TIMEOUT"
+
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
+// Hook is a component of the service. Every func is optional.
+type Hook struct {
+	Name string
+	// Start prepares the component and returns once it is ready, e.g. listening on its port
+	Start func(ctx context.Context) error
+	// Serve runs the component until Stop is called. An error, or a panic, stops the service.
+	Serve func() error
+	// Stop stops the component, giving up when ctx is done
+	Stop func(ctx context.Context) error
+}
+
+// App is the list of components of the service, in the order they start.
+type App struct {
+	hookList        []Hook
+	shutdownTimeout time.Duration
+}
+
+// New returns an empty App stopping within the timeout set by EnvShutdownTimeout, or
+// DefaultShutdownTimeout.
+func New() (*App, error) {
+	a := &App{shutdownTimeout: DefaultShutdownTimeout}
+	if v := strings.TrimSpace(os.Getenv(EnvShutdownTimeout)); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid %s: %s", EnvShutdownTimeout, v)
+		}
+		a.shutdownTimeout = timeout
+	}
+	return a, nil
+}
+
+// Append adds a component started after those already added and stopped before them.
+func (a *App) Append(hook Hook) {
+	a.hookList = append(a.hookList, hook)
+}
+
+// Run starts the components and serves until ctx is done, the process receives SIGINT or
+// SIGTERM, or a component fails. The components that started are stopped in every case. It
+// returns the failure that ended the run, joined with those of stopping.
+func (a *App) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	started := 0
+	var runErr error
+	for _, hook := range a.hookList {
+		if hook.Start == nil {
+			started++
+			continue
+		}
+		if err := call(hook.Name, func() error { return hook.Start(ctx) }); err != nil {
+			runErr = fmt.Errorf("failed to start %s, err: %w", hook.Name, err)
+			break
+		}
+		logger.Infof(ctx, "started %s", hook.Name)
+		started++
+	}
+
+	if runErr == nil {
+		failed := make(chan error, len(a.hookList))
+		for _, hook := range a.hookList[:started] {
+			if hook.Serve == nil {
+				continue
+			}
+			go func() {
+				if err := call(hook.Name, hook.Serve); err != nil {
+					failed <- fmt.Errorf("%s failed, err: %w", hook.Name, err)
+				}
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			logger.Infof(ctx, "stopping, shutdown timeout: %s", a.shutdownTimeout)
+		case runErr = <-failed:
+			logger.Errorf(ctx, "stopping, err: %v", runErr)
+		}
+	}
+
+	// Stopping must not be cut short by the signal that ended the run
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.shutdownTimeout)
+	defer cancel()
+	errList := []error{runErr}
+	for i := started - 1; i >= 0; i-- {
+		hook := a.hookList[i]
+		if hook.Stop == nil {
+			continue
+		}
+		if err := call(hook.Name, func() error { return hook.Stop(stopCtx) }); err != nil {
+			errList = append(errList, fmt.Errorf("failed to stop %s, err: %w", hook.Name, err))
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// call runs fn, turning a panic into an error.
+func call(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	return fn()
+}
+
+// Loop returns the hook of a background loop, such as webhook.Run, which returns once its
+// context is done. Stop waits for the loop to return, so that it finishes its current round.
+func Loop(name string, run func(ctx context.Context)) Hook {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var mu sync.Mutex
+	running, stopped := false, false
+	return Hook{
+		Name: name,
+		Serve: func() error {
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				return nil
+			}
+			running = true
+			mu.Unlock()
+			defer close(done)
+			run(ctx)
+			return nil
+		},
+		Stop: func(stopCtx context.Context) error {
+			mu.Lock()
+			stopped = true
+			wasRunning := running
+			mu.Unlock()
+			cancel()
+			if !wasRunning {
+				return nil
+			}
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return fmt.Errorf("gave up waiting for the loop, err: %w", stopCtx.Err())
+			}
+		},
+	}
+}
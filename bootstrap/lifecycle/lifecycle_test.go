@@ -0,0 +1,126 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder records the calls of the hooks in order.
+type recorder struct {
+	mu       sync.Mutex
+	callList []string
+}
+
+func (r *recorder) hook(name string, startErr error) Hook {
+	return Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			r.record("start " + name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+func (r *recorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.callList = append(r.callList, call)
+}
+
+func (r *recorder) calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.callList)
+}
+
+// TestRunOrder stops the components in the reverse order once the context is done, waiting
+// for the loops to return.
+func TestRunOrder(t *testing.T) {
+	r := &recorder{}
+	app, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	app.Append(r.hook("db", nil))
+	app.Append(Loop("loop", func(ctx context.Context) {
+		<-ctx.Done()
+		r.record("loop returned")
+	}))
+	app.Append(r.hook("server", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := app.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := []string{"start db", "start server", "stop server", "loop returned", "stop db"}
+	if got := r.calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+// TestStartFailure stops only the components that started and reports the failure.
+func TestStartFailure(t *testing.T) {
+	r := &recorder{}
+	app, _ := New()
+	app.Append(r.hook("db", nil))
+	app.Append(Loop("loop", func(ctx context.Context) {
+		t.Error("the loop ran although the start failed")
+	}))
+	app.Append(r.hook("server", errors.New("address already in use")))
+	app.Append(r.hook("late", nil))
+
+	err := app.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to start server") {
+		t.Fatalf("Run() error = %v, want the start failure", err)
+	}
+	want := []string{"start db", "start server", "stop db"}
+	if got := r.calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+// TestServePanic stops the service when a component panics instead of crashing the process.
+func TestServePanic(t *testing.T) {
+	r := &recorder{}
+	app, _ := New()
+	app.Append(r.hook("db", nil))
+	app.Append(Hook{
+		Name: "worker",
+		Serve: func() error {
+			panic("nil map")
+		},
+	})
+
+	err := app.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "worker panicked: nil map") {
+		t.Fatalf("Run() error = %v, want the panic", err)
+	}
+	if got := r.calls(); !slices.Contains(got, "stop db") {
+		t.Errorf("calls = %v, want db stopped", got)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	t.Setenv(EnvShutdownTimeout, "30s")
+	app, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if app.shutdownTimeout != 30*time.Second {
+		t.Errorf("shutdown timeout = %s, want 30s", app.shutdownTimeout)
+	}
+	t.Setenv(EnvShutdownTimeout, "soon")
+	if _, err := New(); err == nil {
+		t.Error("New() accepted an invalid timeout")
+	}
+}
@@ -1,11 +1,20 @@
+// Package logger writes the logs of the service. A log line carries the metadata of the request
+// found in its context, see custom/meta, along with the fields added with WithFields. The level
+// can be changed while the service runs, see SetLevel. The decisions go to a sink of their own,
+// see Decision.
 package logger
 
 import (
+	"ac/custom/meta"
+	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -19,15 +28,33 @@ const (
 	LevelWarn  Level = "warn"
 	LevelError Level = "error"
 
-	defaultLogDirectory = "./log"
+	// EnvLevel sets the level at start, info by default
+	EnvLevel = "LOG_LEVEL"
+	// EnvDecisionSample sets the sampling of the decision log as "initial,thereafter": every
+	// second, the first initial decisions of each result are written, then every thereafter-th
+	// one. "0" writes every decision.
+	EnvDecisionSample = "LOG_DECISION_SAMPLE"
+
+	defaultLogDirectory   = "./log"
+	defaultDecisionSample = "100,10"
 )
 
+var level2Zap = map[Level]zapcore.Level{
+	LevelDebug: zapcore.DebugLevel,
+	LevelInfo:  zapcore.InfoLevel,
+	LevelWarn:  zapcore.WarnLevel,
+	LevelError: zapcore.ErrorLevel,
+}
+
 var (
-	sugaredLogger *zap.SugaredLogger
-	once          sync.Once
+	// Nothing is written before InitLogger
+	baseLogger     = zap.NewNop()
+	decisionLogger = zap.NewNop()
+	atomicLevel    = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	once           sync.Once
 )
 
-// NewLogger initializes the logger with the provided configuration
+// InitLogger initializes the logger and the decision log
 func InitLogger() error {
 	var initErr error
 	once.Do(func() {
@@ -35,6 +62,17 @@ func InitLogger() error {
 			initErr = fmt.Errorf("failed to create log directory, err: %w", err)
 			return
 		}
+		if v := strings.TrimSpace(os.Getenv(EnvLevel)); v != "" {
+			if err := SetLevel(Level(v)); err != nil {
+				initErr = err
+				return
+			}
+		}
+		initial, thereafter, err := parseSample(os.Getenv(EnvDecisionSample))
+		if err != nil {
+			initErr = err
+			return
+		}
 
 		encoderConfig := zap.NewProductionEncoderConfig()
 		encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
@@ -42,19 +80,16 @@ func InitLogger() error {
 		fileEncoder := zapcore.NewJSONEncoder(encoderConfig)
 		consoleEncoder := zapcore.NewConsoleEncoder(encoderConfig)
 
-		outputLevel := zapcore.InfoLevel
-
 		lowPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			return lvl >= outputLevel && lvl <= zapcore.InfoLevel
+			return atomicLevel.Enabled(lvl) && lvl <= zapcore.InfoLevel
 		})
 
 		highPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			return lvl >= outputLevel && lvl > zapcore.InfoLevel
+			return atomicLevel.Enabled(lvl) && lvl > zapcore.InfoLevel
 		})
 
 		cores := []zapcore.Core{
-			zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), lowPriority),
-			zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), highPriority),
+			zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), atomicLevel),
 			zapcore.NewCore(fileEncoder, zapcore.AddSync(&lumberjack.Logger{
 				Filename:   fmt.Sprintf("%s/low.log", defaultLogDirectory),
 				MaxSize:    500,
@@ -68,75 +103,166 @@ func InitLogger() error {
 				MaxAge:     7,
 			}), highPriority),
 		}
+		baseLogger = zap.New(zapcore.NewTee(cores...))
 
-		core := zapcore.NewTee(cores...)
-		logger := zap.New(core)
-		defer logger.Sync()
-
-		sugaredLogger = logger.Sugar()
+		// The decision log does not follow the level of the service, it is only sampled
+		var decisionCore zapcore.Core = zapcore.NewCore(fileEncoder, zapcore.AddSync(&lumberjack.Logger{
+			Filename:   fmt.Sprintf("%s/decision.log", defaultLogDirectory),
+			MaxSize:    500,
+			MaxBackups: 10,
+			MaxAge:     28,
+		}), zapcore.InfoLevel)
+		if initial > 0 {
+			decisionCore = zapcore.NewSamplerWithOptions(decisionCore, time.Second, initial, thereafter)
+		}
+		decisionLogger = zap.New(decisionCore)
 	})
 
 	return initErr
 }
 
+func parseSample(s string) (int, int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		s = defaultDecisionSample
+	}
+	initialStr, thereafterStr, _ := strings.Cut(s, ",")
+	initial, err := strconv.Atoi(strings.TrimSpace(initialStr))
+	if err != nil || initial < 0 {
+		return 0, 0, fmt.Errorf("invalid %s: %s", EnvDecisionSample, s)
+	}
+	thereafter := 0
+	if thereafterStr != "" {
+		if thereafter, err = strconv.Atoi(strings.TrimSpace(thereafterStr)); err != nil || thereafter < 0 {
+			return 0, 0, fmt.Errorf("invalid %s: %s", EnvDecisionSample, s)
+		}
+	}
+	return initial, thereafter, nil
+}
+
+// Sync flushes the buffered logs. Syncing the console fails on some platforms, so the errors
+// are not returned.
+func Sync() {
+	_ = baseLogger.Sync()
+	_ = decisionLogger.Sync()
+}
+
 func Get() *zap.SugaredLogger {
-	return sugaredLogger
+	return baseLogger.Sugar()
+}
+
+// GetLevel returns the current level
+func GetLevel() Level {
+	for k, v := range level2Zap {
+		if v == atomicLevel.Level() {
+			return k
+		}
+	}
+	return LevelInfo
+}
+
+// SetLevel changes the level of the service logs, the decision log is not affected
+func SetLevel(level Level) error {
+	lvl, ok := level2Zap[level]
+	if !ok {
+		return fmt.Errorf("invalid log level: %s", level)
+	}
+	atomicLevel.SetLevel(lvl)
+	return nil
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx whose logs also carry the key-value pairs kv, in addition to
+// the fields ctx already carries.
+func WithFields(ctx context.Context, kv ...interface{}) context.Context {
+	prev, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	fields := make([]zap.Field, len(prev), len(prev)+len(kv)/2)
+	copy(fields, prev)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields = append(fields, zap.Any(fmt.Sprint(kv[i]), kv[i+1]))
+	}
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// contextFields returns the fields carried by ctx: the request metadata, the trace and the fields
+// added with WithFields. The empty ones are left out.
+func contextFields(ctx context.Context, extra int) []zap.Field {
+	m := meta.FromContext(ctx)
+	added, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	fields := make([]zap.Field, 0, 6+len(added)+extra)
+	for _, v := range [...]struct{ key, value string }{
+		{"method", m.Method},
+		{"uri", m.URI},
+		{"request_id", m.RequestID},
+		{"system_code", m.SystemCode},
+		{"actor", m.AdminUser},
+	} {
+		if v.value != "" {
+			fields = append(fields, zap.String(v.key, v.value))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+	}
+	return append(fields, added...)
 }
 
 // LogWith records a log message with request context information
 // Parameters:
-//   - ctx: Echo context containing the HTTP request/response data
+//   - ctx: Context carrying the request metadata and the fields added with WithFields
 //   - level: Logging level (debug/info/warn/error)
 //   - msg: Message to be logged
 //   - kv: Additional key-value pairs to include in log
-func LogWith(ctx echo.Context, level Level, msg string, kv map[string]interface{}) {
-	// Create context fields map
-	baseKv := map[string]interface{}{
-		"method":     ctx.Request().Method,
-		"uri":        ctx.Request().RequestURI,
-		"request_id": ctx.Response().Header().Get(echo.HeaderXRequestID),
+func LogWith(ctx context.Context, level Level, msg string, kv map[string]interface{}) {
+	lvl, ok := level2Zap[level]
+	if !ok {
+		lvl = zapcore.InfoLevel
 	}
-
-	// Merge with external kv map, letting external values take precedence
+	ce := baseLogger.Check(lvl, msg)
+	if ce == nil {
+		return
+	}
+	fields := contextFields(ctx, len(kv))
 	for k, v := range kv {
-		baseKv[k] = v
+		fields = append(fields, zap.Any(k, v))
 	}
+	ce.Write(fields...)
+}
 
-	// Add all fields to logger
-	fields := make([]interface{}, 0, len(baseKv)*2)
-	for k, v := range baseKv {
-		fields = append(fields, k, v)
+func logf(ctx context.Context, lvl zapcore.Level, msg string, v []interface{}) {
+	// The message is only formatted when the level is enabled
+	if !atomicLevel.Enabled(lvl) {
+		return
 	}
-
-	// Log message at appropriate level
-	switch level {
-	case LevelDebug:
-		sugaredLogger.With(fields...).Debug(msg)
-	case LevelInfo:
-		sugaredLogger.With(fields...).Info(msg)
-	case LevelWarn:
-		sugaredLogger.With(fields...).Warn(msg)
-	case LevelError:
-		sugaredLogger.With(fields...).Error(msg)
-	default:
-		sugaredLogger.With(fields...).Info(msg)
+	if ce := baseLogger.Check(lvl, fmt.Sprintf(msg, v...)); ce != nil {
+		ce.Write(contextFields(ctx, 0)...)
 	}
 }
 
-// The following functions wrap logWithContext providing a convenient way
+// The following functions wrap logf providing a convenient way
 // to log messages with different log levels: Info, Debug, Warn, and Error.
-func Infof(ctx echo.Context, msg string, v ...interface{}) {
-	LogWith(ctx, LevelInfo, fmt.Sprintf(msg, v...), nil)
+func Infof(ctx context.Context, msg string, v ...interface{}) {
+	logf(ctx, zapcore.InfoLevel, msg, v)
 }
 
-func Debugf(ctx echo.Context, msg string, v ...interface{}) {
-	LogWith(ctx, LevelDebug, fmt.Sprintf(msg, v...), nil)
+func Debugf(ctx context.Context, msg string, v ...interface{}) {
+	logf(ctx, zapcore.DebugLevel, msg, v)
 }
 
-func Warnf(ctx echo.Context, msg string, v ...interface{}) {
-	LogWith(ctx, LevelWarn, fmt.Sprintf(msg, v...), nil)
+func Warnf(ctx context.Context, msg string, v ...interface{}) {
+	logf(ctx, zapcore.WarnLevel, msg, v)
 }
 
-func Errorf(ctx echo.Context, msg string, v ...interface{}) {
-	LogWith(ctx, LevelError, fmt.Sprintf(msg, v...), nil)
+func Errorf(ctx context.Context, msg string, v ...interface{}) {
+	logf(ctx, zapcore.ErrorLevel, msg, v)
+}
+
+// Decision writes a decision to the decision log, with the fields carried by ctx. The result is
+// the message, so that every result is sampled on its own and the rare denials are not drowned
+// by the allowed decisions.
+func Decision(ctx context.Context, result string, fields ...zap.Field) {
+	if ce := decisionLogger.Check(zapcore.InfoLevel, result); ce != nil {
+		ce.Write(append(contextFields(ctx, len(fields)), fields...)...)
+	}
 }
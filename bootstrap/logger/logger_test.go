@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"ac/custom/meta"
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestContextFields logs with the metadata of a request and an added field, then raises the
+// level at runtime.
+func TestContextFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	baseLogger = zap.New(zapcore.RegisterHooks(core), zap.IncreaseLevel(atomicLevel))
+	defer SetLevel(LevelInfo)
+
+	ctx := meta.NewContext(context.Background(), meta.Meta{RequestID: "req_1", AdminUser: "user_1"})
+	ctx = WithFields(ctx, "system_code", "system_1")
+	Infof(ctx, "granted %s", "view")
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	got := entries[0].ContextMap()
+	for k, want := range map[string]string{"request_id": "req_1", "actor": "user_1", "system_code": "system_1"} {
+		if got[k] != want {
+			t.Errorf("field %s = %v, want %s", k, got[k], want)
+		}
+	}
+	if entries[0].Message != "granted view" {
+		t.Errorf("message = %q, want %q", entries[0].Message, "granted view")
+	}
+
+	if err := SetLevel(LevelWarn); err != nil {
+		t.Fatalf("SetLevel() error = %v", err)
+	}
+	Infof(ctx, "dropped")
+	Warnf(ctx, "kept")
+	if entries := logs.TakeAll(); len(entries) != 1 || entries[0].Message != "kept" {
+		t.Errorf("got %v after raising the level, want only the warning", entries)
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("SetLevel() accepted an unknown level")
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	for s, want := range map[string][2]int{"": {100, 10}, "0": {0, 0}, "5, 2": {5, 2}} {
+		initial, thereafter, err := parseSample(s)
+		if err != nil || initial != want[0] || thereafter != want[1] {
+			t.Errorf("parseSample(%q) = %d, %d, %v, want %d, %d", s, initial, thereafter, err, want[0], want[1])
+		}
+	}
+	if _, _, err := parseSample("many"); err == nil {
+		t.Error("parseSample() accepted an invalid value")
+	}
+}
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+type codeRequest struct {
+	SystemCode string `json:"system_code,omitempty"`
+	Code       string `json:"code"`
+}
+
+type userRoleRequest struct {
+	SystemCode   string   `json:"system_code"`
+	UserCode     string   `json:"user_code"`
+	RoleCodeList []string `json:"role_code_list"`
+	BeginTime    int64    `json:"begin_time,omitempty"`
+	EndTime      int64    `json:"end_time,omitempty"`
+	Version      int64    `json:"-"`
+}
+
+func (r userRoleRequest) ifMatch() int64 { return r.Version }
+
+type subjectRequest struct {
+	Page        int    `json:"page"`
+	PageSize    int    `json:"page_size"`
+	SystemCode  string `json:"system_code"`
+	SubjectCode string `json:"subject_code"`
+}
+
+func (c *Client) AddSystem(ctx context.Context, req AddSystemRequest) (*System, error) {
+	out := &System{}
+	if err := c.call(ctx, http.MethodPost, "/system/add", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) UpdateSystem(ctx context.Context, req UpdateSystemRequest) error {
+	return c.call(ctx, http.MethodPost, "/system/update", true, req, nil)
+}
+
+func (c *Client) DeleteSystem(ctx context.Context, code string) error {
+	if err := c.call(ctx, http.MethodPost, "/system/delete", true, codeRequest{Code: code}, nil); err != nil {
+		return err
+	}
+	c.InvalidateDecisions()
+	return nil
+}
+
+func (c *Client) QuerySystems(ctx context.Context, page, pageSize int) (*List[System], error) {
+	return c.ListSystems(ctx, ListRequest{Page: page, PageSize: pageSize})
+}
+
+func (c *Client) ListSystems(ctx context.Context, req ListRequest) (*List[System], error) {
+	out := &List[System]{}
+	if err := c.call(ctx, http.MethodGet, "/system/query", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) GetSystem(ctx context.Context, code string) (*System, error) {
+	out := &System{}
+	if err := c.call(ctx, http.MethodGet, "/system/get", true, codeRequest{Code: code}, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) AddUser(ctx context.Context, req AddUserRequest) (*User, error) {
+	out := &User{}
+	if err := c.call(ctx, http.MethodPost, "/user/add", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) UpdateUser(ctx context.Context, req UpdateUserRequest) error {
+	return c.call(ctx, http.MethodPost, "/user/update", true, req, nil)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, systemCode, code string) error {
+	if err := c.call(ctx, http.MethodPost, "/user/delete", true, codeRequest{SystemCode: systemCode, Code: code}, nil); err != nil {
+		return err
+	}
+	c.InvalidateUser(systemCode, code)
+	return nil
+}
+
+func (c *Client) QueryUsers(ctx context.Context, page, pageSize int) (*List[User], error) {
+	return c.ListUsers(ctx, ListRequest{Page: page, PageSize: pageSize})
+}
+
+func (c *Client) ListUsers(ctx context.Context, req ListRequest) (*List[User], error) {
+	out := &List[User]{}
+	if err := c.call(ctx, http.MethodGet, "/user/query", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) GetUser(ctx context.Context, systemCode, code string) (*User, error) {
+	out := &User{}
+	if err := c.call(ctx, http.MethodGet, "/user/get", true, codeRequest{SystemCode: systemCode, Code: code}, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) LookupUsers(ctx context.Context, req LookupRequest) ([]User, error) {
+	out := &List[User]{}
+	if err := c.call(ctx, http.MethodGet, "/user/lookup", true, req, out); err != nil {
+		return nil, err
+	}
+	return out.List, nil
+}
+
+func (c *Client) AddRole(ctx context.Context, req AddRoleRequest) (*Role, error) {
+	out := &Role{}
+	if err := c.call(ctx, http.MethodPost, "/role/add", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) UpdateRole(ctx context.Context, req UpdateRoleRequest) error {
+	return c.call(ctx, http.MethodPost, "/role/update", true, req, nil)
+}
+
+func (c *Client) DeleteRole(ctx context.Context, systemCode, code string) error {
+	if err := c.call(ctx, http.MethodPost, "/role/delete", true, codeRequest{SystemCode: systemCode, Code: code}, nil); err != nil {
+		return err
+	}
+	c.InvalidateDecisions()
+	return nil
+}
+
+func (c *Client) QueryRoles(ctx context.Context, page, pageSize int) (*List[Role], error) {
+	return c.ListRoles(ctx, ListRequest{Page: page, PageSize: pageSize})
+}
+
+func (c *Client) ListRoles(ctx context.Context, req ListRequest) (*List[Role], error) {
+	out := &List[Role]{}
+	if err := c.call(ctx, http.MethodGet, "/role/query", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) GetRole(ctx context.Context, systemCode, code string) (*Role, error) {
+	out := &Role{}
+	if err := c.call(ctx, http.MethodGet, "/role/get", true, codeRequest{SystemCode: systemCode, Code: code}, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) LookupRoles(ctx context.Context, req LookupRequest) ([]Role, error) {
+	out := &List[Role]{}
+	if err := c.call(ctx, http.MethodGet, "/role/lookup", true, req, out); err != nil {
+		return nil, err
+	}
+	return out.List, nil
+}
+
+func (c *Client) AddResource(ctx context.Context, req AddResourceRequest) (*Resource, error) {
+	out := &Resource{}
+	if err := c.call(ctx, http.MethodPost, "/resource/add", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) UpdateResource(ctx context.Context, req UpdateResourceRequest) error {
+	if err := c.call(ctx, http.MethodPost, "/resource/update", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateDecisions()
+	return nil
+}
+
+func (c *Client) DeleteResource(ctx context.Context, systemCode, code string) error {
+	if err := c.call(ctx, http.MethodPost, "/resource/delete", true, codeRequest{SystemCode: systemCode, Code: code}, nil); err != nil {
+		return err
+	}
+	c.InvalidateDecisions()
+	return nil
+}
+
+func (c *Client) QueryResources(ctx context.Context, page, pageSize int) (*List[Resource], error) {
+	return c.ListResources(ctx, ListRequest{Page: page, PageSize: pageSize})
+}
+
+func (c *Client) ListResources(ctx context.Context, req ListRequest) (*List[Resource], error) {
+	out := &List[Resource]{}
+	if err := c.call(ctx, http.MethodGet, "/resource/query", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) GetResource(ctx context.Context, systemCode, code string) (*Resource, error) {
+	out := &Resource{}
+	if err := c.call(ctx, http.MethodGet, "/resource/get", true, codeRequest{SystemCode: systemCode, Code: code}, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LookupResources finds the resources named name under parentCode, an empty parentCode means the top level.
+func (c *Client) LookupResources(ctx context.Context, systemCode, name, parentCode string) ([]Resource, error) {
+	req := struct {
+		SystemCode string `json:"system_code"`
+		Name       string `json:"name"`
+		ParentCode string `json:"parent_code"`
+	}{SystemCode: systemCode, Name: name, ParentCode: parentCode}
+	out := &List[Resource]{}
+	if err := c.call(ctx, http.MethodGet, "/resource/lookup", true, req, out); err != nil {
+		return nil, err
+	}
+	return out.List, nil
+}
+
+func (c *Client) AddUserRoles(ctx context.Context, systemCode, userCode string, roleCodeList []string) error {
+	req := userRoleRequest{SystemCode: systemCode, UserCode: userCode, RoleCodeList: roleCodeList}
+	if err := c.call(ctx, http.MethodPost, "/user-role/add", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateUser(systemCode, userCode)
+	return nil
+}
+
+// AddUserRolesBetween grants the roles for a window in unix seconds, 0 leaves that side open.
+func (c *Client) AddUserRolesBetween(ctx context.Context, systemCode, userCode string, roleCodeList []string, beginTime, endTime int64) error {
+	req := userRoleRequest{SystemCode: systemCode, UserCode: userCode, RoleCodeList: roleCodeList, BeginTime: beginTime, EndTime: endTime}
+	if err := c.call(ctx, http.MethodPost, "/user-role/add", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateUser(systemCode, userCode)
+	return nil
+}
+
+// AddUserRolesIfMatch grants the roles like AddUserRoles if the roles of the user are still at
+// version, as returned by QueryUserRoles. It fails with ErrVersionMismatch otherwise.
+func (c *Client) AddUserRolesIfMatch(ctx context.Context, systemCode, userCode string, roleCodeList []string, version int64) error {
+	req := userRoleRequest{SystemCode: systemCode, UserCode: userCode, RoleCodeList: roleCodeList, Version: version}
+	if err := c.call(ctx, http.MethodPost, "/user-role/add", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateUser(systemCode, userCode)
+	return nil
+}
+
+func (c *Client) DeleteUserRoles(ctx context.Context, systemCode, userCode string, roleCodeList []string) error {
+	req := userRoleRequest{SystemCode: systemCode, UserCode: userCode, RoleCodeList: roleCodeList}
+	if err := c.call(ctx, http.MethodPost, "/user-role/delete", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateUser(systemCode, userCode)
+	return nil
+}
+
+// DeleteUserRolesIfMatch revokes the roles like DeleteUserRoles if the roles of the user are
+// still at version, as returned by QueryUserRoles. It fails with ErrVersionMismatch otherwise.
+func (c *Client) DeleteUserRolesIfMatch(ctx context.Context, systemCode, userCode string, roleCodeList []string, version int64) error {
+	req := userRoleRequest{SystemCode: systemCode, UserCode: userCode, RoleCodeList: roleCodeList, Version: version}
+	if err := c.call(ctx, http.MethodPost, "/user-role/delete", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateUser(systemCode, userCode)
+	return nil
+}
+
+func (c *Client) QueryUserRoles(ctx context.Context, req QueryUserRoleRequest) (*List[UserRole], error) {
+	out := &List[UserRole]{}
+	if err := c.call(ctx, http.MethodGet, "/user-role/query", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddPermissions grants permissions to a user or a role. The subject may be a role, so every
+// cached decision is dropped.
+func (c *Client) AddPermissions(ctx context.Context, req AddPermissionRequest) error {
+	if err := c.call(ctx, http.MethodPost, "/permission/add", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateDecisions()
+	return nil
+}
+
+func (c *Client) DeletePermissions(ctx context.Context, req DeletePermissionRequest) error {
+	if err := c.call(ctx, http.MethodPost, "/permission/delete", true, req, nil); err != nil {
+		return err
+	}
+	c.InvalidateDecisions()
+	return nil
+}
+
+// QueryPermissions returns the direct and inherited permissions of the subject.
+func (c *Client) QueryPermissions(ctx context.Context, systemCode, subjectCode string, page, pageSize int) ([]GrantedPermission, error) {
+	out, err := c.QueryPermissionList(ctx, systemCode, subjectCode, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return out.List, nil
+}
+
+// QueryPermissionList is QueryPermissions returning the version of the direct permissions of
+// the subject along with them, for a change made with AddPermissionRequest.Version.
+func (c *Client) QueryPermissionList(ctx context.Context, systemCode, subjectCode string, page, pageSize int) (*List[GrantedPermission], error) {
+	req := subjectRequest{Page: page, PageSize: pageSize, SystemCode: systemCode, SubjectCode: subjectCode}
+	out := &List[GrantedPermission]{}
+	if err := c.call(ctx, http.MethodGet, "/permission/query", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WhoCan returns the users that may currently perform the action on the resource index.
+func (c *Client) WhoCan(ctx context.Context, req WhoCanRequest) ([]UserAccess, error) {
+	out := &List[UserAccess]{}
+	if err := c.call(ctx, http.MethodGet, "/permission/who-can", true, req, out); err != nil {
+		return nil, err
+	}
+	return out.List, nil
+}
+
+// Authenticate decides whether the user may perform the action on the resource index. With a
+// decision cache the answer may be served locally until it expires or is invalidated.
+func (c *Client) Authenticate(ctx context.Context, req AuthenticateRequest) (bool, error) {
+	if authorized, ok := c.cache.get(req); ok {
+		return authorized, nil
+	}
+	out := struct {
+		Authorized bool `json:"authorized"`
+	}{}
+	if err := c.call(ctx, http.MethodPost, "/auth/authenticate", true, req, &out); err != nil {
+		return false, err
+	}
+	c.cache.set(req, out.Authorized)
+	return out.Authorized, nil
+}
+
+// InvalidateDecisions drops every cached decision. Call it when the policy changed through
+// another client.
+func (c *Client) InvalidateDecisions() {
+	c.cache.clear()
+}
+
+// InvalidateUser drops the cached decisions of one user.
+func (c *Client) InvalidateUser(systemCode, userCode string) {
+	c.cache.deleteUser(systemCode, userCode)
+}
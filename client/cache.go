@@ -0,0 +1,115 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+type decisionKey struct {
+	systemCode    string
+	userCode      string
+	resourceIndex string
+	action        string
+}
+
+type decision struct {
+	authorized bool
+	expireAt   time.Time
+}
+
+// decisionCache keeps authorization decisions in memory. All methods are no-ops on a nil cache
+// so the client does not need to check whether caching is enabled.
+type decisionCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[decisionKey]decision
+}
+
+func newDecisionCache(ttl time.Duration, maxEntries int) *decisionCache {
+	if ttl <= 0 || maxEntries <= 0 {
+		return nil
+	}
+	return &decisionCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[decisionKey]decision),
+	}
+}
+
+func (c *decisionCache) get(req AuthenticateRequest) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+	key := toDecisionKey(req)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(v.expireAt) {
+		delete(c.entries, key)
+		return false, false
+	}
+	return v.authorized, true
+}
+
+func (c *decisionCache) set(req AuthenticateRequest, authorized bool) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[toDecisionKey(req)] = decision{authorized: authorized, expireAt: now.Add(c.ttl)}
+}
+
+// evict drops the expired decisions, and when none has expired an arbitrary one, to make room.
+func (c *decisionCache) evict(now time.Time) {
+	for k, v := range c.entries {
+		if now.After(v.expireAt) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		delete(c.entries, k)
+	}
+}
+
+func (c *decisionCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+func (c *decisionCache) deleteUser(systemCode, userCode string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k.systemCode == systemCode && k.userCode == userCode {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func toDecisionKey(req AuthenticateRequest) decisionKey {
+	return decisionKey{
+		systemCode:    req.SystemCode,
+		userCode:      req.UserCode,
+		resourceIndex: req.ResourceIndex,
+		action:        req.Action,
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ChangeRule is a casbin_rule row touched by a change. Policies carry the resource index in V1,
+// groupings the user in V0 and the role in V1.
+type ChangeRule struct {
+	PType string `json:"ptype"`
+	V0    string `json:"v0"`
+	V1    string `json:"v1"`
+	V2    string `json:"v2"`
+	V3    string `json:"v3"`
+	V4    string `json:"v4"`
+}
+
+type Change struct {
+	ID        int64        `json:"id"`
+	Operate   string       `json:"operate"`
+	RuleList  []ChangeRule `json:"rule_list"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// ChangeList is a page of the change feed, LastID is the position to resume from.
+type ChangeList struct {
+	LastID int64    `json:"last_id"`
+	List   []Change `json:"list"`
+}
+
+const maxPollTimeout = 30 * time.Second
+
+// PollChanges waits up to timeout for policy changes after afterID. The timeout is rounded
+// down to whole seconds and must stay below the timeout of the HTTP client.
+func (c *Client) PollChanges(ctx context.Context, afterID int64, timeout time.Duration) (*ChangeList, error) {
+	req := struct {
+		AfterID int64 `json:"after_id"`
+		Timeout int   `json:"timeout"`
+	}{AfterID: afterID, Timeout: max(int(timeout/time.Second), 1)}
+	out := &ChangeList{}
+	if err := c.call(ctx, http.MethodGet, "/changefeed/poll", true, req, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WatchChanges follows the change feed from afterID and drops the cached decisions whenever
+// the policy changes. It returns when ctx is done or a poll fails after its retries, with the
+// position to resume from.
+func (c *Client) WatchChanges(ctx context.Context, afterID int64) (int64, error) {
+	timeout := maxPollTimeout
+	if c.httpClient.Timeout > 0 {
+		timeout = min(timeout, c.httpClient.Timeout/2)
+	}
+	for {
+		changeList, err := c.PollChanges(ctx, afterID, timeout)
+		if err != nil {
+			return afterID, err
+		}
+		if len(changeList.List) > 0 {
+			// A grouping or a role policy may affect any user, so there is no cheaper invalidation
+			c.InvalidateDecisions()
+		}
+		afterID = changeList.LastID
+	}
+}
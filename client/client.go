@@ -0,0 +1,257 @@
+// Package client is the Go SDK of the access control service. It wraps every HTTP endpoint
+// with a typed method, decodes failures into *controller.Error values and can cache
+// authorization decisions locally.
+package client
+
+import (
+	"ac/controller"
+	"ac/custom/define"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The predefined errors of the service. Errors returned by the client match them with errors.Is.
+var (
+	ErrSystemError       = controller.ErrSystemError
+	ErrInvalidInput      = controller.ErrInvalidInput
+	ErrRecordNotFound    = controller.ErrRecordNotFound
+	ErrSodViolation      = controller.ErrSodViolation
+	ErrOutOfScope        = controller.ErrOutOfScope
+	ErrConflict          = controller.ErrConflict
+	ErrAlreadyExists     = controller.ErrAlreadyExists
+	ErrDependencyFailure = controller.ErrDependencyFailure
+	ErrVersionMismatch   = controller.ErrVersionMismatch
+	ErrUnauthorized      = controller.ErrUnauthorized
+	ErrRateLimited       = controller.ErrRateLimited
+	ErrRequestTooLarge   = controller.ErrRequestTooLarge
+)
+
+// Client calls the access control service. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+	cache      *decisionCache
+	adminUser  string
+	apiKey     string
+	token      string
+}
+
+// RetryPolicy controls how calls are retried after transport errors and 5xx responses. Every
+// attempt of a mutating call carries the same Idempotency-Key, so the service replays the
+// response of an attempt that got through instead of applying the change twice.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient replaces the default HTTP client, e.g. to set a timeout or a custom transport.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithDecisionCache caches the results of Authenticate for ttl, keeping at most maxEntries decisions.
+func WithDecisionCache(ttl time.Duration, maxEntries int) Option {
+	return func(c *Client) {
+		c.cache = newDecisionCache(ttl, maxEntries)
+	}
+}
+
+// WithToken authenticates the calls with the admin or the gateway token of the service, the
+// administration API refuses the calls without one.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithAdminUser makes the calls on behalf of a delegated administrator, whose changes are
+// limited to the resources the user manages. The gateway token only acts on behalf of a user.
+func WithAdminUser(userCode string) Option {
+	return func(c *Client) {
+		c.adminUser = userCode
+	}
+}
+
+// WithAPIKey identifies the client to the rate limits of the service, which otherwise count
+// the calls by IP address. The key has to be one of those configured on the service.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.MaxAttempts <= 0 {
+		c.retry.MaxAttempts = 1
+	}
+	return c
+}
+
+// response mirrors output.Response with a lazily decoded data field.
+type response struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Hint string          `json:"hint"`
+	Data json.RawMessage `json:"data"`
+}
+
+// statusError is returned when the service answers with an unexpected HTTP status.
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected http status %d: %s", e.StatusCode, e.Body)
+}
+
+// conditional is implemented by the requests that may be based on a version of the data they
+// change. A version other than 0 is sent in If-Match.
+type conditional interface {
+	ifMatch() int64
+}
+
+// call sends body as JSON and decodes the data of a successful response into out.
+// The service reads the parameters of GET endpoints from a JSON body as well.
+func (c *Client) call(ctx context.Context, method, path string, retryable bool, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request, err: %w", err)
+	}
+	header := http.Header{}
+	if v, ok := body.(conditional); ok && v.ifMatch() != 0 {
+		header.Set("If-Match", strconv.Quote(strconv.FormatInt(v.ifMatch(), 10)))
+	}
+	if method == http.MethodPost {
+		header.Set("Idempotency-Key", uuid.NewString())
+	}
+
+	attempts := 1
+	if retryable {
+		attempts = c.retry.MaxAttempts
+	}
+	backoff := c.retry.Backoff
+	for i := 0; ; i++ {
+		err = c.do(ctx, method, path, payload, header, out)
+		if err == nil || i+1 >= attempts || !isTemporary(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.retry.MaxBackoff)
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, payload []byte, header http.Header, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request, err: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.adminUser != "" {
+		req.Header.Set(define.HeaderAdminUser, c.adminUser)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(define.HeaderAPIKey, c.apiKey)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request, err: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response, err: %w", err)
+	}
+	result := response{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{StatusCode: resp.StatusCode, Body: string(raw)}
+		}
+		return fmt.Errorf("failed to decode response, err: %w", err)
+	}
+	if result.Code != 0 {
+		e := controller.NewError(result.Code, result.Msg, result.Hint)
+		detail := struct {
+			FieldList []controller.FieldError `json:"field_list"`
+		}{}
+		if len(result.Data) > 0 && json.Unmarshal(result.Data, &detail) == nil {
+			e.FieldList = detail.FieldList
+		}
+		return e
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &statusError{StatusCode: resp.StatusCode, Body: string(raw)}
+	}
+	if out == nil || len(result.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf("failed to decode response data, err: %w", err)
+	}
+	return nil
+}
+
+// isTemporary reports whether a failed call may succeed when retried.
+func isTemporary(err error) bool {
+	if errors.Is(err, ErrDependencyFailure) || errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	var httpErr *statusError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	var transportErr *url.Error
+	if errors.As(err, &transportErr) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return false
+}
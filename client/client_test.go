@@ -0,0 +1,145 @@
+package client_test
+
+import (
+	"ac/client"
+	"ac/client/clienttest"
+	"ac/controller"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	server := clienttest.NewServer()
+	c := server.Client(client.WithDecisionCache(time.Minute, 100))
+
+	system, err := c.AddSystem(ctx, client.AddSystemRequest{Name: "crm"})
+	if err != nil {
+		t.Fatalf("AddSystem() error = %v", err)
+	}
+	user, err := c.AddUser(ctx, client.AddUserRequest{SystemCode: system.Code, Name: "alice"})
+	if err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	role, err := c.AddRole(ctx, client.AddRoleRequest{SystemCode: system.Code, Name: "editor"})
+	if err != nil {
+		t.Fatalf("AddRole() error = %v", err)
+	}
+	parent, err := c.AddResource(ctx, client.AddResourceRequest{SystemCode: system.Code, Name: "docs"})
+	if err != nil {
+		t.Fatalf("AddResource() error = %v", err)
+	}
+	child, err := c.AddResource(ctx, client.AddResourceRequest{SystemCode: system.Code, Name: "report", ParentCode: parent.Code})
+	if err != nil {
+		t.Fatalf("AddResource() error = %v", err)
+	}
+
+	req := client.AuthenticateRequest{
+		SystemCode:    system.Code,
+		UserCode:      user.Code,
+		ResourceIndex: "/" + parent.Code + "/" + child.Code,
+		Action:        "view",
+	}
+	if authorized, err := c.Authenticate(ctx, req); err != nil || authorized {
+		t.Fatalf("Authenticate() = %v, %v, want false, nil", authorized, err)
+	}
+
+	err = c.AddPermissions(ctx, client.AddPermissionRequest{
+		SystemCode:  system.Code,
+		SubjectCode: role.Code,
+		PermissionList: []client.Permission{{
+			ResourceIndex: parent.Code,
+			Action:        "edit",
+			BeginTime:     time.Now().Add(-time.Hour).Unix(),
+			EndTime:       time.Now().Add(time.Hour).Unix(),
+		}},
+		Inherit: true,
+	})
+	if err != nil {
+		t.Fatalf("AddPermissions() error = %v", err)
+	}
+	if err := c.AddUserRoles(ctx, system.Code, user.Code, []string{role.Code}); err != nil {
+		t.Fatalf("AddUserRoles() error = %v", err)
+	}
+	if authorized, err := c.Authenticate(ctx, req); err != nil || !authorized {
+		t.Fatalf("Authenticate() = %v, %v, want true, nil", authorized, err)
+	}
+
+	// The second decision is served from the cache
+	calls := server.Calls("/auth/authenticate")
+	if authorized, err := c.Authenticate(ctx, req); err != nil || !authorized {
+		t.Fatalf("Authenticate() = %v, %v, want true, nil", authorized, err)
+	}
+	if got := server.Calls("/auth/authenticate"); got != calls {
+		t.Errorf("Authenticate() called the server %d times, want %d", got, calls)
+	}
+
+	// Overrides are only seen once the cached decision is dropped
+	server.SetDecision(req, false)
+	c.InvalidateUser(system.Code, user.Code)
+	if authorized, err := c.Authenticate(ctx, req); err != nil || authorized {
+		t.Fatalf("Authenticate() = %v, %v, want false, nil", authorized, err)
+	}
+
+	req.UserCode = "user_unknown"
+	_, err = c.Authenticate(ctx, req)
+	if !errors.Is(err, client.ErrInvalidInput) {
+		t.Errorf("Authenticate() error = %v, want %v", err, client.ErrInvalidInput)
+	}
+	var e *controller.Error
+	if !errors.As(err, &e) || len(e.FieldList) != 1 || e.FieldList[0].Field != "user_code" {
+		t.Errorf("Authenticate() error fields = %+v, want user_code", e)
+	}
+	if _, err := c.GetUser(ctx, system.Code, "user_unknown"); !errors.Is(err, client.ErrRecordNotFound) {
+		t.Errorf("GetUser() error = %v, want %v", err, client.ErrRecordNotFound)
+	}
+}
+
+func TestUserRolesIfMatch(t *testing.T) {
+	ctx := context.Background()
+	c := clienttest.NewServer().Client()
+
+	system, err := c.AddSystem(ctx, client.AddSystemRequest{Name: "crm"})
+	if err != nil {
+		t.Fatalf("AddSystem() error = %v", err)
+	}
+	user, err := c.AddUser(ctx, client.AddUserRequest{SystemCode: system.Code, Name: "alice"})
+	if err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	editor, err := c.AddRole(ctx, client.AddRoleRequest{SystemCode: system.Code, Name: "editor"})
+	if err != nil {
+		t.Fatalf("AddRole() error = %v", err)
+	}
+	viewer, err := c.AddRole(ctx, client.AddRoleRequest{SystemCode: system.Code, Name: "viewer"})
+	if err != nil {
+		t.Fatalf("AddRole() error = %v", err)
+	}
+
+	// Two admins read the roles of the user and both edit them
+	query := client.QueryUserRoleRequest{Page: 1, PageSize: 10, SystemCode: system.Code, UserCodeList: []string{user.Code}}
+	read, err := c.QueryUserRoles(ctx, query)
+	if err != nil {
+		t.Fatalf("QueryUserRoles() error = %v", err)
+	}
+	if err := c.AddUserRolesIfMatch(ctx, system.Code, user.Code, []string{editor.Code}, read.Version); err != nil {
+		t.Fatalf("AddUserRolesIfMatch() error = %v", err)
+	}
+	err = c.AddUserRolesIfMatch(ctx, system.Code, user.Code, []string{viewer.Code}, read.Version)
+	if !errors.Is(err, client.ErrVersionMismatch) {
+		t.Fatalf("AddUserRolesIfMatch() error = %v, want %v", err, client.ErrVersionMismatch)
+	}
+
+	reread, err := c.QueryUserRoles(ctx, query)
+	if err != nil {
+		t.Fatalf("QueryUserRoles() error = %v", err)
+	}
+	if reread.Version == read.Version || len(reread.List) != 1 {
+		t.Fatalf("QueryUserRoles() = version %d with %d roles, want a new version with 1 role", reread.Version, len(reread.List))
+	}
+	if err := c.AddUserRolesIfMatch(ctx, system.Code, user.Code, []string{viewer.Code}, reread.Version); err != nil {
+		t.Fatalf("AddUserRolesIfMatch() error = %v", err)
+	}
+}
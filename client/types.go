@@ -0,0 +1,216 @@
+package client
+
+import "time"
+
+// List is the page returned by the query endpoints. Total is only filled by the endpoints that
+// count, and not when the request skipped it.
+type List[T any] struct {
+	Total int64 `json:"total"`
+	List  []T   `json:"list"`
+	// NextCursor picks the page after this one, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Version of the listed set, only for the permissions of a subject and the roles of a single
+	// user. Pass it back with a change to make the change fail if the set changed meanwhile.
+	Version int64 `json:"version,omitempty"`
+}
+
+type System struct {
+	ID          int64     `json:"ID"`
+	Name        string    `json:"name"`
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	ModifiedBy  string    `json:"modified_by"`
+	UpdatedAt   time.Time `json:"update_at"`
+}
+
+type User struct {
+	ID          int64     `json:"ID"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	SystemCode  string    `json:"system_code"`
+	Code        string    `json:"code"`
+	ExternalID  string    `json:"external_id"`
+	ModifiedBy  string    `json:"modified_by"`
+	UpdatedAt   time.Time `json:"update_at"`
+}
+
+type Role User
+
+type Resource struct {
+	ID          int64     `json:"ID"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	SystemCode  string    `json:"system_code"`
+	Code        string    `json:"code"`
+	ParentCode  string    `json:"parent_code"`
+	ModifiedBy  string    `json:"modified_by"`
+	UpdatedAt   time.Time `json:"update_at"`
+}
+
+// UserRole is a role membership. BeginTime and EndTime are RFC3339, empty when that side of
+// the window is open.
+type UserRole struct {
+	SystemCode string `json:"system_code"`
+	UserCode   string `json:"user_code"`
+	UserName   string `json:"user_name"`
+	RoleCode   string `json:"role_code"`
+	RoleName   string `json:"role_name"`
+	BeginTime  string `json:"begin_time"`
+	EndTime    string `json:"end_time"`
+}
+
+// Permission is granted to or revoked from a subject. BeginTime and EndTime are unix seconds.
+type Permission struct {
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+	BeginTime     int64  `json:"begin_time"`
+	EndTime       int64  `json:"end_time"`
+}
+
+// GrantedPermission is a direct or inherited permission of a subject, FromCode is the subject holding it.
+type GrantedPermission struct {
+	FromCode      string `json:"from_code"`
+	FromName      string `json:"from_name"`
+	ResourceIndex string `json:"resource_index"`
+	ResourceName  string `json:"resource_name"`
+	Action        string `json:"action"`
+	BeginTime     string `json:"begin_time"`
+	EndTime       string `json:"end_time"`
+}
+
+// AccessGrant is a policy that lets a user perform an action. Via is "direct", "ancestor" for a
+// "/*" policy of the user on an ancestor, or "role" with Inherited telling the same for the role.
+type AccessGrant struct {
+	Via           string `json:"via"`
+	RoleCode      string `json:"role_code"`
+	RoleName      string `json:"role_name"`
+	Inherited     bool   `json:"inherited"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+	BeginTime     string `json:"begin_time"`
+	EndTime       string `json:"end_time"`
+}
+
+type UserAccess struct {
+	UserCode  string        `json:"user_code"`
+	UserName  string        `json:"user_name"`
+	GrantList []AccessGrant `json:"grant_list"`
+}
+
+type WhoCanRequest struct {
+	SystemCode    string `json:"system_code"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+}
+
+type AddSystemRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Code        string `json:"code,omitempty"`
+}
+
+type UpdateSystemRequest struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AddUserRequest creates a user, Code is generated by the service when empty.
+type AddUserRequest struct {
+	SystemCode  string `json:"system_code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Code        string `json:"code,omitempty"`
+	ExternalID  string `json:"external_id,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	SystemCode  string `json:"system_code"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ExternalID  string `json:"external_id"`
+}
+
+type AddRoleRequest AddUserRequest
+
+type UpdateRoleRequest UpdateUserRequest
+
+type AddResourceRequest struct {
+	SystemCode  string `json:"system_code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentCode  string `json:"parent_code"`
+	Code        string `json:"code,omitempty"`
+}
+
+type UpdateResourceRequest struct {
+	SystemCode  string `json:"system_code"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentCode  string `json:"parent_code"`
+}
+
+// LookupRequest finds users or roles by exactly one of Name and ExternalID.
+type LookupRequest struct {
+	SystemCode string `json:"system_code"`
+	Name       string `json:"name,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+// ListRequest pages, filters and sorts a list. A page is picked either by Page or by Cursor, the
+// NextCursor of the previous page, which stays valid while records are added or removed.
+type ListRequest struct {
+	Page     int    `json:"page,omitempty"`
+	PageSize int    `json:"page_size,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+	// Sort key, prefixed with "-" for descending order, e.g. "-updated_at"
+	Sort      string `json:"sort,omitempty"`
+	SkipTotal bool   `json:"skip_total,omitempty"`
+
+	SystemCode string `json:"system_code,omitempty"`
+	NamePrefix string `json:"name_prefix,omitempty"`
+	// Only for resources, a pointer to an empty code asks for the top level
+	ParentCode *string `json:"parent_code,omitempty"`
+	// Range of the update time in unix seconds, from inclusive and to exclusive
+	UpdatedFrom int64 `json:"updated_from,omitempty"`
+	UpdatedTo   int64 `json:"updated_to,omitempty"`
+}
+
+type QueryUserRoleRequest struct {
+	Page         int      `json:"page"`
+	PageSize     int      `json:"page_size"`
+	Cursor       string   `json:"cursor,omitempty"`
+	SkipTotal    bool     `json:"skip_total,omitempty"`
+	SystemCode   string   `json:"system_code"`
+	UserCodeList []string `json:"user_code_list,omitempty"`
+	RoleCodeList []string `json:"role_code_list,omitempty"`
+}
+
+type AddPermissionRequest struct {
+	SystemCode     string       `json:"system_code"`
+	SubjectCode    string       `json:"subject_code"`
+	PermissionList []Permission `json:"permission_list"`
+	Inherit        bool         `json:"inherit"`
+	// Version of the permissions the change is based on, 0 applies it unconditionally
+	Version int64 `json:"-"`
+}
+
+type DeletePermissionRequest struct {
+	SystemCode     string       `json:"system_code"`
+	SubjectCode    string       `json:"subject_code"`
+	PermissionList []Permission `json:"permission_list"`
+	// Version of the permissions the change is based on, 0 applies it unconditionally
+	Version int64 `json:"-"`
+}
+
+func (r AddPermissionRequest) ifMatch() int64    { return r.Version }
+func (r DeletePermissionRequest) ifMatch() int64 { return r.Version }
+
+type AuthenticateRequest struct {
+	SystemCode    string `json:"system_code"`
+	UserCode      string `json:"user_code"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+}
@@ -4,73 +4,144 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/controller"
-	"ac/custom/define"
+	"ac/custom/admin"
 	"ac/custom/input"
 	"ac/custom/output"
-	"ac/service/casbin"
-	"ac/service/resource"
-	"ac/service/subject"
-	"strings"
+	"ac/dal"
+	"ac/model"
+	"ac/service/decision"
+	"errors"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 func RegisterRoutes(g *echo.Group) {
 	g.POST("/authenticate", authenticate)
+	g.GET("/decisions", queryDecision, admin.Authenticate())
 }
 
-func authenticate(ctx echo.Context) error {
+type Decision struct {
+	ID            int64     `json:"id"`
+	SystemCode    string    `json:"system_code"`
+	UserCode      string    `json:"user_code"`
+	ResourceIndex string    `json:"resource_index"`
+	Action        string    `json:"action"`
+	Result        string    `json:"result"`
+	MatchedRule   string    `json:"matched_rule"`
+	LatencyUs     int64     `json:"latency_us"`
+	RequestID     string    `json:"request_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func authenticate(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode    string `json:"system_code" validate:"required,gt=0"`
 		UserCode      string `json:"user_code" validate:"required,gt=0"`
 		ResourceIndex string `json:"resource_index" validate:"required,gt=0"`
 		Action        string `json:"action" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
-	if _, ok := define.ValidAction2Level[body.Action]; !ok {
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid action"))
-	}
-	if ok, err := subject.ValidateUser(ctx, body.SystemCode, body.UserCode); !ok {
-		if err != nil {
-			logger.Errorf(ctx, "failed to validate user, err: %v, system code: %s, code: %s", err, body.SystemCode, body.UserCode)
-		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid resource code"))
-	}
-	partList := strings.Split(body.ResourceIndex, "/")
 
-	resourceCodeList := make([]string, 0, len(partList)-1)
-	for _, v := range partList {
-		if !strings.HasPrefix(v, define.PrefixResource) {
-			continue
+	authorized, err := decision.Check(ctx, decision.Request{
+		SystemCode:    body.SystemCode,
+		UserCode:      body.UserCode,
+		ResourceIndex: body.ResourceIndex,
+		Action:        body.Action,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, decision.ErrInvalidAction):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("action", "Invalid action"))
+		case errors.Is(err, decision.ErrInvalidUser):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("user_code", "Invalid user code"))
+		case errors.Is(err, decision.ErrInvalidResourceIndex):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("resource_index", "Invalid resource index"))
 		}
-		resourceCodeList = append(resourceCodeList, v)
+		logger.Errorf(ctx, "failed to authenticate, err: %v, system code: %s, user code: %s, resource index: %s", err, body.SystemCode, body.UserCode, body.ResourceIndex)
+		return output.Failure(c, err)
 	}
+	return output.Success(c, map[string]bool{
+		"authorized": authorized,
+	})
+}
 
-	validateResult, err := resource.ValidateBatch(ctx, body.SystemCode, resourceCodeList)
-	if err != nil {
-		logger.Errorf(ctx, "failed to validate role, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
-	}
+// decisionListSpec is what the decision log supports, the system code is required and the
+// latest decisions come first by default.
+var decisionListSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+	},
+	SortKeys:    []string{"created_at", "latency_us"},
+	DefaultSort: "-id",
+}
 
-	for _, v := range resourceCodeList {
-		if valid, ok := validateResult[v]; ok && valid {
-			continue
-		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid resource index"))
+// queryDecision lists the recorded decisions of a system. The decisions of the last second may
+// not be written yet, see service/decisionlog.
+func queryDecision(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		dal.ListQuery
+		UserCode      string `json:"user_code"`
+		ResourceIndex string `json:"resource_index"`
+		Action        string `json:"action"`
+		Result        string `json:"result" validate:"omitempty,oneof=allow deny invalid error"`
+		// Range of the decision time in unix seconds, from inclusive and to exclusive
+		DecidedFrom int64 `json:"decided_from" validate:"gte=0"`
+		DecidedTo   int64 `json:"decided_to" validate:"gte=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.SystemCode == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "'system_code' failed on the 'required' rule"))
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.DecidedFrom > 0 && body.DecidedTo > 0 && body.DecidedTo <= body.DecidedFrom {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("decided_to", "'decided_to' must be after 'decided_from'"))
 	}
 
-	enforcer, err := casbin.NewEnforcer(database.DB)
+	recordList, info, err := dal.List[model.DecisionLog](ctx, database.DB, body.ListQuery, decisionListSpec, func(db *gorm.DB) *gorm.DB {
+		// The zero fields are left out
+		db = db.Where(model.DecisionLog{
+			UserCode:      body.UserCode,
+			ResourceIndex: body.ResourceIndex,
+			Action:        body.Action,
+			Result:        body.Result,
+		})
+		if body.DecidedFrom > 0 {
+			db = db.Where("created_at >= ?", time.Unix(body.DecidedFrom, 0).UTC())
+		}
+		if body.DecidedTo > 0 {
+			db = db.Where("created_at < ?", time.Unix(body.DecidedTo, 0).UTC())
+		}
+		return db
+	})
 	if err != nil {
-		return output.Failure(ctx, controller.ErrSystemError)
+		logger.Errorf(ctx, "failed to query, err: %v", err)
+		return output.Failure(c, err)
 	}
-	authorized, err := enforcer.Enforce(body.UserCode, body.SystemCode+body.ResourceIndex, body.Action)
-	if err != nil {
-		logger.Errorf(ctx, "failed to enforce, err: %v, system code: %s, user code: %s, resource code: %s", err, body.SystemCode, body.UserCode, body.ResourceIndex)
-		return output.Failure(ctx, controller.ErrSystemError)
+
+	list := make([]Decision, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, Decision{
+			ID:            v.ID,
+			SystemCode:    v.SystemCode,
+			UserCode:      v.UserCode,
+			ResourceIndex: v.ResourceIndex,
+			Action:        v.Action,
+			Result:        v.Result,
+			MatchedRule:   v.MatchedRule,
+			LatencyUs:     v.LatencyUs,
+			RequestID:     v.RequestID,
+			CreatedAt:     v.CreatedAt,
+		})
 	}
-	return output.Success(ctx, map[string]bool{
-		"authorized": authorized,
-	})
+	return output.List(c, list, info)
 }
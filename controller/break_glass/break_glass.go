@@ -0,0 +1,254 @@
+package break_glass
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/admin"
+	"ac/custom/define"
+	"ac/custom/input"
+	"ac/custom/output"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/breakglass"
+	"ac/service/resource"
+	"ac/service/subject"
+	"ac/service/system"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type Grant struct {
+	ID            int64      `json:"id"`
+	Code          string     `json:"code"`
+	UserCode      string     `json:"user_code"`
+	ResourceIndex string     `json:"resource_index"`
+	Action        string     `json:"action"`
+	Justification string     `json:"justification"`
+	RequestedBy   string     `json:"requested_by"`
+	BeginTime     time.Time  `json:"begin_time"`
+	EndTime       time.Time  `json:"end_time"`
+	ReviewedBy    string     `json:"reviewed_by"`
+	ReviewNote    string     `json:"review_note"`
+	ReviewedAt    *time.Time `json:"reviewed_at"`
+}
+
+func RegisterRoutes(g *echo.Group) {
+	g.POST("/grant", grant)
+	g.POST("/review", review)
+	g.GET("/report", report)
+}
+
+// validateResourceIndex checks that every resource of the index belongs to the system.
+func validateResourceIndex(ctx context.Context, systemCode, resourceIndex string) (bool, error) {
+	resourceCodeList := make([]string, 0)
+	for _, v := range strings.Split(resourceIndex, "/") {
+		if strings.HasPrefix(v, define.PrefixResource) {
+			resourceCodeList = append(resourceCodeList, v)
+		}
+	}
+	if len(resourceCodeList) == 0 {
+		return false, nil
+	}
+	validateResult, err := resource.ValidateBatch(ctx, systemCode, resourceCodeList)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range resourceCodeList {
+		if valid, ok := validateResult[v]; !ok || !valid {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// grant gives the user the action on the resource path right away, for duration seconds.
+func grant(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode    string `json:"system_code" validate:"required,gt=0"`
+		UserCode      string `json:"user_code" validate:"required,gt=0"`
+		ResourceIndex string `json:"resource_index" validate:"required,gt=0"`
+		Action        string `json:"action" validate:"required,gt=0"`
+		Justification string `json:"justification" validate:"required,gt=0,lte=500"`
+		RequestedBy   string `json:"requested_by" validate:"required,gt=0,lte=50"`
+		Duration      int64  `json:"duration" validate:"required,gt=0,lte=14400"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	body.Justification = strings.TrimSpace(body.Justification)
+	if body.Justification == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("justification", "justification is empty"))
+	}
+	duration := time.Duration(body.Duration) * time.Second
+	if duration > breakglass.MaxDuration {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("duration", "duration must not exceed "+breakglass.MaxDuration.String()))
+	}
+	if _, ok := define.ValidAction2Level[body.Action]; !ok {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("action", "Invalid action"))
+	}
+	body.ResourceIndex = strings.Trim(strings.TrimSpace(body.ResourceIndex), "/")
+
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+	if ok, err := subject.ValidateUser(ctx, body.SystemCode, body.UserCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate user, err: %v, system code: %s, code: %s", err, body.SystemCode, body.UserCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("user_code", "Invalid user code"))
+	}
+	if ok, err := validateResourceIndex(ctx, body.SystemCode, body.ResourceIndex); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate resource, err: %v, resource index: %s", err, body.ResourceIndex)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("resource_index", "Invalid resource index"))
+	}
+	if err := admin.Authorize(ctx, body.SystemCode, body.ResourceIndex); err != nil {
+		return output.Failure(c, err)
+	}
+
+	code := ""
+	for i := 0; i < 3; i++ {
+		tmpCode := util.GenerateCode(define.PrefixBreakGlass)
+
+		ok, err := breakglass.IsCodeAvailable(ctx, tmpCode)
+		if err != nil {
+			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+			return output.Failure(c, err)
+		}
+		if ok {
+			code = tmpCode
+			break
+		}
+	}
+	if code == "" {
+		logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+		return output.Failure(c, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+	}
+
+	now := util.UTCNow().Truncate(time.Second)
+	record := &model.BreakGlass{
+		SystemCode:    body.SystemCode,
+		Code:          code,
+		UserCode:      body.UserCode,
+		ResourceIndex: body.ResourceIndex,
+		Action:        body.Action,
+		Justification: body.Justification,
+		RequestedBy:   body.RequestedBy,
+		BeginTime:     now,
+		EndTime:       now.Add(duration),
+		CreatedAt:     now,
+	}
+	if err := breakglass.Add(ctx, record); err != nil {
+		logger.Errorf(ctx, "failed to grant break-glass access, err: %v", err)
+		if errors.Is(err, breakglass.ErrAlreadyGranted) {
+			return output.Failure(c, controller.ErrAlreadyExists.WithHint("The user already holds a break-glass grant on this resource"))
+		}
+		return output.Failure(c, err)
+	}
+	return output.Success(c, toGrant(record))
+}
+
+func review(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `json:"system_code" validate:"required,gt=0"`
+		Code       string `json:"code" validate:"required,gt=0"`
+		ReviewedBy string `json:"reviewed_by" validate:"required,gt=0,lte=50"`
+		ReviewNote string `json:"review_note" validate:"lte=500"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if err := breakglass.Review(ctx, body.SystemCode, body.Code, body.ReviewedBy, body.ReviewNote); err != nil {
+		if errors.Is(err, breakglass.ErrNotFound) {
+			return output.Failure(c, controller.ErrRecordNotFound)
+		}
+		if errors.Is(err, breakglass.ErrAlreadyReviewed) {
+			return output.Failure(c, controller.ErrConflict.WithHint("The grant has already been reviewed"))
+		}
+		logger.Errorf(ctx, "failed to review break-glass grant, err: %v, code: %s", err, body.Code)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+// report lists the grants still waiting for a review, newest first, or all of them.
+// reportListSpec is what the report supports, the system code is required and the latest
+// grants come first by default.
+var reportListSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+	},
+	SortKeys:    []string{"begin_time", "end_time"},
+	DefaultSort: "-id",
+}
+
+func report(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		dal.ListQuery
+		IncludeReviewed bool `json:"include_reviewed"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.SystemCode == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "'system_code' failed on the 'required' rule"))
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	recordList, info, err := dal.List[model.BreakGlass](ctx, database.DB, body.ListQuery, reportListSpec, func(db *gorm.DB) *gorm.DB {
+		if !body.IncludeReviewed {
+			db = db.Where("reviewed_at IS NULL")
+		}
+		return db
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query, err: %v", err)
+		return output.Failure(c, err)
+	}
+
+	list := make([]Grant, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, toGrant(&v))
+	}
+	return output.List(c, list, info)
+}
+
+func toGrant(record *model.BreakGlass) Grant {
+	return Grant{
+		ID:            record.ID,
+		Code:          record.Code,
+		UserCode:      record.UserCode,
+		ResourceIndex: record.ResourceIndex,
+		Action:        record.Action,
+		Justification: record.Justification,
+		RequestedBy:   record.RequestedBy,
+		BeginTime:     record.BeginTime,
+		EndTime:       record.EndTime,
+		ReviewedBy:    record.ReviewedBy,
+		ReviewNote:    record.ReviewNote,
+		ReviewedAt:    record.ReviewedAt,
+	}
+}
@@ -0,0 +1,123 @@
+package changefeed
+
+import (
+	"ac/bootstrap/logger"
+	"ac/custom/input"
+	"ac/custom/output"
+	"ac/service/changefeed"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultLimit   = 100
+	maxLimit       = 1000
+	defaultTimeout = 30
+
+	heartbeatInterval = 15 * time.Second
+)
+
+func RegisterRoutes(g *echo.Group) {
+	g.GET("/poll", poll)
+	g.GET("/stream", stream)
+}
+
+// poll is a long poll, it answers as soon as there are changes after after_id or when the
+// timeout (in seconds) expires. Clients resume by passing the returned last_id.
+func poll(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		AfterID int64 `query:"after_id" json:"after_id" validate:"gte=0"`
+		Limit   int   `query:"limit" json:"limit" validate:"gte=0,lte=1000"`
+		Timeout int   `query:"timeout" json:"timeout" validate:"gte=0,lte=60"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.Limit == 0 {
+		body.Limit = defaultLimit
+	}
+	if body.Timeout == 0 {
+		body.Timeout = defaultTimeout
+	}
+
+	waitCtx, cancel := context.WithTimeout(c.Request().Context(), time.Duration(body.Timeout)*time.Second)
+	defer cancel()
+	changefeed.Wait(waitCtx, body.AfterID)
+
+	list, err := changefeed.List(ctx, body.AfterID, body.Limit)
+	if err != nil {
+		logger.Errorf(ctx, "failed to list changes, err: %v, after id: %d", err, body.AfterID)
+		return output.Failure(c, err)
+	}
+	lastID := body.AfterID
+	if len(list) > 0 {
+		lastID = list[len(list)-1].ID
+	}
+	return output.Success(c, map[string]interface{}{
+		"last_id": lastID,
+		"list":    list,
+	})
+}
+
+// stream sends the changes as server-sent events, the event ID is the change ID. A reconnecting
+// EventSource resumes from its Last-Event-ID header, other clients may pass after_id.
+func stream(c echo.Context) error {
+	ctx := c.Request().Context()
+	afterID, err := strconv.ParseInt(c.Request().Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		afterID, err = strconv.ParseInt(c.QueryParam("after_id"), 10, 64)
+	}
+	if err != nil || afterID < 0 {
+		afterID = 0
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	reqCtx := c.Request().Context()
+	for {
+		list, err := changefeed.List(ctx, afterID, maxLimit)
+		if err != nil {
+			logger.Errorf(ctx, "failed to list changes, err: %v, after id: %d", err, afterID)
+			return nil
+		}
+		for _, v := range list {
+			data, err := sonic.MarshalString(v)
+			if err != nil {
+				logger.Errorf(ctx, "failed to marshal change, err: %v, id: %d", err, v.ID)
+				return nil
+			}
+			if _, err := fmt.Fprintf(resp, "id: %d\nevent: change\ndata: %s\n\n", v.ID, data); err != nil {
+				return nil
+			}
+			afterID = v.ID
+		}
+		if len(list) == maxLimit {
+			resp.Flush()
+			continue
+		}
+		// Comments keep proxies from closing an idle connection
+		if _, err := fmt.Fprint(resp, ": heartbeat\n\n"); err != nil {
+			return nil
+		}
+		resp.Flush()
+
+		waitCtx, cancel := context.WithTimeout(reqCtx, heartbeatInterval)
+		changefeed.Wait(waitCtx, afterID)
+		cancel()
+		if reqCtx.Err() != nil {
+			return nil
+		}
+	}
+}
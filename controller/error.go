@@ -1,17 +1,52 @@
 package controller
 
-// Predefined errors
+import "net/http"
+
+// Predefined errors. Every failure of the HTTP API is one of them, clients tell them apart by
+// the code and the HTTP status follows from it, see Status.
 var (
-	ErrSystemError    = NewError(1, "system error", "An unexpected system error occurred")
-	ErrInvalidInput   = NewError(2, "invalid input", "Please check your input")
-	ErrRecordNotFound = NewError(3, "record not found", "The record does not exist or is no longer available")
+	ErrSystemError       = NewError(1, "system error", "An unexpected system error occurred")
+	ErrInvalidInput      = NewError(2, "invalid input", "Please check your input")
+	ErrRecordNotFound    = NewError(3, "record not found", "The record does not exist or is no longer available")
+	ErrSodViolation      = NewError(4, "separation of duties violation", "The user cannot hold these roles at the same time")
+	ErrOutOfScope        = NewError(5, "out of administration scope", "You can only administer the resources you manage")
+	ErrConflict          = NewError(6, "conflict", "The data has been updated. Please refresh and try again")
+	ErrAlreadyExists     = NewError(7, "already exists", "The record already exists")
+	ErrDependencyFailure = NewError(8, "dependency failure", "A service the request depends on is unavailable. Please try again later")
+	ErrVersionMismatch   = NewError(9, "version mismatch", "The data has been updated by someone else. Please refresh and try again")
+	ErrUnauthorized      = NewError(10, "unauthorized", "Please provide valid credentials")
+	ErrRateLimited       = NewError(11, "rate limited", "Too many requests. Please slow down and try again later")
+	ErrRequestTooLarge   = NewError(12, "request too large", "The request body is too large")
 )
 
+// code2Status maps the codes of the predefined errors to their HTTP status.
+var code2Status = map[int]int{
+	ErrSystemError.Code:       http.StatusInternalServerError,
+	ErrInvalidInput.Code:      http.StatusBadRequest,
+	ErrRecordNotFound.Code:    http.StatusNotFound,
+	ErrSodViolation.Code:      http.StatusConflict,
+	ErrOutOfScope.Code:        http.StatusForbidden,
+	ErrConflict.Code:          http.StatusConflict,
+	ErrAlreadyExists.Code:     http.StatusConflict,
+	ErrDependencyFailure.Code: http.StatusServiceUnavailable,
+	ErrVersionMismatch.Code:   http.StatusPreconditionFailed,
+	ErrUnauthorized.Code:      http.StatusUnauthorized,
+	ErrRateLimited.Code:       http.StatusTooManyRequests,
+	ErrRequestTooLarge.Code:   http.StatusRequestEntityTooLarge,
+}
+
 // Error struct defines the structure of an error
 type Error struct {
-	Code int    `json:"code"` // Error code, identifies the type of error
-	Msg  string `json:"msg"`  // Error message, used for debugging and logging
-	Hint string `json:"hint"` // Hint message, provides user-friendly information
+	Code      int          `json:"code"`                 // Error code, identifies the type of error
+	Msg       string       `json:"msg"`                  // Error message, used for debugging and logging
+	Hint      string       `json:"hint"`                 // Hint message, provides user-friendly information
+	FieldList []FieldError `json:"field_list,omitempty"` // Invalid fields of the request, if any
+}
+
+// FieldError names an invalid field of the request by its JSON name.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
 }
 
 // Error implements the error interface, returning the error message
@@ -19,6 +54,21 @@ func (e *Error) Error() string {
 	return e.Msg
 }
 
+// Is reports whether target is an Error with the same code, so that errors decoded from a
+// response match the predefined errors with errors.Is
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Status returns the HTTP status of the error, 500 for an unknown code.
+func (e *Error) Status() int {
+	if status, ok := code2Status[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
 // NewError is a constructor function that creates a new Error instance
 func NewError(code int, msg, hint string) *Error {
 	return &Error{
@@ -31,17 +81,34 @@ func NewError(code int, msg, hint string) *Error {
 // WithMsg returns a new Error instance with a temporarily modified Msg field
 func (e *Error) WithMsg(newMsg string) *Error {
 	return &Error{
-		Code: e.Code,
-		Msg:  newMsg,
-		Hint: e.Hint,
+		Code:      e.Code,
+		Msg:       newMsg,
+		Hint:      e.Hint,
+		FieldList: e.FieldList,
 	}
 }
 
 // WithHint returns a new Error instance with a temporarily modified Hint field
 func (e *Error) WithHint(newHint string) *Error {
 	return &Error{
-		Code: e.Code,
-		Msg:  e.Msg,
-		Hint: newHint,
+		Code:      e.Code,
+		Msg:       e.Msg,
+		Hint:      newHint,
+		FieldList: e.FieldList,
+	}
+}
+
+// WithField returns a new Error instance naming an invalid field, the reason becomes the hint
+// unless the error already names a field
+func (e *Error) WithField(field, reason string) *Error {
+	hint := e.Hint
+	if len(e.FieldList) == 0 {
+		hint = reason
+	}
+	return &Error{
+		Code:      e.Code,
+		Msg:       e.Msg,
+		Hint:      hint,
+		FieldList: append(append([]FieldError{}, e.FieldList...), FieldError{Field: field, Reason: reason}),
 	}
 }
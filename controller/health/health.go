@@ -0,0 +1,81 @@
+package health
+
+import (
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/admin"
+	"ac/custom/input"
+	"ac/custom/output"
+	"ac/service/health"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterRoutes(g *echo.Group) {
+	g.GET("/healthz", liveness)
+	g.GET("/readyz", readiness)
+	g.GET("/debug/info", info, admin.Middleware())
+	g.GET("/debug/log-level", getLogLevel, admin.Middleware())
+	g.POST("/debug/log-level", setLogLevel, admin.Middleware())
+}
+
+// liveness answers as long as the process serves HTTP, it checks no dependency so that an
+// outage of the database does not get the instance restarted.
+func liveness(c echo.Context) error {
+	return output.Success(c, nil)
+}
+
+func readiness(c echo.Context) error {
+	ctx := c.Request().Context()
+	failed := health.Ready(ctx)
+	if len(failed) == 0 {
+		return output.Success(c, map[string]string{
+			health.CheckDatabase: "ok",
+			health.CheckEnforcer: "ok",
+			health.CheckSchema:   "ok",
+		})
+	}
+	nameList := make([]string, 0, len(failed))
+	for name := range failed {
+		nameList = append(nameList, name)
+	}
+	sort.Strings(nameList)
+	e := controller.ErrDependencyFailure
+	for _, name := range nameList {
+		logger.Errorf(ctx, "readiness check failed, check: %s, err: %v", name, failed[name])
+		e = e.WithField(name, failed[name].Error())
+	}
+	return output.Failure(c, e)
+}
+
+func info(c echo.Context) error {
+	ctx := c.Request().Context()
+	info, err := health.GetInfo(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get info, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, info)
+}
+
+func getLogLevel(c echo.Context) error {
+	return output.Success(c, map[string]logger.Level{"level": logger.GetLevel()})
+}
+
+// setLogLevel changes the level of the logs of this instance until it restarts.
+func setLogLevel(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		Level string `json:"level" validate:"required,oneof=debug info warn error"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	prev := logger.GetLevel()
+	if err := logger.SetLevel(logger.Level(body.Level)); err != nil {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("level", err.Error()))
+	}
+	logger.Warnf(ctx, "log level changed from %s to %s", prev, body.Level)
+	return output.Success(c, map[string]logger.Level{"level": logger.GetLevel()})
+}
@@ -4,6 +4,7 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/controller"
+	"ac/custom/admin"
 	"ac/custom/define"
 	"ac/custom/input"
 	"ac/custom/output"
@@ -11,12 +12,16 @@ import (
 	"ac/dal"
 	"ac/model"
 	"ac/service/casbin"
+	"ac/service/decision"
 	"ac/service/resource"
 	"ac/service/rule"
 	"ac/service/subject"
 	"ac/service/system"
+	"context"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -28,6 +33,9 @@ func RegisterRoutes(g *echo.Group) {
 	g.POST("/add", addItem)
 	g.POST("/delete", deleteItem)
 	g.GET("/query", query)
+	g.GET("/who-can", whoCan)
+	g.GET("/matrix", matrix)
+	g.POST("/what-if", whatIf)
 }
 
 type Permission struct {
@@ -37,28 +45,48 @@ type Permission struct {
 	EndTime       int64  `json:"end_time" validate:"required,gt=0"`
 }
 
-func addItem(ctx echo.Context) error {
+// authorize checks that the caller may administer every resource of the permissions. It
+// returns the error to respond with, nil when allowed.
+func authorize(ctx context.Context, systemCode string, permissionList []Permission) *controller.Error {
+	for _, v := range permissionList {
+		if err := admin.Authorize(ctx, systemCode, v.ResourceIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode     string       `json:"system_code" validate:"required,gt=0"`
 		SubjectCode    string       `json:"subject_code" validate:"required,gt=0"`
-		PermissionList []Permission `json:"permission_list" validate:"required,gt=0,dive,required"`
+		PermissionList []Permission `json:"permission_list" validate:"required,gt=0,max=500,dive,required"`
 		Inherit        bool         `json:"inherit"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	version, err := input.IfMatch(c)
+	if err != nil {
+		return output.Failure(c, err)
 	}
 
-	if ok, err := validateSystemAndSubject(ctx, body.SystemCode, body.SubjectCode); !ok {
+	if invalid, err := validateSystemAndSubject(ctx, body.SystemCode, body.SubjectCode); invalid != nil || err != nil {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate, err: %v, system code: %s, user code: %s", err, body.SystemCode, body.SubjectCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, invalid)
 	}
 
 	tmpPermissionList, err := validatePermissionList(ctx, body.SystemCode, body.PermissionList)
 	if err != nil {
 		logger.Errorf(ctx, "failed to validate, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
+	}
+	if e := authorize(ctx, body.SystemCode, tmpPermissionList); e != nil {
+		return output.Failure(c, e)
 	}
 
 	ruleToAdd := make([]rule.Rule, 0, len(tmpPermissionList))
@@ -67,55 +95,69 @@ func addItem(ctx echo.Context) error {
 		bt := time.Unix(v.BeginTime, 0).UTC()
 		et := time.Unix(v.EndTime, 0).UTC()
 		ruleToAdd = append(ruleToAdd, rule.Rule{
-			V0: body.SubjectCode,
-			V1: body.SystemCode + "/" + v.ResourceIndex,
-			V2: v.Action,
-			V3: bt,
-			V4: et,
+			PType: model.PTypePolicy,
+			V0:    body.SubjectCode,
+			V1:    body.SystemCode + "/" + v.ResourceIndex,
+			V2:    v.Action,
+			V3:    bt,
+			V4:    et,
 		})
 		if body.Inherit {
 			ruleToAdd = append(ruleToAdd, rule.Rule{
-				V0: body.SubjectCode,
-				V1: body.SystemCode + "/" + v.ResourceIndex + "/*",
-				V2: v.Action,
-				V3: bt,
-				V4: et,
+				PType: model.PTypePolicy,
+				V0:    body.SubjectCode,
+				V1:    body.SystemCode + "/" + v.ResourceIndex + "/*",
+				V2:    v.Action,
+				V3:    bt,
+				V4:    et,
 			})
 		}
 	}
 
-	err = rule.Add(ctx, ruleToAdd)
+	err = rule.AddIfMatch(ctx, ruleToAdd, version)
 	if err != nil {
 		logger.Errorf(ctx, "failed to add permission, err: %v", err)
+		if errors.Is(err, rule.ErrVersionMismatch) {
+			return output.Failure(c, controller.ErrVersionMismatch)
+		}
 		if errors.Is(err, rule.ErrDuplicateRule) {
-			return output.Failure(ctx, controller.ErrSystemError.WithHint("User's permissions have been updated. Please refresh and try again."))
+			return output.Failure(c, controller.ErrConflict.WithHint("User's permissions have been updated. Please refresh and try again."))
 		}
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func deleteItem(ctx echo.Context) error {
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode     string       `json:"system_code" validate:"required,gt=0"`
 		SubjectCode    string       `json:"subject_code" validate:"required,gt=0"`
-		PermissionList []Permission `json:"permission_list" validate:"required,gt=0,dive,required"`
+		PermissionList []Permission `json:"permission_list" validate:"required,gt=0,max=500,dive,required"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	version, err := input.IfMatch(c)
+	if err != nil {
+		return output.Failure(c, err)
 	}
 
-	if ok, err := validateSystemAndSubject(ctx, body.SystemCode, body.SubjectCode); !ok {
+	if invalid, err := validateSystemAndSubject(ctx, body.SystemCode, body.SubjectCode); invalid != nil || err != nil {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate, err: %v, system code: %s, user code: %s", err, body.SystemCode, body.SubjectCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, invalid)
 	}
 
 	tmpPermissionList, err := validatePermissionList(ctx, body.SystemCode, body.PermissionList)
 	if err != nil {
 		logger.Errorf(ctx, "failed to validate, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
+	}
+	if e := authorize(ctx, body.SystemCode, tmpPermissionList); e != nil {
+		return output.Failure(c, e)
 	}
 
 	ruleToDelete := make([]rule.Rule, 0, len(tmpPermissionList))
@@ -124,52 +166,66 @@ func deleteItem(ctx echo.Context) error {
 		bt := time.Unix(v.BeginTime, 0).UTC()
 		et := time.Unix(v.EndTime, 0).UTC()
 		ruleToDelete = append(ruleToDelete, rule.Rule{
-			V0: body.SubjectCode,
-			V1: body.SystemCode + "/" + v.ResourceIndex,
-			V2: v.Action,
-			V3: bt,
-			V4: et,
+			PType: model.PTypePolicy,
+			V0:    body.SubjectCode,
+			V1:    body.SystemCode + "/" + v.ResourceIndex,
+			V2:    v.Action,
+			V3:    bt,
+			V4:    et,
 		})
 	}
 
-	err = rule.Delete(ctx, ruleToDelete)
+	err = rule.DeleteIfMatch(ctx, ruleToDelete, version)
 	if err != nil {
 		logger.Errorf(ctx, "failed to delete permission, err: %v", err)
+		if errors.Is(err, rule.ErrVersionMismatch) {
+			return output.Failure(c, controller.ErrVersionMismatch)
+		}
 		if errors.Is(err, rule.ErrRuleNotFound) {
-			return output.Failure(ctx, controller.ErrSystemError.WithHint("User's permissions have been updated. Please refresh and try again."))
+			return output.Failure(c, controller.ErrConflict.WithHint("User's permissions have been updated. Please refresh and try again."))
 		}
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func query(ctx echo.Context) error {
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		Page        int    `json:"page" validate:"required,gt=0"`
 		PageSize    int    `json:"page_size" validate:"required,gt=0"`
 		SystemCode  string `json:"system_code" validate:"required,gt=0"`
 		SubjectCode string `json:"subject_code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
-	if ok, err := validateSystemAndSubject(ctx, body.SystemCode, body.SubjectCode); !ok {
+	if invalid, err := validateSystemAndSubject(ctx, body.SystemCode, body.SubjectCode); invalid != nil || err != nil {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate, err: %v, system code: %s, user code: %s", err, body.SystemCode, body.SubjectCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, invalid)
+	}
+
+	// Read ahead of the rules, so that a change in between makes the version stale rather than
+	// the list
+	version, err := rule.Version(ctx, body.SystemCode, body.SubjectCode, model.PTypePolicy)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get version, err: %v", err)
+		return output.Failure(c, err)
 	}
 
-	enforcer, err := casbin.NewEnforcer(database.DB)
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
 	if err != nil {
 		logger.Errorf(ctx, "failed to create enforcer, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	ruleList, err := enforcer.GetImplicitPermissionsForUser(body.SubjectCode)
 	if err != nil {
 		logger.Errorf(ctx, "failed to get rule list, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	type Permission struct {
 		FromCode      string `json:"from_code"`
@@ -210,7 +266,7 @@ func query(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to get system list, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	systemCode2System := util.ToMap(systemList, func(obj model.System) string {
 		return obj.Code
@@ -218,14 +274,14 @@ func query(ctx echo.Context) error {
 	resouceCode2Resouce, err := resource.QueryResourceByCode(ctx, body.SystemCode, recourceCodeList)
 	if err != nil {
 		logger.Errorf(ctx, "failed to get resource, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	subjectList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
 		return db.Where(model.Subject{SystemCode: body.SystemCode}).Where("code IN ?", subjectCodeList)
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to get subject list, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	subjectCode2Subject := util.ToMap(subjectList, func(obj model.Subject) string {
 		return obj.Code
@@ -251,12 +307,213 @@ func query(ctx echo.Context) error {
 		v.ResourceName = strings.Join(pathNameList, "/")
 		list[i] = v
 	}
-	return output.Success(ctx, map[string]interface{}{
-		"list": list,
+	output.ETag(c, version)
+	return output.Success(c, map[string]interface{}{
+		"list":    list,
+		"version": version,
+	})
+}
+
+// whoCan is the reverse of query, it lists the users that may perform the action on the resource.
+func whoCan(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode    string `json:"system_code" validate:"required,gt=0"`
+		ResourceIndex string `json:"resource_index" validate:"required,gt=0"`
+		Action        string `json:"action" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, system code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+
+	list, err := decision.WhoCan(ctx, body.SystemCode, body.ResourceIndex, body.Action)
+	if err != nil {
+		switch {
+		case errors.Is(err, decision.ErrInvalidAction):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("action", "Invalid action"))
+		case errors.Is(err, decision.ErrInvalidResourceIndex):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("resource_index", "Invalid resource index"))
+		}
+		logger.Errorf(ctx, "failed to look up users, err: %v, system code: %s, resource index: %s", err, body.SystemCode, body.ResourceIndex)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, map[string]interface{}{
+		"total": len(list),
+		"list":  list,
+	})
+}
+
+// matrix streams the users x resources grid of the highest action per cell as CSV, or as TSV
+// with format=tsv. The file starts with a byte order mark so that spreadsheets read it as UTF-8.
+func matrix(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `query:"system_code" json:"system_code" validate:"required,gt=0"`
+		At         int64  `query:"at" json:"at" validate:"gte=0"`
+		Format     string `query:"format" json:"format" validate:"omitempty,oneof=csv tsv"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, system code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+
+	at := util.UTCNow()
+	if body.At > 0 {
+		at = time.Unix(body.At, 0).UTC()
+	}
+	contentType, extension, comma := "text/csv; charset=utf-8", "csv", ','
+	if body.Format == "tsv" {
+		contentType, extension, comma = "text/tab-separated-values; charset=utf-8", "tsv", '\t'
+	}
+	level2Action := make(map[uint8]string, len(define.ValidAction2Level))
+	for k, v := range define.ValidAction2Level {
+		level2Action[uint8(v)] = k
+	}
+
+	resp := c.Response()
+	writer := csv.NewWriter(resp)
+	writer.Comma = comma
+	rowCount := 0
+	writeHeader := func(columnList []decision.MatrixColumn) error {
+		resp.Header().Set(echo.HeaderContentType, contentType)
+		resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s.%s"`, body.SystemCode, at.Format("20060102150405"), extension))
+		resp.WriteHeader(http.StatusOK)
+		if _, err := resp.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return err
+		}
+		record := []string{"user_code", "user_name"}
+		for _, v := range columnList {
+			record = append(record, fmt.Sprintf("%s (%s)", v.ResourceName, v.ResourceIndex))
+		}
+		return writer.Write(record)
+	}
+	writeRow := func(row decision.MatrixRow) error {
+		record := make([]string, 0, len(row.LevelList)+2)
+		record = append(record, row.UserCode, row.UserName)
+		for _, v := range row.LevelList {
+			record = append(record, level2Action[v])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		if rowCount++; rowCount%100 == 0 {
+			writer.Flush()
+			resp.Flush()
+		}
+		return writer.Error()
+	}
+	if err := decision.Matrix(ctx, body.SystemCode, at, writeHeader, writeRow); err != nil {
+		logger.Errorf(ctx, "failed to export matrix, err: %v, system code: %s", err, body.SystemCode)
+		if !resp.Committed {
+			return output.Failure(c, err)
+		}
+		// The status is sent already, a truncated file is all that can be reported
+		return nil
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// whatIf previews the access users would gain or lose with the proposed rules, nothing is saved.
+func whatIf(c echo.Context) error {
+	ctx := c.Request().Context()
+	type UserRole struct {
+		UserCode string `json:"user_code" validate:"required,gt=0"`
+		RoleCode string `json:"role_code" validate:"required,gt=0"`
+	}
+	type Policy struct {
+		SubjectCode string `json:"subject_code" validate:"required,gt=0"`
+		Permission
+	}
+	body := struct {
+		SystemCode         string     `json:"system_code" validate:"required,gt=0"`
+		At                 int64      `json:"at" validate:"gte=0"`
+		PolicyAddList      []Policy   `json:"policy_add_list" validate:"max=500,dive,required"`
+		PolicyDeleteList   []Policy   `json:"policy_delete_list" validate:"max=500,dive,required"`
+		UserRoleAddList    []UserRole `json:"user_role_add_list" validate:"max=500,dive,required"`
+		UserRoleDeleteList []UserRole `json:"user_role_delete_list" validate:"max=500,dive,required"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, system code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+
+	toPolicyList := func(list []Policy) []decision.ProposedPolicy {
+		policyList := make([]decision.ProposedPolicy, 0, len(list))
+		for _, v := range list {
+			policyList = append(policyList, decision.ProposedPolicy{
+				SubjectCode:   v.SubjectCode,
+				ResourceIndex: v.ResourceIndex,
+				Action:        v.Action,
+				BeginTime:     time.Unix(v.BeginTime, 0).UTC(),
+				EndTime:       time.Unix(v.EndTime, 0).UTC(),
+			})
+		}
+		return policyList
+	}
+	toUserRoleList := func(list []UserRole) []decision.ProposedUserRole {
+		userRoleList := make([]decision.ProposedUserRole, 0, len(list))
+		for _, v := range list {
+			userRoleList = append(userRoleList, decision.ProposedUserRole{UserCode: v.UserCode, RoleCode: v.RoleCode})
+		}
+		return userRoleList
+	}
+	at := util.UTCNow()
+	if body.At > 0 {
+		at = time.Unix(body.At, 0).UTC()
+	}
+
+	list, err := decision.WhatIf(ctx, body.SystemCode, decision.Proposal{
+		PolicyAddList:      toPolicyList(body.PolicyAddList),
+		PolicyDeleteList:   toPolicyList(body.PolicyDeleteList),
+		UserRoleAddList:    toUserRoleList(body.UserRoleAddList),
+		UserRoleDeleteList: toUserRoleList(body.UserRoleDeleteList),
+	}, at)
+	if err != nil {
+		switch {
+		case errors.Is(err, decision.ErrInvalidAction):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("action", "Invalid action"))
+		case errors.Is(err, decision.ErrInvalidResourceIndex):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("resource_index", "Invalid resource index"))
+		case errors.Is(err, decision.ErrInvalidSubject), errors.Is(err, decision.ErrInvalidUser), errors.Is(err, decision.ErrInvalidRole):
+			return output.Failure(c, controller.ErrInvalidInput.WithField("subject_code", "Invalid subject code"))
+		case errors.Is(err, decision.ErrRuleExists):
+			return output.Failure(c, controller.ErrConflict.WithHint("Some rules to add already exist"))
+		case errors.Is(err, decision.ErrRuleNotFound):
+			return output.Failure(c, controller.ErrConflict.WithHint("Some rules to delete do not exist"))
+		}
+		logger.Errorf(ctx, "failed to simulate, err: %v, system code: %s", err, body.SystemCode)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, map[string]interface{}{
+		"total": len(list),
+		"list":  list,
 	})
 }
 
-func validatePermissionList(ctx echo.Context, systemCode string, permissionList []Permission) ([]Permission, error) {
+func validatePermissionList(ctx context.Context, systemCode string, permissionList []Permission) ([]Permission, error) {
 	seen := make(map[string]struct{})
 	filtered := make([]Permission, 0, len(permissionList))
 
@@ -303,27 +560,28 @@ func validatePermissionList(ctx echo.Context, systemCode string, permissionList
 		}
 
 		if !allExist {
-			return nil, fmt.Errorf("invalid resource in ResourceIndex: %s", v.ResourceIndex)
+			return nil, controller.ErrInvalidInput.WithField("permission_list", fmt.Sprintf("Invalid resource in resource_index: %s", v.ResourceIndex))
 		}
 	}
 
 	return filtered, nil
 }
 
-func validateSystemAndSubject(ctx echo.Context, systemCode, subjectCode string) (bool, error) {
+// validateSystemAndSubject returns the error naming the unknown code, nil when both are known.
+func validateSystemAndSubject(ctx context.Context, systemCode, subjectCode string) (*controller.Error, error) {
 	if ok, err := system.Validate(ctx, systemCode); !ok {
 		if err != nil {
-			return false, fmt.Errorf("failed to validate system, err: %w", err)
+			return nil, fmt.Errorf("failed to validate system, err: %w", err)
 		}
-		return false, nil
+		return controller.ErrInvalidInput.WithField("system_code", "Invalid system code"), nil
 	}
 
 	if ok, err := subject.Validate(ctx, systemCode, subjectCode); !ok {
 		if err != nil {
-			return false, fmt.Errorf("failed to validate subject, err: %w", err)
+			return nil, fmt.Errorf("failed to validate subject, err: %w", err)
 		}
-		return false, nil
+		return controller.ErrInvalidInput.WithField("subject_code", "Invalid subject code"), nil
 	}
 
-	return true, nil
+	return nil, nil
 }
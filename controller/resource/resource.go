@@ -4,15 +4,19 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/controller"
+	"ac/custom/admin"
 	"ac/custom/define"
 	"ac/custom/input"
 	"ac/custom/output"
 	"ac/custom/util"
 	"ac/dal"
 	"ac/model"
+	"ac/service/delegation"
 	"ac/service/resource"
+	"context"
 
 	"ac/service/system"
+	"ac/service/webhook"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -25,6 +29,7 @@ type Resource struct {
 	Description string    `json:"description"`
 	SystemCode  string    `json:"system_code"`
 	Code        string    `json:"code"`
+	ParentCode  string    `json:"parent_code"`
 	ModifiedBy  string    `json:"modified_by"`
 	UpdatedAt   time.Time `json:"update_at"`
 }
@@ -35,53 +40,90 @@ func RegisterRoutes(g *echo.Group) {
 	g.POST("/delete", deleteItem)
 	g.GET("/query", query)
 	g.GET("/get", GetItem)
+	g.GET("/lookup", lookup)
 }
 
-func addItem(ctx echo.Context) error {
+// authorize checks that the caller may administer below the resource, an empty code stands for
+// the top level of the system. It returns the error to respond with, nil when allowed.
+func authorize(ctx context.Context, systemCode, code string) *controller.Error {
+	resourceIndex := ""
+	// An unscoped caller may administer anything, there is no index to look up
+	if code != "" && delegation.Caller(ctx) != "" {
+		var err error
+		if resourceIndex, err = resource.Index(ctx, systemCode, code); err != nil {
+			logger.Errorf(ctx, "failed to get resource index, err: %v, system code: %s, code: %s", err, systemCode, code)
+			return controller.ErrSystemError
+		}
+	}
+	return admin.Authorize(ctx, systemCode, resourceIndex)
+}
+
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode  string `json:"system_code" validate:"required,gt=0"`
 		Name        string `json:"name" validate:"required,gt=0"`
 		Description string `json:"description"`
 		ParentCode  string `json:"parent_code"`
+		Code        string `json:"code"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
 	if body.ParentCode != "" {
 		if ok, err := resource.Validate(ctx, body.SystemCode, body.ParentCode); !ok {
 			if err != nil {
 				logger.Errorf(ctx, "failed to validate resource, err: %v, system code: %s, code: %s", err, body.SystemCode, body.ParentCode)
+				return output.Failure(c, err)
 			}
-			return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid resource code"))
+			return output.Failure(c, controller.ErrInvalidInput.WithField("parent_code", "Invalid resource code"))
 		}
 	}
+	if e := authorize(ctx, body.SystemCode, body.ParentCode); e != nil {
+		return output.Failure(c, e)
+	}
 
-	var code string
-	for i := 0; i < 3; i++ {
-		tmpCode := util.GenerateCode(define.PrefixResource)
-
-		ok, err := resource.IsCodeAvailable(ctx, tmpCode)
+	code := body.Code
+	if code != "" {
+		if err := util.ValidateCode(define.PrefixResource, code); err != nil {
+			return output.Failure(c, controller.ErrInvalidInput.WithField("code", err.Error()))
+		}
+		ok, err := resource.IsCodeAvailable(ctx, code)
 		if err != nil {
 			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, code)
-			return output.Failure(ctx, controller.ErrSystemError)
+			return output.Failure(c, err)
 		}
-		if ok {
-			code = tmpCode
-			break
+		if !ok {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("code", "The code is already in use"))
 		}
-	}
+	} else {
+		for i := 0; i < 3; i++ {
+			tmpCode := util.GenerateCode(define.PrefixResource)
 
-	if code == "" {
-		logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+			ok, err := resource.IsCodeAvailable(ctx, tmpCode)
+			if err != nil {
+				logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+				return output.Failure(c, err)
+			}
+			if ok {
+				code = tmpCode
+				break
+			}
+		}
+
+		if code == "" {
+			logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+			return output.Failure(c, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+		}
 	}
 
 	now := util.UTCNow()
@@ -94,15 +136,22 @@ func addItem(ctx echo.Context) error {
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
-	if err := dal.NewRepo[model.Resource]().Insert(ctx, database.DB, newValue); err != nil {
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Resource]().Insert(ctx, tx, newValue); err != nil {
+			return err
+		}
+		return webhook.EnqueueResource(ctx, tx, newValue, webhook.ActionCreated)
+	})
+	if err != nil {
 		logger.Errorf(ctx, "failed to insert record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 
-	return output.Success(ctx, Resource{
+	return output.Success(c, Resource{
 		ID:          newValue.ID,
 		SystemCode:  newValue.SystemCode,
 		Code:        newValue.Code,
+		ParentCode:  newValue.ParentCode,
 		Name:        newValue.Name,
 		Description: newValue.Description,
 		ModifiedBy:  newValue.ModifiedBy,
@@ -110,7 +159,8 @@ func addItem(ctx echo.Context) error {
 	})
 }
 
-func updateItem(ctx echo.Context) error {
+func updateItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode  string `json:"system_code" validate:"required,gt=0"`
 		Code        string `json:"code" validate:"required,gt=0"`
@@ -118,30 +168,42 @@ func updateItem(ctx echo.Context) error {
 		Description string `json:"description"`
 		ParentCode  string `json:"parent_code"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
 	if ok, err := resource.Validate(ctx, body.SystemCode, body.Code); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate resource, err: %v, system code: %s, code: %s", err, body.SystemCode, body.ParentCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid resource code"))
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid resource code"))
 	}
 
 	if body.ParentCode != "" {
 		if ok, err := resource.Validate(ctx, body.SystemCode, body.ParentCode); !ok {
 			if err != nil {
 				logger.Errorf(ctx, "failed to validate resource, err: %v, system code: %s, code: %s", err, body.SystemCode, body.ParentCode)
+				return output.Failure(c, err)
 			}
-			return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid resource code"))
+			return output.Failure(c, controller.ErrInvalidInput.WithField("parent_code", "Invalid resource code"))
+		}
+	}
+	// Moving a resource needs the scope over both places
+	if e := authorize(ctx, body.SystemCode, body.Code); e != nil {
+		return output.Failure(c, e)
+	}
+	if body.ParentCode != "" {
+		if e := authorize(ctx, body.SystemCode, body.ParentCode); e != nil {
+			return output.Failure(c, e)
 		}
 	}
 
@@ -149,73 +211,94 @@ func updateItem(ctx echo.Context) error {
 	newValue := &model.Resource{
 		Name:        body.Name,
 		Description: body.Description,
-		ParentCode:  body.Code,
+		ParentCode:  body.ParentCode,
 		UpdatedAt:   now,
 	}
-	if err := dal.NewRepo[model.Resource]().Update(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
-		return db.Where(model.Resource{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
-	}); err != nil {
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Resource]().Update(ctx, tx, newValue, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Resource{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
+		}); err != nil {
+			return err
+		}
+		return webhook.EnqueueResource(ctx, tx, &model.Resource{SystemCode: body.SystemCode, Code: body.Code, Name: body.Name, ParentCode: body.ParentCode}, webhook.ActionUpdated)
+	})
+	if err != nil {
 		logger.Errorf(ctx, "failed to update record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func deleteItem(ctx echo.Context) error {
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode string `json:"system_code" validate:"required,gt=0"`
 		Code       string `json:"code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
 	if ok, err := resource.Validate(ctx, body.SystemCode, body.Code); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate resource, err: %v, system code: %s, code: %s", err, body.SystemCode, body.Code)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid resource code"))
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid resource code"))
+	}
+	if e := authorize(ctx, body.SystemCode, body.Code); e != nil {
+		return output.Failure(c, e)
 	}
 
 	now := util.UTCNow()
 	newValue := &model.Resource{
 		DeletedAt: &now,
 	}
-	if err := dal.NewRepo[model.Resource]().Update(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
-		return db.Where(model.Resource{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
-	}); err != nil {
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Resource]().Update(ctx, tx, newValue, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Resource{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
+		}); err != nil {
+			return err
+		}
+		return webhook.EnqueueResource(ctx, tx, &model.Resource{SystemCode: body.SystemCode, Code: body.Code}, webhook.ActionDeleted)
+	})
+	if err != nil {
 		logger.Errorf(ctx, "failed to update record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func query(ctx echo.Context) error {
-	body := struct {
-		Page     int `json:"page"`
-		PageSize int `json:"page_size"`
-	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
-	}
+// listSpec is what the list of resources supports.
+var listSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+		dal.FilterNamePrefix: "name",
+		dal.FilterParentCode: "parent_code",
+		dal.FilterUpdatedAt:  "updated_at",
+	},
+	SortKeys: []string{"name", "updated_at"},
+}
 
-	recordList, err := dal.NewRepo[model.Resource]().QueryList(ctx, database.DB, dal.Paginate(body.Page, body.PageSize))
-	if err != nil {
-		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := dal.ListQuery{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
-	count, err := dal.NewRepo[model.Resource]().Count(ctx, database.DB)
+	recordList, info, err := dal.List[model.Resource](ctx, database.DB, body, listSpec)
 	if err != nil {
-		logger.Errorf(ctx, "failed to count, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		logger.Errorf(ctx, "failed to query, err: %v", err)
+		return output.Failure(c, err)
 	}
 	list := make([]Resource, 0, len(recordList))
 	for _, v := range recordList {
@@ -224,24 +307,23 @@ func query(ctx echo.Context) error {
 			Name:       v.Name,
 			SystemCode: v.SystemCode,
 			Code:       v.Code,
+			ParentCode: v.ParentCode,
 			ModifiedBy: v.ModifiedBy,
 			UpdatedAt:  v.UpdatedAt,
 		})
 	}
 
-	return output.Success(ctx, map[string]interface{}{
-		"total": count,
-		"list":  list,
-	})
+	return output.List(c, list, info)
 }
 
-func GetItem(ctx echo.Context) error {
+func GetItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode string `json:"system_code" validate:"required,gt=0"`
 		Code       string `json:"code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
 	record, err := dal.NewRepo[model.Resource]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
@@ -249,19 +331,56 @@ func GetItem(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	} else if record == nil {
 		logger.Infof(ctx, "failed to query, no matching record found, code: %s", body.Code)
-		return output.Failure(ctx, controller.ErrRecordNotFound)
+		return output.Failure(c, controller.ErrRecordNotFound)
 	}
 
-	return output.Success(ctx, Resource{
+	return output.Success(c, Resource{
 		ID:          record.ID,
 		Name:        record.Name,
 		Description: record.Description,
 		SystemCode:  record.SystemCode,
 		Code:        record.Code,
+		ParentCode:  record.ParentCode,
 		ModifiedBy:  record.ModifiedBy,
 		UpdatedAt:   record.UpdatedAt,
 	})
 }
+
+func lookup(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `json:"system_code" validate:"required,gt=0"`
+		Name       string `json:"name" validate:"required,gt=0"`
+		ParentCode string `json:"parent_code"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+
+	resourceList, err := resource.QueryByName(ctx, body.SystemCode, body.Name, body.ParentCode)
+	if err != nil {
+		logger.Errorf(ctx, "failed to query resource by name, err: %v, system code: %s, name: %s", err, body.SystemCode, body.Name)
+		return output.Failure(c, err)
+	}
+
+	list := make([]Resource, 0, len(resourceList))
+	for _, v := range resourceList {
+		list = append(list, Resource{
+			ID:          v.ID,
+			Name:        v.Name,
+			Description: v.Description,
+			SystemCode:  v.SystemCode,
+			Code:        v.Code,
+			ParentCode:  v.ParentCode,
+			ModifiedBy:  v.ModifiedBy,
+			UpdatedAt:   v.UpdatedAt,
+		})
+	}
+
+	return output.Success(c, map[string]interface{}{
+		"list": list,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/controller"
+	"ac/custom/admin"
 
 	"ac/custom/define"
 	"ac/custom/input"
@@ -14,6 +15,7 @@ import (
 
 	"ac/service/subject"
 	"ac/service/system"
+	"ac/service/webhook"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -26,6 +28,7 @@ type Role struct {
 	Description string    `json:"description"`
 	SystemCode  string    `json:"system_code"`
 	Code        string    `json:"code"`
+	ExternalID  string    `json:"external_id"`
 	ModifiedBy  string    `json:"modified_by"`
 	UpdatedAt   time.Time `json:"update_at"`
 }
@@ -36,43 +39,76 @@ func RegisterRoutes(g *echo.Group) {
 	g.POST("/delete", deleteItem)
 	g.GET("/query", query)
 	g.GET("/get", GetItem)
+	g.GET("/lookup", lookup)
 }
 
-func addItem(ctx echo.Context) error {
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode  string `json:"system_code" validate:"required,gt=0"`
 		Name        string `json:"name" validate:"required,gt=0"`
 		Description string `json:"description"`
+		Code        string `json:"code"`
+		ExternalID  string `json:"external_id" validate:"max=100"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
-	var code string
-	for i := 0; i < 3; i++ {
-		tmpCode := util.GenerateCode(define.PrefixRole)
-
-		ok, err := subject.IsRoleCodeAvailable(ctx, tmpCode)
+	code := body.Code
+	if code != "" {
+		if err := util.ValidateCode(define.PrefixRole, code); err != nil {
+			return output.Failure(c, controller.ErrInvalidInput.WithField("code", err.Error()))
+		}
+		ok, err := subject.IsRoleCodeAvailable(ctx, code)
 		if err != nil {
 			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, code)
-			return output.Failure(ctx, controller.ErrSystemError)
+			return output.Failure(c, err)
+		}
+		if !ok {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("code", "The code is already in use"))
 		}
-		if ok {
-			code = tmpCode
-			break
+	} else {
+		for i := 0; i < 3; i++ {
+			tmpCode := util.GenerateCode(define.PrefixRole)
+
+			ok, err := subject.IsRoleCodeAvailable(ctx, tmpCode)
+			if err != nil {
+				logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+				return output.Failure(c, err)
+			}
+			if ok {
+				code = tmpCode
+				break
+			}
+		}
+
+		if code == "" {
+			logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+			return output.Failure(c, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
 		}
 	}
 
-	if code == "" {
-		logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+	if body.ExternalID != "" {
+		ok, err := subject.IsRoleExternalIDAvailable(ctx, body.SystemCode, body.ExternalID)
+		if err != nil {
+			logger.Errorf(ctx, "failed to check external id availability, err: %v, system code: %s, external id: %s", err, body.SystemCode, body.ExternalID)
+			return output.Failure(c, err)
+		}
+		if !ok {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("external_id", "The external id is already in use"))
+		}
 	}
 
 	now := util.UTCNow()
@@ -82,107 +118,160 @@ func addItem(ctx echo.Context) error {
 		Name:        body.Name,
 		Description: body.Description,
 		Code:        code,
+		ExternalID:  body.ExternalID,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
-	if err := dal.NewRepo[model.Subject]().Insert(ctx, database.DB, newValue); err != nil {
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Subject]().Insert(ctx, tx, newValue); err != nil {
+			return err
+		}
+		return webhook.EnqueueSubject(ctx, tx, newValue, webhook.ActionCreated)
+	})
+	if err != nil {
 		logger.Errorf(ctx, "failed to insert record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, Role{ID: newValue.ID, SystemCode: newValue.SystemCode, Code: newValue.Code})
+	return output.Success(c, Role{ID: newValue.ID, SystemCode: newValue.SystemCode, Code: newValue.Code, ExternalID: newValue.ExternalID})
 }
 
-func updateItem(ctx echo.Context) error {
+func updateItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode  string `json:"system_code" validate:"required,gt=0"`
 		Code        string `json:"code" validate:"required,gt=0"`
 		Name        string `json:"name" validate:"required,gt=0"`
 		Description string `json:"description"`
+		ExternalID  string `json:"external_id" validate:"max=100"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
 	if ok, err := subject.ValidateRole(ctx, body.SystemCode, body.Code); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate role, err: %v, system code: %s, user code: %s", err, body.SystemCode, body.Code)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid role code"))
+	}
+
+	if body.ExternalID != "" {
+		record, err := subject.QueryRoleByExternalID(ctx, body.SystemCode, body.ExternalID)
+		if err != nil {
+			logger.Errorf(ctx, "failed to query role by external id, err: %v, system code: %s, external id: %s", err, body.SystemCode, body.ExternalID)
+			return output.Failure(c, err)
+		}
+		if record != nil && record.Code != body.Code {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("external_id", "The external id is already in use"))
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid role code"))
 	}
 
 	now := util.UTCNow()
 	newValue := &model.Subject{
 		Name:        body.Name,
 		Description: body.Description,
+		ExternalID:  body.ExternalID,
 		UpdatedAt:   now,
 	}
-	if err := dal.NewRepo[model.Subject]().Update(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
-		return db.Where(model.Subject{SystemCode: body.SystemCode, Code: body.Code, Type: model.SubjectTypeRole}).Limit(1)
-	}); err != nil {
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Subject]().Update(ctx, tx, newValue, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Subject{SystemCode: body.SystemCode, Code: body.Code, Type: model.SubjectTypeRole}).Limit(1)
+		}); err != nil {
+			return err
+		}
+		return webhook.EnqueueSubject(ctx, tx, &model.Subject{SystemCode: body.SystemCode, Type: model.SubjectTypeRole, Code: body.Code, Name: body.Name, ExternalID: body.ExternalID}, webhook.ActionUpdated)
+	})
+	if err != nil {
 		logger.Errorf(ctx, "failed to update record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func deleteItem(ctx echo.Context) error {
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode string `json:"system_code" validate:"required,gt=0"`
 		Code       string `json:"code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
 	if ok, err := subject.ValidateRole(ctx, body.SystemCode, body.Code); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate role, err: %v, system code: %s, user code: %s", err, body.SystemCode, body.Code)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid role code"))
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid role code"))
 	}
 
 	now := util.UTCNow()
 	newValue := &model.Subject{
 		DeletedAt: &now,
 	}
-	if err := dal.NewRepo[model.Subject]().Update(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
-		return db.Where(model.Subject{SystemCode: body.SystemCode, Code: body.Code, Type: model.SubjectTypeRole}).Limit(1)
-	}); err != nil {
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Subject]().Update(ctx, tx, newValue, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Subject{SystemCode: body.SystemCode, Code: body.Code, Type: model.SubjectTypeRole}).Limit(1)
+		}); err != nil {
+			return err
+		}
+		return webhook.EnqueueSubject(ctx, tx, &model.Subject{SystemCode: body.SystemCode, Type: model.SubjectTypeRole, Code: body.Code}, webhook.ActionDeleted)
+	})
+	if err != nil {
 		logger.Errorf(ctx, "failed to update record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func query(ctx echo.Context) error {
-	body := struct {
-		Page     int `json:"page"`
-		PageSize int `json:"page_size"`
-	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+// listSpec is what the list of roles supports, the name filter is served by
+// idx_system_code_name_type along with the system code.
+var listSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+		dal.FilterNamePrefix: "name",
+		dal.FilterUpdatedAt:  "updated_at",
+	},
+	SortKeys: []string{"name", "updated_at"},
+}
+
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := dal.ListQuery{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
-	recordList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+	recordList, info, err := dal.List[model.Subject](ctx, database.DB, body, listSpec, func(db *gorm.DB) *gorm.DB {
 		return db.Where(model.Subject{Type: model.SubjectTypeRole})
-	}, dal.Paginate(body.Page, body.PageSize))
+	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 
 	list := make([]Role, 0, len(recordList))
@@ -192,23 +281,23 @@ func query(ctx echo.Context) error {
 			Name:       v.Name,
 			SystemCode: v.SystemCode,
 			Code:       v.Code,
+			ExternalID: v.ExternalID,
 			ModifiedBy: v.ModifiedBy,
 			UpdatedAt:  v.UpdatedAt,
 		})
 	}
 
-	return output.Success(ctx, map[string]interface{}{
-		"list": list,
-	})
+	return output.List(c, list, info)
 }
 
-func GetItem(ctx echo.Context) error {
+func GetItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode string `json:"system_code" validate:"required,gt=0"`
 		Code       string `json:"code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
 	record, err := dal.NewRepo[model.Subject]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
@@ -216,20 +305,73 @@ func GetItem(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query role, err: %v, system code: %s, code: %s", err, body.SystemCode, body.Code)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	if record == nil {
 		logger.Infof(ctx, "failed to query, no matching record found, system code: %s, code: %s", body.SystemCode, body.Code)
-		return output.Failure(ctx, controller.ErrRecordNotFound)
+		return output.Failure(c, controller.ErrRecordNotFound)
 	}
 
-	return output.Success(ctx, Role{
+	return output.Success(c, Role{
 		ID:          record.ID,
 		Name:        record.Name,
 		Description: record.Description,
 		SystemCode:  record.SystemCode,
 		Code:        record.Code,
+		ExternalID:  record.ExternalID,
 		ModifiedBy:  record.ModifiedBy,
 		UpdatedAt:   record.UpdatedAt,
 	})
 }
+
+func lookup(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `json:"system_code" validate:"required,gt=0"`
+		Name       string `json:"name"`
+		ExternalID string `json:"external_id"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if (body.Name == "") == (body.ExternalID == "") {
+		return output.Failure(c, controller.ErrInvalidInput.WithMsg("exactly one of 'name' and 'external_id' must be provided"))
+	}
+
+	var subjectList []subject.Subject
+	if body.ExternalID != "" {
+		record, err := subject.QueryRoleByExternalID(ctx, body.SystemCode, body.ExternalID)
+		if err != nil {
+			logger.Errorf(ctx, "failed to query role by external id, err: %v, system code: %s, external id: %s", err, body.SystemCode, body.ExternalID)
+			return output.Failure(c, err)
+		}
+		if record != nil {
+			subjectList = append(subjectList, *record)
+		}
+	} else {
+		var err error
+		subjectList, err = subject.QueryRoleByName(ctx, body.SystemCode, body.Name)
+		if err != nil {
+			logger.Errorf(ctx, "failed to query role by name, err: %v, system code: %s, name: %s", err, body.SystemCode, body.Name)
+			return output.Failure(c, err)
+		}
+	}
+
+	list := make([]Role, 0, len(subjectList))
+	for _, v := range subjectList {
+		list = append(list, Role{
+			ID:          v.ID,
+			Name:        v.Name,
+			Description: v.Description,
+			SystemCode:  v.SystemCode,
+			Code:        v.Code,
+			ExternalID:  v.ExternalID,
+			ModifiedBy:  v.ModifiedBy,
+			UpdatedAt:   v.UpdatedAt,
+		})
+	}
+
+	return output.Success(c, map[string]interface{}{
+		"list": list,
+	})
+}
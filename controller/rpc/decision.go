@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"ac/api/decision"
+	"ac/bootstrap/logger"
+	"context"
+	"errors"
+
+	decisionService "ac/service/decision"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxBatchCheckSize = 1000
+
+type decisionServer struct {
+	decision.UnimplementedDecisionServiceServer
+}
+
+func (s *decisionServer) Check(ctx context.Context, req *decision.CheckRequest) (*decision.CheckResponse, error) {
+	if err := validateCheckRequest(req); err != nil {
+		return nil, err
+	}
+	authorized, err := decisionService.Check(ctx, toRequest(req))
+	if err != nil {
+		logger.Errorf(ctx, "failed to check, err: %v, system code: %s, user code: %s, resource index: %s", err, req.GetSystemCode(), req.GetUserCode(), req.GetResourceIndex())
+		return nil, toStatus(err)
+	}
+	return &decision.CheckResponse{Authorized: authorized}, nil
+}
+
+func (s *decisionServer) BatchCheck(ctx context.Context, req *decision.BatchCheckRequest) (*decision.BatchCheckResponse, error) {
+	if len(req.GetChecks()) == 0 || len(req.GetChecks()) > maxBatchCheckSize {
+		return nil, status.Errorf(codes.InvalidArgument, "checks must contain between 1 and %d requests", maxBatchCheckSize)
+	}
+	for _, v := range req.GetChecks() {
+		if err := validateCheckRequest(v); err != nil {
+			return nil, err
+		}
+	}
+
+	reqList := make([]decisionService.Request, 0, len(req.GetChecks()))
+	for _, v := range req.GetChecks() {
+		reqList = append(reqList, toRequest(v))
+	}
+	resultList, err := decisionService.BatchCheck(ctx, reqList)
+	if err != nil {
+		logger.Errorf(ctx, "failed to batch check, err: %v", err)
+		return nil, toStatus(err)
+	}
+
+	resp := &decision.BatchCheckResponse{Results: make([]*decision.BatchCheckResult, 0, len(resultList))}
+	for i, v := range resultList {
+		result := &decision.BatchCheckResult{Request: req.GetChecks()[i], Authorized: v.Authorized}
+		if v.Err != nil {
+			result.Error = status.Convert(toStatus(v.Err)).Message()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+func (s *decisionServer) ListPermissions(ctx context.Context, req *decision.ListPermissionsRequest) (*decision.ListPermissionsResponse, error) {
+	if req.GetSystemCode() == "" || req.GetUserCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "system_code and user_code are required")
+	}
+	permissionList, err := decisionService.ListPermissions(ctx, req.GetSystemCode(), req.GetUserCode())
+	if err != nil {
+		logger.Errorf(ctx, "failed to list permissions, err: %v, system code: %s, user code: %s", err, req.GetSystemCode(), req.GetUserCode())
+		return nil, toStatus(err)
+	}
+	resp := &decision.ListPermissionsResponse{Permissions: make([]*decision.Permission, 0, len(permissionList))}
+	for _, v := range permissionList {
+		resp.Permissions = append(resp.Permissions, toPermission(&v))
+	}
+	return resp, nil
+}
+
+func (s *decisionServer) Explain(ctx context.Context, req *decision.CheckRequest) (*decision.ExplainResponse, error) {
+	if err := validateCheckRequest(req); err != nil {
+		return nil, err
+	}
+	authorized, permission, err := decisionService.Explain(ctx, toRequest(req))
+	if err != nil {
+		logger.Errorf(ctx, "failed to explain, err: %v, system code: %s, user code: %s, resource index: %s", err, req.GetSystemCode(), req.GetUserCode(), req.GetResourceIndex())
+		return nil, toStatus(err)
+	}
+	resp := &decision.ExplainResponse{Authorized: authorized}
+	if permission != nil {
+		resp.MatchedPermission = toPermission(permission)
+	}
+	return resp, nil
+}
+
+func validateCheckRequest(req *decision.CheckRequest) error {
+	if req.GetSystemCode() == "" || req.GetUserCode() == "" || req.GetResourceIndex() == "" || req.GetAction() == "" {
+		return status.Error(codes.InvalidArgument, "system_code, user_code, resource_index and action are required")
+	}
+	return nil
+}
+
+// toStatus maps service errors onto gRPC status codes without leaking internal details.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, decisionService.ErrInvalidAction):
+		return status.Error(codes.InvalidArgument, "Invalid action")
+	case errors.Is(err, decisionService.ErrInvalidResourceIndex):
+		return status.Error(codes.InvalidArgument, "Invalid resource index")
+	case errors.Is(err, decisionService.ErrInvalidUser):
+		return status.Error(codes.NotFound, "Invalid user code")
+	default:
+		return status.Error(codes.Internal, "An unexpected system error occurred")
+	}
+}
+
+func toRequest(req *decision.CheckRequest) decisionService.Request {
+	return decisionService.Request{
+		SystemCode:    req.GetSystemCode(),
+		UserCode:      req.GetUserCode(),
+		ResourceIndex: req.GetResourceIndex(),
+		Action:        req.GetAction(),
+	}
+}
+
+func toPermission(permission *decisionService.Permission) *decision.Permission {
+	return &decision.Permission{
+		FromCode:      permission.FromCode,
+		ResourceIndex: permission.ResourceIndex,
+		Action:        permission.Action,
+		BeginTime:     permission.BeginTime,
+		EndTime:       permission.EndTime,
+	}
+}
@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"ac/api/decision"
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/custom/limit"
+	"ac/custom/meta"
+	"ac/custom/tracing"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Server wraps the gRPC server together with its health service.
+type Server struct {
+	*grpc.Server
+	health *health.Server
+}
+
+// NewServer creates the gRPC server exposing the decision service, the standard
+// health checking service and server reflection. The calls are limited by limiter.
+func NewServer(limiter *limit.Limiter) *Server {
+	s := &Server{
+		Server: grpc.NewServer(grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, withMeta, limiter.UnaryServerInterceptor)),
+		health: health.NewServer(),
+	}
+	decision.RegisterDecisionServiceServer(s.Server, &decisionServer{})
+	healthpb.RegisterHealthServer(s.Server, s.health)
+	reflection.Register(s.Server)
+
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(decision.DecisionService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+// GracefulStop reports NOT_SERVING to health checkers before draining the in-flight calls.
+func (s *Server) GracefulStop() {
+	s.health.Shutdown()
+	s.Server.GracefulStop()
+}
+
+// Shutdown stops the server like GracefulStop, cancelling the calls still in flight once ctx
+// is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.GracefulStop()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Server.Stop()
+		<-done
+		return fmt.Errorf("failed to drain grpc calls, err: %w", ctx.Err())
+	}
+}
+
+// withMeta puts the metadata of every call into its context, taking the request ID from the
+// x-request-id metadata when the caller sends one, and logs the call the same way the HTTP
+// request logger does.
+func withMeta(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	ctx = background.NewContext(ctx, http.MethodPost, info.FullMethod)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-request-id"); len(v) > 0 && v[0] != "" {
+			m := meta.FromContext(ctx)
+			m.RequestID = v[0]
+			ctx = meta.NewContext(ctx, m)
+		}
+	}
+
+	resp, err := handler(ctx, req)
+
+	kv := map[string]interface{}{
+		"latency": time.Since(start),
+		"status":  status.Code(err).String(),
+	}
+	if err == nil {
+		logger.LogWith(ctx, logger.LevelInfo, "success", kv)
+	} else {
+		kv["error"] = err.Error()
+		logger.LogWith(ctx, logger.LevelError, "failure", kv)
+	}
+	return resp, err
+}
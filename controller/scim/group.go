@@ -0,0 +1,373 @@
+package scim
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/define"
+	"ac/custom/scim"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	scimService "ac/service/scim"
+	"ac/service/sod"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func listGroups(c echo.Context) error {
+	ctx := c.Request().Context()
+	systemCode := c.Param("system_code")
+	filter, err := scim.ParseFilter(c.QueryParam("filter"))
+	if err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidFilter, err.Error())
+	}
+	offset, limit, err := scim.ParsePagination(c.QueryParam("startIndex"), c.QueryParam("count"))
+	if err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+
+	condition := func(db *gorm.DB) *gorm.DB {
+		db = db.Where(model.ScimGroup{SystemCode: systemCode}).Where("deleted_at IS NULL")
+		if filter != nil {
+			switch filter.Attribute {
+			case "displayname":
+				db = db.Where("display_name = ?", filter.Value)
+			case "externalid":
+				db = db.Where("external_id = ?", filter.Value)
+			case "id":
+				db = db.Where("code = ?", filter.Value)
+			default:
+				db = db.Where("1 = 0")
+			}
+		}
+		return db
+	}
+	recordList, err := dal.NewRepo[model.ScimGroup]().QueryList(ctx, database.DB, condition, func(db *gorm.DB) *gorm.DB {
+		return db.Order("id asc").Offset(offset).Limit(limit)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query groups, err: %v", err)
+		return fail(c, errSystem)
+	}
+	count, err := dal.NewRepo[model.ScimGroup]().Count(ctx, database.DB, condition)
+	if err != nil {
+		logger.Errorf(ctx, "failed to count groups, err: %v", err)
+		return fail(c, errSystem)
+	}
+
+	groupCodeList := make([]string, 0, len(recordList))
+	for _, v := range recordList {
+		groupCodeList = append(groupCodeList, v.Code)
+	}
+	memberList := []model.ScimGroupMember{}
+	if c.QueryParam("excludedAttributes") != "members" && len(groupCodeList) > 0 {
+		memberList, err = dal.NewRepo[model.ScimGroupMember]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Where("group_code IN ?", groupCodeList).Order("id asc")
+		})
+		if err != nil {
+			logger.Errorf(ctx, "failed to query group members, err: %v", err)
+			return fail(c, errSystem)
+		}
+	}
+	groupCode2Members := make(map[string][]string, len(recordList))
+	for _, v := range memberList {
+		groupCode2Members[v.GroupCode] = append(groupCode2Members[v.GroupCode], v.UserCode)
+	}
+
+	list := make([]scim.Group, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, toGroup(v, groupCode2Members[v.Code]))
+	}
+	return scim.JSON(c, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: count,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(list),
+		Resources:    list,
+	})
+}
+
+func getGroup(c echo.Context) error {
+	record, err := queryGroup(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	return respondGroup(c, http.StatusOK, record)
+}
+
+func createGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	systemCode := c.Param("system_code")
+	body := scim.Group{}
+	if err := scim.Bind(c, &body); err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+	body.DisplayName = strings.TrimSpace(body.DisplayName)
+	if body.DisplayName == "" {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, "displayName is required")
+	}
+	if err := checkGroupUniqueness(ctx, systemCode, "", body.DisplayName); err != nil {
+		return fail(c, err)
+	}
+
+	now := util.UTCNow()
+	newValue := &model.ScimGroup{
+		SystemCode:  systemCode,
+		Code:        util.GenerateCode(define.PrefixGroup),
+		DisplayName: body.DisplayName,
+		ExternalID:  body.ExternalID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := scimService.CreateGroup(ctx, newValue, memberCodeList(body.Members)); err != nil {
+		return fail(c, memberFailure(ctx, err))
+	}
+	logger.Infof(ctx, "group provisioned, system code: %s, group code: %s, display name: %s", systemCode, newValue.Code, newValue.DisplayName)
+	return respondGroup(c, http.StatusCreated, newValue)
+}
+
+func replaceGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	record, err := queryGroup(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	body := scim.Group{}
+	if err := scim.Bind(c, &body); err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+	body.DisplayName = strings.TrimSpace(body.DisplayName)
+	if body.DisplayName == "" {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, "displayName is required")
+	}
+
+	if err := renameGroup(ctx, record, body.DisplayName, body.ExternalID); err != nil {
+		return fail(c, err)
+	}
+	if err := scimService.ReplaceMembers(ctx, record.SystemCode, record, memberCodeList(body.Members)); err != nil {
+		return fail(c, memberFailure(ctx, err))
+	}
+	return respondGroup(c, http.StatusOK, record)
+}
+
+func patchGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	record, err := queryGroup(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	body := scim.PatchRequest{}
+	if err := scim.Bind(c, &body); err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+
+	for _, op := range body.Operations {
+		if err := applyGroupOperation(ctx, record, op); err != nil {
+			return fail(c, err)
+		}
+	}
+	return respondGroup(c, http.StatusOK, record)
+}
+
+func deleteGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	record, err := queryGroup(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	if err := scimService.DeleteGroup(ctx, record.SystemCode, record); err != nil {
+		logger.Errorf(ctx, "failed to delete group, err: %v, system code: %s, code: %s", err, record.SystemCode, record.Code)
+		return fail(c, errSystem)
+	}
+	return scim.NoContent(c)
+}
+
+func applyGroupOperation(ctx context.Context, record *model.ScimGroup, op scim.PatchOperation) error {
+	invalidValue := func(err error) error {
+		return &failure{status: http.StatusBadRequest, scimType: scim.ErrTypeInvalidValue, detail: err.Error()}
+	}
+
+	switch strings.ToLower(op.Op) {
+	case "add", "remove":
+		memberCode, err := scim.ParseMemberPath(op.Path)
+		if err != nil {
+			return &failure{status: http.StatusBadRequest, scimType: scim.ErrTypeInvalidPath, detail: err.Error()}
+		}
+		memberList := []string{}
+		if memberCode != "" {
+			memberList = append(memberList, memberCode)
+		} else if len(op.Value) > 0 {
+			members := []scim.Member{}
+			if err := json.Unmarshal(op.Value, &members); err != nil {
+				return invalidValue(err)
+			}
+			memberList = memberCodeList(members)
+		}
+		if strings.EqualFold(op.Op, "add") {
+			return memberFailure(ctx, scimService.AddMembers(ctx, record.SystemCode, record, memberList))
+		}
+		if len(memberList) == 0 {
+			// Removing the "members" path without a value clears the group
+			if memberList, err = scimService.QueryMemberList(ctx, record.Code); err != nil {
+				return memberFailure(ctx, err)
+			}
+		}
+		return memberFailure(ctx, scimService.RemoveMembers(ctx, record.SystemCode, record, memberList))
+	case "replace":
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return invalidValue(err)
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+		displayName, externalID := record.DisplayName, record.ExternalID
+		for path, value := range values {
+			switch strings.ToLower(path) {
+			case "displayname":
+				if err := json.Unmarshal(value, &displayName); err != nil {
+					return invalidValue(err)
+				}
+			case "externalid":
+				if err := json.Unmarshal(value, &externalID); err != nil {
+					return invalidValue(err)
+				}
+			case "members":
+				members := []scim.Member{}
+				if err := json.Unmarshal(value, &members); err != nil {
+					return invalidValue(err)
+				}
+				err := scimService.ReplaceMembers(ctx, record.SystemCode, record, memberCodeList(members))
+				if err != nil {
+					return memberFailure(ctx, err)
+				}
+			}
+		}
+		return renameGroup(ctx, record, strings.TrimSpace(displayName), externalID)
+	default:
+		return &failure{status: http.StatusBadRequest, scimType: scim.ErrTypeInvalidPath, detail: "unsupported operation: " + op.Op}
+	}
+}
+
+// renameGroup updates the display name and the external id. A new display name maps the
+// group onto a different role, so the members are moved by the SCIM service.
+func renameGroup(ctx context.Context, record *model.ScimGroup, displayName, externalID string) error {
+	if displayName == "" {
+		return &failure{status: http.StatusBadRequest, scimType: scim.ErrTypeInvalidValue, detail: "displayName is required"}
+	}
+	if displayName != record.DisplayName {
+		if err := checkGroupUniqueness(ctx, record.SystemCode, record.Code, displayName); err != nil {
+			return err
+		}
+		if err := scimService.RenameGroup(ctx, record.SystemCode, record, displayName); err != nil {
+			logger.Errorf(ctx, "failed to rename group, err: %v, system code: %s, code: %s", err, record.SystemCode, record.Code)
+			return errSystem
+		}
+	}
+	if externalID != record.ExternalID {
+		err := dal.NewRepo[model.ScimGroup]().UpdateWithMap(ctx, database.DB, map[string]interface{}{
+			"external_id": externalID,
+			"updated_at":  util.UTCNow(),
+		}, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimGroup{SystemCode: record.SystemCode, Code: record.Code}).Limit(1)
+		})
+		if err != nil {
+			logger.Errorf(ctx, "failed to update record, err: %v", err)
+			return errSystem
+		}
+		record.ExternalID = externalID
+	}
+	return nil
+}
+
+func checkGroupUniqueness(ctx context.Context, systemCode, code, displayName string) error {
+	record, err := dal.NewRepo[model.ScimGroup]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimGroup{SystemCode: systemCode, DisplayName: displayName}).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query group, err: %v", err)
+		return errSystem
+	}
+	if record != nil && record.Code != code {
+		return &failure{status: http.StatusConflict, scimType: scim.ErrTypeUniqueness, detail: "displayName is already in use"}
+	}
+	return nil
+}
+
+// memberFailure converts errors of the SCIM service into SCIM errors.
+func memberFailure(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, scimService.ErrInvalidMember) {
+		return &failure{status: http.StatusBadRequest, scimType: scim.ErrTypeInvalidValue, detail: err.Error()}
+	}
+	if errors.Is(err, sod.ErrViolation) {
+		return &failure{status: http.StatusConflict, detail: err.Error()}
+	}
+	logger.Errorf(ctx, "failed to update group members, err: %v", err)
+	return errSystem
+}
+
+func queryGroup(c echo.Context) (*model.ScimGroup, error) {
+	ctx := c.Request().Context()
+	systemCode, code := c.Param("system_code"), c.Param("id")
+	record, err := dal.NewRepo[model.ScimGroup]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimGroup{SystemCode: systemCode, Code: code}).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query group, err: %v, system code: %s, code: %s", err, systemCode, code)
+		return nil, errSystem
+	}
+	if record == nil {
+		return nil, &failure{status: http.StatusNotFound, detail: "Group not found"}
+	}
+	return record, nil
+}
+
+func respondGroup(c echo.Context, status int, record *model.ScimGroup) error {
+	ctx := c.Request().Context()
+	memberList, err := scimService.QueryMemberList(ctx, record.Code)
+	if err != nil {
+		logger.Errorf(ctx, "failed to query group members, err: %v", err)
+		return fail(c, errSystem)
+	}
+	return scim.JSON(c, status, toGroup(*record, memberList))
+}
+
+func toGroup(record model.ScimGroup, memberList []string) scim.Group {
+	members := make([]scim.Member, 0, len(memberList))
+	for _, v := range memberList {
+		members = append(members, scim.Member{Value: v})
+	}
+	return scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          record.Code,
+		ExternalID:  record.ExternalID,
+		DisplayName: record.DisplayName,
+		Members:     members,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      record.CreatedAt,
+			LastModified: record.UpdatedAt,
+		},
+	}
+}
+
+func memberCodeList(members []scim.Member) []string {
+	list := make([]string, 0, len(members))
+	for _, v := range members {
+		if v.Value != "" {
+			list = append(list, v.Value)
+		}
+	}
+	return list
+}
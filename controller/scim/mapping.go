@@ -0,0 +1,130 @@
+package scim
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/admin"
+	"ac/custom/input"
+	"ac/custom/output"
+	"ac/dal"
+	"ac/model"
+	"ac/service/subject"
+	"ac/service/system"
+	"errors"
+	"time"
+
+	scimService "ac/service/scim"
+	"ac/service/sod"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type Mapping struct {
+	ID          int64     `json:"id"`
+	DisplayName string    `json:"display_name"`
+	RoleCode    string    `json:"role_code"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RegisterMappingRoutes registers the administration endpoints of the mapping from the
+// display names of the SCIM groups onto the roles their members are granted.
+func RegisterMappingRoutes(g *echo.Group) {
+	g.POST("/add", addMapping)
+	g.POST("/delete", deleteMapping)
+	g.GET("/query", queryMapping)
+}
+
+func addMapping(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode  string `json:"system_code" validate:"required,gt=0"`
+		DisplayName string `json:"display_name" validate:"required,gt=0,lte=100"`
+		RoleCode    string `json:"role_code" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	// The members of the groups change with the identity provider, out of any delegated scope
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+	if ok, err := subject.ValidateRole(ctx, body.SystemCode, body.RoleCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate role, err: %v, code: %s", err, body.RoleCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("role_code", "Invalid role code"))
+	}
+	record, err := dal.NewRepo[model.ScimRoleMapping]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimRoleMapping{SystemCode: body.SystemCode, DisplayName: body.DisplayName})
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query mapping, err: %v", err)
+		return output.Failure(c, err)
+	}
+	if record != nil {
+		return output.Failure(c, controller.ErrAlreadyExists.WithField("display_name", "The display name is already mapped"))
+	}
+
+	if err := scimService.MapRole(ctx, body.SystemCode, body.DisplayName, body.RoleCode); err != nil {
+		logger.Errorf(ctx, "failed to map role, err: %v, system code: %s, display name: %s", err, body.SystemCode, body.DisplayName)
+		if errors.Is(err, sod.ErrViolation) {
+			return output.Failure(c, controller.ErrSodViolation.WithMsg(err.Error()))
+		}
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+func deleteMapping(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode  string `json:"system_code" validate:"required,gt=0"`
+		DisplayName string `json:"display_name" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if err := scimService.UnmapRole(ctx, body.SystemCode, body.DisplayName); err != nil {
+		logger.Errorf(ctx, "failed to unmap role, err: %v, system code: %s, display name: %s", err, body.SystemCode, body.DisplayName)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+func queryMapping(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `query:"system_code" json:"system_code" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+
+	recordList, err := dal.NewRepo[model.ScimRoleMapping]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimRoleMapping{SystemCode: body.SystemCode}).Order("id asc")
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query mapping, err: %v", err)
+		return output.Failure(c, err)
+	}
+	list := make([]Mapping, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, Mapping{ID: v.ID, DisplayName: v.DisplayName, RoleCode: v.RoleCode, CreatedAt: v.CreatedAt})
+	}
+	return output.Success(c, list)
+}
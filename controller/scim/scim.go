@@ -0,0 +1,103 @@
+package scim
+
+import (
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/admin"
+	"ac/custom/scim"
+	"ac/service/system"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterRoutes registers the SCIM 2.0 endpoints. The group is expected to carry a
+// ":system_code" path parameter, every user and group is provisioned into that system. The
+// identity provider authenticates with the admin token as a bearer token, see custom/admin.
+func RegisterRoutes(g *echo.Group) {
+	g.Use(admin.AuthenticateWith(unauthorized), validateSystem)
+
+	g.GET("/ServiceProviderConfig", serviceProviderConfig)
+
+	g.GET("/Users", listUsers)
+	g.POST("/Users", createUser)
+	g.GET("/Users/:id", getUser)
+	g.PUT("/Users/:id", replaceUser)
+	g.PATCH("/Users/:id", patchUser)
+	g.DELETE("/Users/:id", deleteUser)
+
+	g.GET("/Groups", listGroups)
+	g.POST("/Groups", createGroup)
+	g.GET("/Groups/:id", getGroup)
+	g.PUT("/Groups/:id", replaceGroup)
+	g.PATCH("/Groups/:id", patchGroup)
+	g.DELETE("/Groups/:id", deleteGroup)
+}
+
+// failure carries a SCIM error from the helpers to the handler writing the response.
+type failure struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (f *failure) Error() string {
+	return f.detail
+}
+
+var errSystem = &failure{status: http.StatusInternalServerError, detail: "An unexpected system error occurred"}
+
+func fail(c echo.Context, err error) error {
+	var f *failure
+	if !errors.As(err, &f) {
+		f = errSystem
+	}
+	return scim.Failure(c, f.status, f.scimType, f.detail)
+}
+
+func unauthorized(c echo.Context, err *controller.Error) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="scim"`)
+	return scim.Failure(c, err.Status(), "", err.Hint)
+}
+
+func validateSystem(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		systemCode := c.Param("system_code")
+		if ok, err := system.Validate(ctx, systemCode); !ok {
+			if err != nil {
+				logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, systemCode)
+			}
+			return scim.Failure(c, http.StatusNotFound, "", "Invalid system code")
+		}
+		// Provisioning changes the users and groups of the whole system
+		if err := admin.AuthorizeSystem(ctx, systemCode); err != nil {
+			return scim.Failure(c, err.Status(), "", err.Hint)
+		}
+		return next(c)
+	}
+}
+
+func serviceProviderConfig(c echo.Context) error {
+	supported := func(v bool) map[string]bool {
+		return map[string]bool{"supported": v}
+	}
+	return scim.JSON(c, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scim.SchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": 500},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{
+			{
+				"type":        "oauthbearertoken",
+				"name":        "OAuth Bearer Token",
+				"description": "Authentication with the admin token in the Authorization header",
+				"primary":     true,
+			},
+		},
+	})
+}
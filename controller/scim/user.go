@@ -0,0 +1,349 @@
+package scim
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/define"
+	"ac/custom/scim"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/subject"
+	"ac/service/webhook"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	scimService "ac/service/scim"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func listUsers(c echo.Context) error {
+	ctx := c.Request().Context()
+	systemCode := c.Param("system_code")
+	filter, err := scim.ParseFilter(c.QueryParam("filter"))
+	if err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidFilter, err.Error())
+	}
+	offset, limit, err := scim.ParsePagination(c.QueryParam("startIndex"), c.QueryParam("count"))
+	if err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+
+	condition := func(db *gorm.DB) *gorm.DB {
+		db = db.Where(model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser})
+		if filter != nil {
+			switch filter.Attribute {
+			case "username":
+				db = db.Where("name = ?", filter.Value)
+			case "externalid":
+				db = db.Where("external_id = ?", filter.Value)
+			case "id":
+				db = db.Where("code = ?", filter.Value)
+			default:
+				db = db.Where("1 = 0")
+			}
+		}
+		return db
+	}
+	recordList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, condition, func(db *gorm.DB) *gorm.DB {
+		return db.Order("id asc").Offset(offset).Limit(limit)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query users, err: %v", err)
+		return fail(c, errSystem)
+	}
+	count, err := dal.NewRepo[model.Subject]().Count(ctx, database.DB, condition)
+	if err != nil {
+		logger.Errorf(ctx, "failed to count users, err: %v", err)
+		return fail(c, errSystem)
+	}
+
+	list := make([]scim.User, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, toUser(v))
+	}
+	return scim.JSON(c, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: count,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(list),
+		Resources:    list,
+	})
+}
+
+func getUser(c echo.Context) error {
+	record, err := queryUser(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	return scim.JSON(c, http.StatusOK, toUser(*record))
+}
+
+func createUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	systemCode := c.Param("system_code")
+	body := scim.User{}
+	if err := scim.Bind(c, &body); err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+	body.UserName = strings.TrimSpace(body.UserName)
+	if body.UserName == "" {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, "userName is required")
+	}
+	if err := checkUserUniqueness(ctx, systemCode, "", body.UserName, body.ExternalID); err != nil {
+		return fail(c, err)
+	}
+
+	var code string
+	for i := 0; i < 3; i++ {
+		tmpCode := util.GenerateCode(define.PrefixUser)
+
+		ok, err := subject.IsUserCodeAvailable(ctx, tmpCode)
+		if err != nil {
+			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+			return fail(c, errSystem)
+		}
+		if ok {
+			code = tmpCode
+			break
+		}
+	}
+	if code == "" {
+		logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+		return scim.Failure(c, http.StatusInternalServerError, "", "Unable to generate the code. Please try again later")
+	}
+
+	now := util.UTCNow()
+	newValue := &model.Subject{
+		SystemCode: systemCode,
+		Type:       model.SubjectTypeUser,
+		Name:       body.UserName,
+		Code:       code,
+		ExternalID: body.ExternalID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if body.Active != nil && !*body.Active {
+		newValue.DeletedAt = &now
+	}
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.Subject]().Insert(ctx, tx, newValue); err != nil {
+			return err
+		}
+		return webhook.EnqueueSubject(ctx, tx, newValue, webhook.ActionCreated)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to insert record, err: %v", err)
+		return fail(c, uniquenessFailure(err))
+	}
+	logger.Infof(ctx, "user provisioned, system code: %s, user code: %s, user name: %s", systemCode, code, body.UserName)
+	return scim.JSON(c, http.StatusCreated, toUser(*newValue))
+}
+
+func replaceUser(c echo.Context) error {
+	record, err := queryUser(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	body := scim.User{}
+	if err := scim.Bind(c, &body); err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+	body.UserName = strings.TrimSpace(body.UserName)
+	if body.UserName == "" {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, "userName is required")
+	}
+	active := body.Active == nil || *body.Active
+	return applyUserChange(c, record, body.UserName, body.ExternalID, active)
+}
+
+func patchUser(c echo.Context) error {
+	record, err := queryUser(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	body := scim.PatchRequest{}
+	if err := scim.Bind(c, &body); err != nil {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+	}
+
+	userName, externalID, active := record.Name, record.ExternalID, record.DeletedAt == nil
+	for _, op := range body.Operations {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidPath, "unsupported operation: "+op.Op)
+		}
+		// Without a path the value is an object holding the attributes to replace
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+		for path, value := range values {
+			var err error
+			switch strings.ToLower(path) {
+			case "username":
+				err = json.Unmarshal(value, &userName)
+			case "externalid":
+				err = json.Unmarshal(value, &externalID)
+			case "active":
+				err = unmarshalBool(value, &active)
+			}
+			// Attributes this server does not store are ignored, identity providers
+			// send a lot of them and rejecting the request would stop provisioning.
+			if err != nil {
+				return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, err.Error())
+			}
+		}
+	}
+	return applyUserChange(c, record, strings.TrimSpace(userName), externalID, active)
+}
+
+func deleteUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	record, err := queryUser(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	if err := scimService.DeprovisionUser(ctx, record.SystemCode, record.Code); err != nil {
+		logger.Errorf(ctx, "failed to deprovision user, err: %v, system code: %s, code: %s", err, record.SystemCode, record.Code)
+		return fail(c, errSystem)
+	}
+	return scim.NoContent(c)
+}
+
+func applyUserChange(c echo.Context, record *model.Subject, userName, externalID string, active bool) error {
+	ctx := c.Request().Context()
+	if userName == "" {
+		return scim.Failure(c, http.StatusBadRequest, scim.ErrTypeInvalidValue, "userName is required")
+	}
+	if err := checkUserUniqueness(ctx, record.SystemCode, record.Code, userName, externalID); err != nil {
+		return fail(c, err)
+	}
+
+	now := util.UTCNow()
+	newValue := map[string]interface{}{
+		"name":        userName,
+		"external_id": externalID,
+		"updated_at":  now,
+	}
+	// Reactivation only restores the user, its groupings are pushed again by the identity provider
+	if active && record.DeletedAt != nil {
+		newValue["deleted_at"] = nil
+	}
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.Subject]().UpdateWithMap(ctx, tx, newValue, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Subject{SystemCode: record.SystemCode, Code: record.Code, Type: model.SubjectTypeUser}).Limit(1)
+		})
+		if err != nil {
+			return err
+		}
+		changed := *record
+		changed.Name, changed.ExternalID = userName, externalID
+		return webhook.EnqueueSubject(ctx, tx, &changed, webhook.ActionUpdated)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to update record, err: %v", err)
+		return fail(c, uniquenessFailure(err))
+	}
+	record.Name, record.ExternalID, record.UpdatedAt = userName, externalID, now
+	if active {
+		record.DeletedAt = nil
+	}
+
+	if !active && record.DeletedAt == nil {
+		if err := scimService.DeprovisionUser(ctx, record.SystemCode, record.Code); err != nil {
+			logger.Errorf(ctx, "failed to deprovision user, err: %v, system code: %s, code: %s", err, record.SystemCode, record.Code)
+			return fail(c, errSystem)
+		}
+		record.DeletedAt = &now
+	}
+	return scim.JSON(c, http.StatusOK, toUser(*record))
+}
+
+// uniquenessFailure converts a write that broke a unique key, lost to a concurrent one after
+// checkUserUniqueness, into the same SCIM error.
+func uniquenessFailure(err error) error {
+	if errors.Is(err, dal.ErrDuplicateKey) {
+		return &failure{status: http.StatusConflict, scimType: scim.ErrTypeUniqueness, detail: "userName or externalId is already in use"}
+	}
+	return errSystem
+}
+
+// checkUserUniqueness makes sure no other user of the system uses the same userName or externalId.
+func checkUserUniqueness(ctx context.Context, systemCode, code, userName, externalID string) error {
+	recordList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		db = db.Where(model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser}).Where("deleted_at IS NULL")
+		if externalID != "" {
+			return db.Where("name = ? OR external_id = ?", userName, externalID)
+		}
+		return db.Where("name = ?", userName)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query users, err: %v", err)
+		return errSystem
+	}
+	for _, v := range recordList {
+		if v.Code != code {
+			return &failure{status: http.StatusConflict, scimType: scim.ErrTypeUniqueness, detail: "userName or externalId is already in use"}
+		}
+	}
+	return nil
+}
+
+// queryUser loads the user addressed by the ":id" path parameter.
+func queryUser(c echo.Context) (*model.Subject, error) {
+	ctx := c.Request().Context()
+	systemCode, code := c.Param("system_code"), c.Param("id")
+	record, err := dal.NewRepo[model.Subject]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Code: code, Type: model.SubjectTypeUser})
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query user, err: %v, system code: %s, code: %s", err, systemCode, code)
+		return nil, errSystem
+	}
+	if record == nil {
+		return nil, &failure{status: http.StatusNotFound, detail: "User not found"}
+	}
+	return record, nil
+}
+
+func toUser(record model.Subject) scim.User {
+	active := record.DeletedAt == nil
+	return scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          record.Code,
+		ExternalID:  record.ExternalID,
+		UserName:    record.Name,
+		DisplayName: record.Name,
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      record.CreatedAt,
+			LastModified: record.UpdatedAt,
+		},
+	}
+}
+
+// unmarshalBool accepts both JSON booleans and the "True"/"False" strings some identity providers send.
+func unmarshalBool(value json.RawMessage, v *bool) error {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			*v = true
+			return nil
+		case "false":
+			*v = false
+			return nil
+		}
+	}
+	return json.Unmarshal(value, v)
+}
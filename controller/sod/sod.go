@@ -0,0 +1,336 @@
+package sod
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/admin"
+	"ac/custom/define"
+	"ac/custom/input"
+	"ac/custom/output"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/sod"
+	"ac/service/subject"
+	"ac/service/system"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type Constraint struct {
+	ID           int64     `json:"id"`
+	Code         string    `json:"code"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	RoleCodeList []string  `json:"role_code_list"`
+	ModifiedBy   string    `json:"modified_by"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func RegisterRoutes(g *echo.Group) {
+	g.POST("/add", addItem)
+	g.POST("/update", updateItem)
+	g.POST("/delete", deleteItem)
+	g.GET("/query", query)
+	g.GET("/violation", violation)
+}
+
+// validateRoleList cleans up the roles of a constraint, it returns a hint when they are not usable.
+func validateRoleList(ctx context.Context, systemCode string, roleCodeList []string) ([]string, string, error) {
+	roleCodeList = util.Deduplicate(slices.DeleteFunc(roleCodeList, func(s string) bool {
+		return strings.TrimSpace(s) == ""
+	}))
+	if len(roleCodeList) < 2 {
+		return nil, "A constraint needs at least two roles", nil
+	}
+	validateResult, err := subject.ValidateRoleBatch(ctx, systemCode, roleCodeList)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to validate role, err: %w", err)
+	}
+	for _, v := range roleCodeList {
+		if valid, ok := validateResult[v]; !ok || !valid {
+			return nil, "Invalid role code", nil
+		}
+	}
+	return roleCodeList, "", nil
+}
+
+func toRoleModelList(constraintCode string, roleCodeList []string, now time.Time) []*model.SodConstraintRole {
+	list := make([]*model.SodConstraintRole, 0, len(roleCodeList))
+	for _, v := range roleCodeList {
+		list = append(list, &model.SodConstraintRole{ConstraintCode: constraintCode, RoleCode: v, CreatedAt: now})
+	}
+	return list
+}
+
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode   string   `json:"system_code" validate:"required,gt=0"`
+		Code         string   `json:"code"`
+		Name         string   `json:"name" validate:"required,gt=0,lte=50"`
+		Description  string   `json:"description" validate:"lte=200"`
+		RoleCodeList []string `json:"role_code_list" validate:"required,gt=1,max=100,dive,required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+	roleCodeList, hint, err := validateRoleList(ctx, body.SystemCode, body.RoleCodeList)
+	if err != nil {
+		logger.Errorf(ctx, "failed to validate role list, err: %v", err)
+		return output.Failure(c, err)
+	}
+	if hint != "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("role_code_list", hint))
+	}
+
+	code := body.Code
+	if code != "" {
+		if err := util.ValidateCode(define.PrefixSod, code); err != nil {
+			return output.Failure(c, controller.ErrInvalidInput.WithField("code", err.Error()))
+		}
+		ok, err := sod.IsCodeAvailable(ctx, code)
+		if err != nil {
+			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, code)
+			return output.Failure(c, err)
+		}
+		if !ok {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("code", "The code is already in use"))
+		}
+	} else {
+		for i := 0; i < 3; i++ {
+			tmpCode := util.GenerateCode(define.PrefixSod)
+
+			ok, err := sod.IsCodeAvailable(ctx, tmpCode)
+			if err != nil {
+				logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+				return output.Failure(c, err)
+			}
+			if ok {
+				code = tmpCode
+				break
+			}
+		}
+
+		if code == "" {
+			logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+			return output.Failure(c, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+		}
+	}
+
+	now := util.UTCNow()
+	newValue := &model.SodConstraint{
+		SystemCode:  body.SystemCode,
+		Code:        code,
+		Name:        body.Name,
+		Description: body.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	err = dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.SodConstraint]().Insert(ctx, tx, newValue); err != nil {
+			return fmt.Errorf("failed to insert constraint, err: %w", err)
+		}
+		return dal.NewRepo[model.SodConstraintRole]().BatchInsert(ctx, tx, toRoleModelList(code, roleCodeList, now), 20)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to insert record, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, Constraint{ID: newValue.ID, Code: newValue.Code, Name: newValue.Name, RoleCodeList: roleCodeList})
+}
+
+// updateItem replaces the name, description and roles of the constraint. Users that already
+// hold conflicting roles are not touched, they show up in violation.
+func updateItem(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode   string   `json:"system_code" validate:"required,gt=0"`
+		Code         string   `json:"code" validate:"required,gt=0"`
+		Name         string   `json:"name" validate:"required,gt=0,lte=50"`
+		Description  string   `json:"description" validate:"lte=200"`
+		RoleCodeList []string `json:"role_code_list" validate:"required,gt=1,max=100,dive,required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := sod.Validate(ctx, body.SystemCode, body.Code); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate constraint, err: %v, code: %s", err, body.Code)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid constraint code"))
+	}
+	roleCodeList, hint, err := validateRoleList(ctx, body.SystemCode, body.RoleCodeList)
+	if err != nil {
+		logger.Errorf(ctx, "failed to validate role list, err: %v", err)
+		return output.Failure(c, err)
+	}
+	if hint != "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("role_code_list", hint))
+	}
+
+	now := util.UTCNow()
+	err = dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.SodConstraint]().UpdateWithMap(ctx, tx, map[string]interface{}{
+			"name":        body.Name,
+			"description": body.Description,
+			"updated_at":  now,
+		}, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.SodConstraint{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update constraint, err: %w", err)
+		}
+		err = dal.NewRepo[model.SodConstraintRole]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.SodConstraintRole{ConstraintCode: body.Code})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete constraint role, err: %w", err)
+		}
+		return dal.NewRepo[model.SodConstraintRole]().BatchInsert(ctx, tx, toRoleModelList(body.Code, roleCodeList, now), 20)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to update record, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `json:"system_code" validate:"required,gt=0"`
+		Code       string `json:"code" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := sod.Validate(ctx, body.SystemCode, body.Code); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate constraint, err: %v, code: %s", err, body.Code)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid constraint code"))
+	}
+
+	now := util.UTCNow()
+	newValue := &model.SodConstraint{
+		DeletedAt: &now,
+	}
+	if err := dal.NewRepo[model.SodConstraint]().Update(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.SodConstraint{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
+	}); err != nil {
+		logger.Errorf(ctx, "failed to update record, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+// listSpec is what the list of constraints supports, the system code is required.
+var listSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+		dal.FilterNamePrefix: "name",
+		dal.FilterUpdatedAt:  "updated_at",
+	},
+	SortKeys: []string{"name", "updated_at"},
+}
+
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := dal.ListQuery{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.SystemCode == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "'system_code' failed on the 'required' rule"))
+	}
+
+	recordList, info, err := dal.List[model.SodConstraint](ctx, database.DB, body, listSpec, func(db *gorm.DB) *gorm.DB {
+		return db.Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query, err: %v", err)
+		return output.Failure(c, err)
+	}
+
+	codeList := make([]string, 0, len(recordList))
+	for _, v := range recordList {
+		codeList = append(codeList, v.Code)
+	}
+	roleList, err := dal.NewRepo[model.SodConstraintRole]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("constraint_code IN ?", codeList).Order("id asc")
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query constraint role, err: %v", err)
+		return output.Failure(c, err)
+	}
+	code2RoleCodeList := make(map[string][]string)
+	for _, v := range roleList {
+		code2RoleCodeList[v.ConstraintCode] = append(code2RoleCodeList[v.ConstraintCode], v.RoleCode)
+	}
+
+	list := make([]Constraint, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, Constraint{
+			ID:           v.ID,
+			Code:         v.Code,
+			Name:         v.Name,
+			Description:  v.Description,
+			RoleCodeList: code2RoleCodeList[v.Code],
+			ModifiedBy:   v.ModifiedBy,
+			UpdatedAt:    v.UpdatedAt,
+		})
+	}
+
+	return output.List(c, list, info)
+}
+
+// violation lists the users that hold more than one role of a constraint, e.g. because they
+// got the roles before the constraint was created.
+func violation(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `json:"system_code" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+
+	list, err := sod.Violations(ctx, body.SystemCode)
+	if err != nil {
+		logger.Errorf(ctx, "failed to find violations, err: %v, system code: %s", err, body.SystemCode)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, map[string]interface{}{
+		"total": len(list),
+		"list":  list,
+	})
+}
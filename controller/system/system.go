@@ -4,6 +4,7 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/controller"
+	"ac/custom/admin"
 	"ac/custom/define"
 	"ac/custom/input"
 	"ac/custom/output"
@@ -34,32 +35,52 @@ func RegisterRoutes(g *echo.Group) {
 	g.GET("/get", GetItem)
 }
 
-func addItem(ctx echo.Context) error {
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		Name        string `json:"name" validate:"required,gt=0"`
 		Description string `json:"description"`
+		Code        string `json:"code"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeUnscoped(ctx); err != nil {
+		return output.Failure(c, err)
 	}
-	var code string
-	for i := 0; i < 3; i++ {
-		tmpCode := util.GenerateCode(define.PrefixSystem)
 
-		ok, err := system.IsCodeAvailable(ctx, tmpCode)
+	code := body.Code
+	if code != "" {
+		if err := util.ValidateCode(define.PrefixSystem, code); err != nil {
+			return output.Failure(c, controller.ErrInvalidInput.WithField("code", err.Error()))
+		}
+		ok, err := system.IsCodeAvailable(ctx, code)
 		if err != nil {
 			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, code)
-			return output.Failure(ctx, controller.ErrSystemError)
+			return output.Failure(c, err)
 		}
-		if ok {
-			code = tmpCode
-			break
+		if !ok {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("code", "The code is already in use"))
+		}
+	} else {
+		for i := 0; i < 3; i++ {
+			tmpCode := util.GenerateCode(define.PrefixSystem)
+
+			ok, err := system.IsCodeAvailable(ctx, tmpCode)
+			if err != nil {
+				logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+				return output.Failure(c, err)
+			}
+			if ok {
+				code = tmpCode
+				break
+			}
 		}
-	}
 
-	if code == "" {
-		logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+		if code == "" {
+			logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+			return output.Failure(c, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+		}
 	}
 
 	now := util.UTCNow()
@@ -72,26 +93,31 @@ func addItem(ctx echo.Context) error {
 	}
 	if err := dal.NewRepo[model.System]().Insert(ctx, database.DB, newValue); err != nil {
 		logger.Errorf(ctx, "failed to insert record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, System{ID: newValue.ID, Code: newValue.Code, Name: newValue.Name})
+	return output.Success(c, System{ID: newValue.ID, Code: newValue.Code, Name: newValue.Name})
 }
 
-func updateItem(ctx echo.Context) error {
+func updateItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		Code        string `json:"code" validate:"required,gt=0"`
 		Name        string `json:"name" validate:"required,gt=0"`
 		Description string `json:"description"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.Code); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.Code); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.Code)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid system code"))
 	}
 
 	now := util.UTCNow()
@@ -104,24 +130,29 @@ func updateItem(ctx echo.Context) error {
 		return db.Where(model.System{Code: body.Code}).Limit(1)
 	}); err != nil {
 		logger.Errorf(ctx, "failed to update record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func deleteItem(ctx echo.Context) error {
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		Code string `json:"code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.Code); err != nil {
+		return output.Failure(c, err)
 	}
 
 	if ok, err := system.Validate(ctx, body.Code); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.Code)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid system code"))
 	}
 
 	now := util.UTCNow()
@@ -132,24 +163,32 @@ func deleteItem(ctx echo.Context) error {
 		return db.Where(model.System{Code: body.Code}).Limit(1)
 	}); err != nil {
 		logger.Errorf(ctx, "failed to update record, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func query(ctx echo.Context) error {
-	body := struct {
-		Page     int `json:"page"`
-		PageSize int `json:"page_size"`
-	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+// listSpec is what the list of systems supports, the code of a system is its system code.
+var listSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "code",
+		dal.FilterNamePrefix: "name",
+		dal.FilterUpdatedAt:  "updated_at",
+	},
+	SortKeys: []string{"name", "updated_at"},
+}
+
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := dal.ListQuery{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
-	recordList, err := dal.NewRepo[model.System]().QueryList(ctx, database.DB, dal.Paginate(body.Page, body.PageSize))
+	recordList, info, err := dal.List[model.System](ctx, database.DB, body, listSpec)
 	if err != nil {
 		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 
 	list := make([]System, 0, len(recordList))
@@ -163,17 +202,16 @@ func query(ctx echo.Context) error {
 		})
 	}
 
-	return output.Success(ctx, map[string]interface{}{
-		"list": list,
-	})
+	return output.List(c, list, info)
 }
 
-func GetItem(ctx echo.Context) error {
+func GetItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		Code string `json:"code" validate:"required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
 	}
 
 	record, err := dal.NewRepo[model.System]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
@@ -181,13 +219,13 @@ func GetItem(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	} else if record == nil {
 		logger.Infof(ctx, "failed to query, no matching record found, code: %s", body.Code)
-		return output.Failure(ctx, controller.ErrRecordNotFound)
+		return output.Failure(c, controller.ErrRecordNotFound)
 	}
 
-	return output.Success(ctx, System{
+	return output.Success(c, System{
 		ID:          record.ID,
 		Code:        record.Code,
 		Name:        record.Name,
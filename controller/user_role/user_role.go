@@ -4,16 +4,21 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/controller"
+	"ac/custom/admin"
 	"ac/custom/define"
 	"ac/custom/input"
 	"ac/custom/output"
 	"ac/custom/util"
 	"ac/dal"
 	"ac/model"
-	"fmt"
+	"context"
+	"errors"
 	"slices"
 	"strings"
+	"time"
 
+	"ac/service/rule"
+	"ac/service/sod"
 	"ac/service/subject"
 	"ac/service/system"
 
@@ -27,14 +32,39 @@ func RegisterRoutes(g *echo.Group) {
 	g.GET("/query", query)
 }
 
-func addItem(ctx echo.Context) error {
+// authorize checks that the caller may administer everything the roles grant. It returns the
+// error to respond with, nil when allowed.
+func authorize(ctx context.Context, systemCode string, roleCodeList []string) *controller.Error {
+	for _, v := range roleCodeList {
+		if err := admin.AuthorizeRole(ctx, systemCode, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode   string   `json:"system_code" validate:"required,gt=0"`
 		UserCode     string   `json:"user_code" validate:"required,gt=0"`
-		RoleCodeList []string `json:"role_code_list" validate:"required,gt=0,dive,required,gt=0"`
+		RoleCodeList []string `json:"role_code_list" validate:"required,gt=0,max=100,dive,required,gt=0"`
+		// Optional window of the membership, unix seconds, 0 leaves that side open
+		BeginTime int64 `json:"begin_time" validate:"gte=0"`
+		EndTime   int64 `json:"end_time" validate:"gte=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	version, err := input.IfMatch(c)
+	if err != nil {
+		return output.Failure(c, err)
+	}
+	if body.BeginTime > 0 && body.EndTime > 0 && body.EndTime < body.BeginTime {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("end_time", "end_time must be after begin_time"))
+	}
+	if body.EndTime > 0 && body.EndTime < time.Now().Unix() {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("end_time", "end_time has passed"))
 	}
 
 	body.RoleCodeList = util.Deduplicate(slices.DeleteFunc(body.RoleCodeList, func(s string) bool {
@@ -44,27 +74,32 @@ func addItem(ctx echo.Context) error {
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 	if ok, err := subject.ValidateUser(ctx, body.SystemCode, body.UserCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate user, err: %v, system code: %s, code: %s", err, body.SystemCode, body.UserCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid user code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("user_code", "Invalid user code"))
 	}
 
 	validateResult, err := subject.ValidateRoleBatch(ctx, body.SystemCode, body.RoleCodeList)
 	if err != nil {
 		logger.Errorf(ctx, "failed to validate role, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 
 	for _, v := range body.RoleCodeList {
 		if valid, ok := validateResult[v]; ok && valid {
 			continue
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid role code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("role_code_list", "Invalid role code"))
+	}
+	if e := authorize(ctx, body.SystemCode, body.RoleCodeList); e != nil {
+		return output.Failure(c, e)
 	}
 
 	ruleList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
@@ -72,41 +107,57 @@ func addItem(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query user role, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	if len(ruleList) > 0 {
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("User's roles have been updated. Please refresh and try again."))
+		return output.Failure(c, controller.ErrConflict.WithHint("User's roles have been updated. Please refresh and try again."))
 	}
-	valuesToAdd := make([]*model.CasbinRule, 0, len(body.RoleCodeList))
+	var beginTime, endTime time.Time
+	if body.BeginTime > 0 {
+		beginTime = time.Unix(body.BeginTime, 0).UTC()
+	}
+	if body.EndTime > 0 {
+		endTime = time.Unix(body.EndTime, 0).UTC()
+	}
+	ruleToAdd := make([]rule.Rule, 0, len(body.RoleCodeList))
 	for _, v := range body.RoleCodeList {
-		valuesToAdd = append(valuesToAdd, &model.CasbinRule{
+		ruleToAdd = append(ruleToAdd, rule.Rule{
 			PType: model.PTypeGroup,
 			V0:    body.UserCode,
 			V1:    v,
+			V3:    beginTime,
+			V4:    endTime,
 		})
 	}
-	err = database.DB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
-		err := dal.NewRepo[model.CasbinRule]().BatchInsert(ctx, tx, valuesToAdd, 20)
-		if err != nil {
-			return fmt.Errorf("failed to add casin rule, err: %v", err)
+	if err := rule.AddIfMatch(ctx, ruleToAdd, version); err != nil {
+		logger.Errorf(ctx, "failed to add user role, err: %v", err)
+		if errors.Is(err, rule.ErrVersionMismatch) {
+			return output.Failure(c, controller.ErrVersionMismatch)
 		}
-		return nil
-	})
-	if err != nil {
-		logger.Errorf(ctx, "failed to commit, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		if errors.Is(err, rule.ErrDuplicateRule) {
+			return output.Failure(c, controller.ErrConflict.WithHint("User's roles have been updated. Please refresh and try again."))
+		}
+		if errors.Is(err, sod.ErrViolation) {
+			return output.Failure(c, controller.ErrSodViolation.WithMsg(err.Error()))
+		}
+		return output.Failure(c, err)
 	}
-	return output.Success(ctx, nil)
+	return output.Success(c, nil)
 }
 
-func deleteItem(ctx echo.Context) error {
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
 		SystemCode   string   `json:"system_code" validate:"required,gt=0"`
 		UserCode     string   `json:"user_code" validate:"required,gt=0"`
-		RoleCodeList []string `json:"role_code_list" validate:"required,gt=0,dive,required,gt=0"`
+		RoleCodeList []string `json:"role_code_list" validate:"required,gt=0,max=100,dive,required,gt=0"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	version, err := input.IfMatch(c)
+	if err != nil {
+		return output.Failure(c, err)
 	}
 	body.RoleCodeList = util.Deduplicate(slices.DeleteFunc(body.RoleCodeList, func(s string) bool {
 		return strings.TrimSpace(s) == ""
@@ -115,27 +166,32 @@ func deleteItem(ctx echo.Context) error {
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 	if ok, err := subject.ValidateUser(ctx, body.SystemCode, body.UserCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate user, err: %v, system code: %s, code: %s", err, body.SystemCode, body.UserCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid user code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("user_code", "Invalid user code"))
 	}
 
 	validateResult, err := subject.ValidateRoleBatch(ctx, body.SystemCode, body.RoleCodeList)
 	if err != nil {
 		logger.Errorf(ctx, "failed to validate role, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 
 	for _, v := range body.RoleCodeList {
 		if valid, ok := validateResult[v]; ok && valid {
 			continue
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid role code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("role_code_list", "Invalid role code"))
+	}
+	if e := authorize(ctx, body.SystemCode, body.RoleCodeList); e != nil {
+		return output.Failure(c, e)
 	}
 
 	ruleList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
@@ -143,41 +199,74 @@ func deleteItem(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query user role, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	if len(ruleList) != len(body.RoleCodeList) {
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("User's roles have been updated. Please refresh and try again."))
+		return output.Failure(c, controller.ErrConflict.WithHint("User's roles have been updated. Please refresh and try again."))
 	}
 
-	err = database.DB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
-		err := dal.NewRepo[model.CasbinRule]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
-			return db.Where(ruleList).Limit(len(ruleList))
+	ruleToDelete := make([]rule.Rule, 0, len(ruleList))
+	for _, v := range ruleList {
+		ruleToDelete = append(ruleToDelete, rule.Rule{
+			PType: v.PType,
+			V0:    v.V0,
+			V1:    v.V1,
 		})
-		if err != nil {
-			return fmt.Errorf("failed to add casin rule, err: %v", err)
+	}
+	if err := rule.DeleteIfMatch(ctx, ruleToDelete, version); err != nil {
+		logger.Errorf(ctx, "failed to delete user role, err: %v", err)
+		if errors.Is(err, rule.ErrVersionMismatch) {
+			return output.Failure(c, controller.ErrVersionMismatch)
 		}
-		return nil
-	})
-	if err != nil {
-		logger.Errorf(ctx, "failed to commit, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		if errors.Is(err, rule.ErrRuleNotFound) {
+			return output.Failure(c, controller.ErrConflict.WithHint("User's roles have been updated. Please refresh and try again."))
+		}
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+// listSpec is what the list of memberships supports, the system code is required and applied
+// by membershipCondition, casbin_rule holds the memberships of every system.
+var listSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "",
+	},
+	DefaultSort: "-id",
+}
+
+// membershipCondition returns the condition of the memberships of the system, narrowed down to
+// the users or roles if any. casbin_rule has no system code, the memberships of the system are
+// those of its subjects.
+func membershipCondition(systemCode string, userCodeList, roleCodeList []string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		subjectList := db.Session(&gorm.Session{NewDB: true}).Model(&model.Subject{}).Select("code").Where(model.Subject{SystemCode: systemCode})
+		db = db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v0 IN (?)", subjectList)
+		if len(userCodeList) > 0 {
+			db = db.Where("v0 IN ?", userCodeList)
+		}
+		if len(roleCodeList) > 0 {
+			db = db.Where("v1 IN ?", roleCodeList)
+		}
+		return db
 	}
-	return output.Success(ctx, nil)
 }
 
-func query(ctx echo.Context) error {
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
 	body := struct {
-		Page         int      `json:"page" validate:"required,gt=0"`
-		PageSize     int      `json:"page_size" validate:"required,gt=0"`
-		SystemCode   string   `json:"system_code" validate:"required,gt=0"`
+		dal.ListQuery
 		UserCodeList []string `json:"user_code_list"`
 		RoleCodeList []string `json:"role_code_list"`
 	}{}
-	if err := input.BindAndValidate(ctx, &body); err != nil {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg(err.Error()))
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.SystemCode == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "'system_code' failed on the 'required' rule"))
 	}
 	if len(body.UserCodeList) > 0 && len(body.RoleCodeList) > 0 {
-		return output.Failure(ctx, controller.ErrInvalidInput.WithMsg("both 'UserCodeList' and 'RoleCodeList' cannot be provided simultaneously"))
+		return output.Failure(c, controller.ErrInvalidInput.WithMsg("both 'UserCodeList' and 'RoleCodeList' cannot be provided simultaneously"))
 	}
 	body.UserCodeList = util.Deduplicate(slices.DeleteFunc(body.UserCodeList, func(s string) bool {
 		return strings.TrimSpace(s) == ""
@@ -188,22 +277,23 @@ func query(ctx echo.Context) error {
 	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate system, err: %v, code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
 		}
-		return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid system code"))
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
 	}
 
 	if len(body.UserCodeList) > 0 {
 		validateResult, err := subject.ValidateUserBatch(ctx, body.SystemCode, body.UserCodeList)
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate user, err: %v", err)
-			return output.Failure(ctx, controller.ErrSystemError)
+			return output.Failure(c, err)
 		}
 
 		for _, v := range body.UserCodeList {
 			if valid, ok := validateResult[v]; ok && valid {
 				continue
 			}
-			return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid user code"))
+			return output.Failure(c, controller.ErrInvalidInput.WithField("user_code_list", "Invalid user code"))
 		}
 	}
 
@@ -211,47 +301,35 @@ func query(ctx echo.Context) error {
 		validateResult, err := subject.ValidateRoleBatch(ctx, body.SystemCode, body.RoleCodeList)
 		if err != nil {
 			logger.Errorf(ctx, "failed to validate role, err: %v", err)
-			return output.Failure(ctx, controller.ErrSystemError)
+			return output.Failure(c, err)
 		}
 
 		for _, v := range body.RoleCodeList {
 			if valid, ok := validateResult[v]; ok && valid {
 				continue
 			}
-			return output.Failure(ctx, controller.ErrSystemError.WithHint("Invalid role code"))
+			return output.Failure(c, controller.ErrInvalidInput.WithField("role_code_list", "Invalid role code"))
 		}
 	}
 
-	ruleList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
-		if len(body.UserCodeList) > 0 {
-			db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v0 IN ?", body.UserCodeList)
-		}
-		if len(body.RoleCodeList) > 0 {
-			db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v1 IN ?", body.RoleCodeList)
+	// The roles of a single user carry its version, read ahead of them so that a change in
+	// between makes the version stale rather than the list
+	var version int64
+	if len(body.UserCodeList) == 1 {
+		v, err := rule.Version(ctx, body.SystemCode, body.UserCodeList[0], model.PTypeGroup)
+		if err != nil {
+			logger.Errorf(ctx, "failed to get version, err: %v", err)
+			return output.Failure(c, err)
 		}
-		db.Order("id desc")
-		return db
-	}, dal.Paginate(body.Page, body.PageSize))
-	if err != nil {
-		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		version = v
 	}
 
-	count, err := dal.NewRepo[model.CasbinRule]().Count(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
-		if len(body.UserCodeList) > 0 {
-			db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v0 IN ?", body.UserCodeList)
-		}
-		if len(body.RoleCodeList) > 0 {
-			db.Or(db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v1 IN ?", body.RoleCodeList))
-		}
-		return db
-	}, dal.Paginate(body.Page, body.PageSize))
+	ruleList, info, err := dal.List[model.CasbinRule](ctx, database.DB, body.ListQuery, listSpec, membershipCondition(body.SystemCode, body.UserCodeList, body.RoleCodeList))
 	if err != nil {
 		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 
-	fmt.Println(111)
 	tmpCodeList := make([]string, 0, len(ruleList))
 	for _, v := range ruleList {
 		if strings.HasPrefix(v.V0, define.PrefixUser) || strings.HasPrefix(v.V0, define.PrefixRole) {
@@ -266,7 +344,7 @@ func query(ctx echo.Context) error {
 	})
 	if err != nil {
 		logger.Errorf(ctx, "failed to query, err: %v", err)
-		return output.Failure(ctx, controller.ErrSystemError)
+		return output.Failure(c, err)
 	}
 	subjectCode2Name := make(map[string]string)
 	for _, v := range recordList {
@@ -279,6 +357,8 @@ func query(ctx echo.Context) error {
 		UserName   string `json:"user_name"`
 		RoleCode   string `json:"role_code"`
 		RoleName   string `json:"role_name"`
+		BeginTime  string `json:"begin_time"`
+		EndTime    string `json:"end_time"`
 	}
 	list := make([]Rule, 0, len(ruleList))
 	for _, v := range ruleList {
@@ -286,6 +366,8 @@ func query(ctx echo.Context) error {
 			SystemCode: body.SystemCode,
 			UserCode:   v.V0,
 			RoleCode:   v.V1,
+			BeginTime:  v.V3,
+			EndTime:    v.V4,
 		}
 		if name, ok := subjectCode2Name[v.V0]; ok {
 			rule.UserName = name
@@ -295,8 +377,10 @@ func query(ctx echo.Context) error {
 		}
 		list = append(list, rule)
 	}
-	return output.Success(ctx, map[string]interface{}{
-		"total": count,
-		"list":  list,
-	})
+	data := output.ListData(list, info)
+	if version > 0 {
+		output.ETag(c, version)
+		data["version"] = version
+	}
+	return output.Success(c, data)
 }
@@ -0,0 +1,38 @@
+package user_role
+
+import (
+	"ac/model"
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// TestMembershipConditionTwoSystems builds the query of the memberships of two systems sharing
+// casbin_rule and checks that each is limited to the subjects of its own system.
+func TestMembershipConditionTwoSystems(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:password@tcp(127.0.0.1:3306)/ac",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("failed to open database, err: %v", err)
+	}
+
+	for _, systemCode := range []string{"system_a", "system_b"} {
+		stmt := db.Model(&model.CasbinRule{}).Scopes(membershipCondition(systemCode, nil, []string{"role_1"})).Find(&[]model.CasbinRule{}).Statement
+		sql := stmt.SQL.String()
+		if !strings.Contains(sql, "v0 IN (SELECT `code` FROM `subject` WHERE `subject`.`system_code` = ?") {
+			t.Errorf("system %s: query is not limited to the subjects of the system: %s", systemCode, sql)
+		}
+		other := "system_b"
+		if systemCode == "system_b" {
+			other = "system_a"
+		}
+		if !slices.Contains(stmt.Vars, any(systemCode)) || slices.Contains(stmt.Vars, any(other)) {
+			t.Errorf("system %s: query vars = %v", systemCode, stmt.Vars)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package webhook
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/admin"
+	"ac/custom/define"
+	"ac/custom/input"
+	"ac/custom/output"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/system"
+	"ac/service/webhook"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Webhook never carries the secret, it is only returned once by addItem.
+type Webhook struct {
+	ID            int64     `json:"id"`
+	Code          string    `json:"code"`
+	URL           string    `json:"url"`
+	EventTypeList []string  `json:"event_type_list"`
+	ModifiedBy    string    `json:"modified_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type DeadLetter struct {
+	ID          int64     `json:"id"`
+	OutboxID    int64     `json:"outbox_id"`
+	WebhookCode string    `json:"webhook_code"`
+	Attempt     int       `json:"attempt"`
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func RegisterRoutes(g *echo.Group) {
+	g.POST("/add", addItem)
+	g.POST("/update", updateItem)
+	g.POST("/delete", deleteItem)
+	g.GET("/query", query)
+	g.GET("/dead-letter/query", queryDeadLetter)
+	g.POST("/dead-letter/retry", retryDeadLetter)
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url, err: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https url")
+	}
+	return nil
+}
+
+func validateEventTypeList(eventTypeList []string) error {
+	for _, v := range eventTypeList {
+		if !webhook.IsValidEventType(v) {
+			return fmt.Errorf("unknown event type '%s'", v)
+		}
+	}
+	return nil
+}
+
+func addItem(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode    string   `json:"system_code" validate:"required,gt=0"`
+		Code          string   `json:"code"`
+		URL           string   `json:"url" validate:"required,gt=0,lte=500"`
+		Secret        string   `json:"secret" validate:"omitempty,gte=16,lte=100"`
+		EventTypeList []string `json:"event_type_list"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := validateURL(body.URL); err != nil {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("url", err.Error()))
+	}
+	if err := validateEventTypeList(body.EventTypeList); err != nil {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("event_type_list", err.Error()))
+	}
+	if ok, err := system.Validate(ctx, body.SystemCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate system, err: %v, system code: %s", err, body.SystemCode)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "Invalid system code"))
+	}
+
+	code := body.Code
+	if code != "" {
+		if err := util.ValidateCode(define.PrefixWebhook, code); err != nil {
+			return output.Failure(c, controller.ErrInvalidInput.WithField("code", err.Error()))
+		}
+		ok, err := webhook.IsCodeAvailable(ctx, code)
+		if err != nil {
+			logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, code)
+			return output.Failure(c, err)
+		}
+		if !ok {
+			return output.Failure(c, controller.ErrAlreadyExists.WithField("code", "The code is already in use"))
+		}
+	} else {
+		for i := 0; i < 3; i++ {
+			tmpCode := util.GenerateCode(define.PrefixWebhook)
+
+			ok, err := webhook.IsCodeAvailable(ctx, tmpCode)
+			if err != nil {
+				logger.Errorf(ctx, "failed to check code availability, err: %v, code: %s", err, tmpCode)
+				return output.Failure(c, err)
+			}
+			if ok {
+				code = tmpCode
+				break
+			}
+		}
+
+		if code == "" {
+			logger.Errorf(ctx, "failed to generate unique code after 3 attempts")
+			return output.Failure(c, controller.ErrSystemError.WithHint("Unable to generate the code. Please try again later"))
+		}
+	}
+
+	secret := body.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhook.GenerateSecret(); err != nil {
+			logger.Errorf(ctx, "failed to generate secret, err: %v", err)
+			return output.Failure(c, err)
+		}
+	}
+
+	now := util.UTCNow()
+	newValue := &model.Webhook{
+		SystemCode:    body.SystemCode,
+		Code:          code,
+		URL:           body.URL,
+		Secret:        secret,
+		EventTypeList: strings.Join(util.Deduplicate(body.EventTypeList), ","),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := dal.NewRepo[model.Webhook]().Insert(ctx, database.DB, newValue); err != nil {
+		logger.Errorf(ctx, "failed to insert record, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, map[string]interface{}{
+		"id":     newValue.ID,
+		"code":   newValue.Code,
+		"secret": secret,
+	})
+}
+
+func updateItem(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode    string   `json:"system_code" validate:"required,gt=0"`
+		Code          string   `json:"code" validate:"required,gt=0"`
+		URL           string   `json:"url" validate:"required,gt=0,lte=500"`
+		Secret        string   `json:"secret" validate:"omitempty,gte=16,lte=100"`
+		EventTypeList []string `json:"event_type_list"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := validateURL(body.URL); err != nil {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("url", err.Error()))
+	}
+	if err := validateEventTypeList(body.EventTypeList); err != nil {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("event_type_list", err.Error()))
+	}
+
+	if ok, err := webhook.Validate(ctx, body.SystemCode, body.Code); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate webhook, err: %v, code: %s", err, body.Code)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid webhook code"))
+	}
+
+	// A map so that clearing the event types, which subscribes to all of them, is written too
+	newValue := map[string]interface{}{
+		"url":             body.URL,
+		"event_type_list": strings.Join(util.Deduplicate(body.EventTypeList), ","),
+		"updated_at":      util.UTCNow(),
+	}
+	if body.Secret != "" {
+		newValue["secret"] = body.Secret
+	}
+	if err := dal.NewRepo[model.Webhook]().UpdateWithMap(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Webhook{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
+	}); err != nil {
+		logger.Errorf(ctx, "failed to update record, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+func deleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string `json:"system_code" validate:"required,gt=0"`
+		Code       string `json:"code" validate:"required,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if ok, err := webhook.Validate(ctx, body.SystemCode, body.Code); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate webhook, err: %v, code: %s", err, body.Code)
+			return output.Failure(c, err)
+		}
+		return output.Failure(c, controller.ErrRecordNotFound.WithHint("Invalid webhook code"))
+	}
+
+	now := util.UTCNow()
+	newValue := &model.Webhook{
+		DeletedAt: &now,
+	}
+	if err := dal.NewRepo[model.Webhook]().Update(ctx, database.DB, newValue, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Webhook{SystemCode: body.SystemCode, Code: body.Code}).Limit(1)
+	}); err != nil {
+		logger.Errorf(ctx, "failed to update record, err: %v", err)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
+
+// listSpec is what the list of webhooks supports, the system code is required.
+var listSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+		dal.FilterUpdatedAt:  "updated_at",
+	},
+	SortKeys: []string{"updated_at"},
+}
+
+func query(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := dal.ListQuery{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.SystemCode == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "'system_code' failed on the 'required' rule"))
+	}
+
+	recordList, info, err := dal.List[model.Webhook](ctx, database.DB, body, listSpec, func(db *gorm.DB) *gorm.DB {
+		return db.Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to query, err: %v", err)
+		return output.Failure(c, err)
+	}
+
+	list := make([]Webhook, 0, len(recordList))
+	for _, v := range recordList {
+		eventTypeList := make([]string, 0)
+		if v.EventTypeList != "" {
+			eventTypeList = strings.Split(v.EventTypeList, ",")
+		}
+		list = append(list, Webhook{
+			ID:            v.ID,
+			Code:          v.Code,
+			URL:           v.URL,
+			EventTypeList: eventTypeList,
+			ModifiedBy:    v.ModifiedBy,
+			UpdatedAt:     v.UpdatedAt,
+		})
+	}
+
+	return output.List(c, list, info)
+}
+
+// deadLetterListSpec is what the list of dead letters supports, newest first by default.
+var deadLetterListSpec = dal.ListSpec{
+	Columns: map[string]string{
+		dal.FilterSystemCode: "system_code",
+	},
+	DefaultSort: "-id",
+}
+
+func queryDeadLetter(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := dal.ListQuery{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if body.SystemCode == "" {
+		return output.Failure(c, controller.ErrInvalidInput.WithField("system_code", "'system_code' failed on the 'required' rule"))
+	}
+
+	recordList, info, err := dal.List[model.WebhookDeadLetter](ctx, database.DB, body, deadLetterListSpec)
+	if err != nil {
+		logger.Errorf(ctx, "failed to query, err: %v", err)
+		return output.Failure(c, err)
+	}
+
+	list := make([]DeadLetter, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, DeadLetter{
+			ID:          v.ID,
+			OutboxID:    v.OutboxID,
+			WebhookCode: v.WebhookCode,
+			Attempt:     v.Attempt,
+			LastError:   v.LastError,
+			CreatedAt:   v.CreatedAt,
+		})
+	}
+
+	return output.List(c, list, info)
+}
+
+// retryDeadLetter queues the dead letters for delivery again, starting over with their attempts.
+func retryDeadLetter(c echo.Context) error {
+	ctx := c.Request().Context()
+	body := struct {
+		SystemCode string  `json:"system_code" validate:"required,gt=0"`
+		IDList     []int64 `json:"id_list" validate:"required,gt=0,max=100,dive,gt=0"`
+	}{}
+	if err := input.BindAndValidate(c, &body); err != nil {
+		return output.Failure(c, err)
+	}
+	if err := admin.AuthorizeSystem(ctx, body.SystemCode); err != nil {
+		return output.Failure(c, err)
+	}
+
+	if err := webhook.RetryDeadLetters(ctx, body.SystemCode, util.Deduplicate(body.IDList)); err != nil {
+		if errors.Is(err, webhook.ErrDeadLetterNotFound) {
+			return output.Failure(c, controller.ErrRecordNotFound.WithHint("Some dead letters do not exist"))
+		}
+		logger.Errorf(ctx, "failed to retry dead letters, err: %v, system code: %s", err, body.SystemCode)
+		return output.Failure(c, err)
+	}
+	return output.Success(c, nil)
+}
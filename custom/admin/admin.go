@@ -0,0 +1,127 @@
+// Package admin authenticates the callers of the administration API and guards the operator
+// endpoints, such as /debug/info. Two bearer tokens are known:
+//   - the admin token, set by AC_ADMIN_TOKEN, held by operators and trusted services. Its
+//     requests are not scoped, unless they name a user in define.HeaderAdminUser.
+//   - the gateway token, set by AC_GATEWAY_TOKEN, held by the gateway authenticating the users.
+//     Its requests must name the user in define.HeaderAdminUser and are scoped to what the user
+//     manages, see service/delegation.
+//
+// Requests carrying neither are refused, as are all of them while no token is set.
+package admin
+
+import (
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/define"
+	"ac/custom/meta"
+	"ac/custom/output"
+	"ac/service/delegation"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	EnvToken        = "AC_ADMIN_TOKEN"
+	EnvGatewayToken = "AC_GATEWAY_TOKEN"
+)
+
+// bearer reports whether the request carries the token set by the env as "Authorization: Bearer".
+func bearer(c echo.Context, env string) bool {
+	token := os.Getenv(env)
+	given, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Middleware lets through the requests carrying the admin token.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !bearer(c, EnvToken) {
+				return output.Failure(c, controller.ErrUnauthorized)
+			}
+			return next(c)
+		}
+	}
+}
+
+// Authenticate identifies the caller of the administration API by its token and puts it into
+// the metadata of the request. It must run after meta.Middleware.
+func Authenticate() echo.MiddlewareFunc {
+	return AuthenticateWith(func(c echo.Context, err *controller.Error) error {
+		return output.Failure(c, err)
+	})
+}
+
+// AuthenticateWith is Authenticate for the APIs with their own error format, such as SCIM.
+// fail writes the response to a request refused with err.
+func AuthenticateWith(fail func(c echo.Context, err *controller.Error) error) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			adminUser := strings.TrimSpace(c.Request().Header.Get(define.HeaderAdminUser))
+			token := ""
+			switch {
+			case bearer(c, EnvToken):
+				token = meta.TokenAdmin
+			case bearer(c, EnvGatewayToken) && adminUser != "":
+				token = meta.TokenGateway
+			default:
+				return fail(c, controller.ErrUnauthorized)
+			}
+			req := c.Request()
+			m := meta.FromContext(req.Context())
+			m.Authenticated, m.Token, m.AdminUser = true, token, adminUser
+			c.SetRequest(req.WithContext(meta.NewContext(req.Context(), m)))
+			return next(c)
+		}
+	}
+}
+
+// Authorize checks that the caller may administer below the resource index of the system, an
+// empty index stands for the whole system. It returns the error to respond with, nil when
+// allowed.
+func Authorize(ctx context.Context, systemCode, resourceIndex string) *controller.Error {
+	return toError(ctx, delegation.Authorize(ctx, systemCode, resourceIndex))
+}
+
+// AuthorizeRole checks that the caller may administer everything the role grants. It returns
+// the error to respond with, nil when allowed.
+func AuthorizeRole(ctx context.Context, systemCode, roleCode string) *controller.Error {
+	return toError(ctx, delegation.AuthorizeRole(ctx, systemCode, roleCode))
+}
+
+func toError(ctx context.Context, err error) *controller.Error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, delegation.ErrUnauthenticated):
+		return controller.ErrUnauthorized
+	case errors.Is(err, delegation.ErrOutOfScope):
+		return controller.ErrOutOfScope.WithMsg(err.Error())
+	}
+	logger.Errorf(ctx, "failed to authorize, err: %v", err)
+	return controller.ErrSystemError
+}
+
+// AuthorizeSystem checks that the caller may administer the whole system, for the changes to
+// what belongs to the system as a whole: its users, roles, webhooks and constraints.
+func AuthorizeSystem(ctx context.Context, systemCode string) *controller.Error {
+	return Authorize(ctx, systemCode, "")
+}
+
+// AuthorizeUnscoped checks that the caller is not scoped, for the changes to no system in
+// particular, such as creating one.
+func AuthorizeUnscoped(ctx context.Context) *controller.Error {
+	m := meta.FromContext(ctx)
+	if !m.Authenticated {
+		return controller.ErrUnauthorized
+	}
+	if m.AdminUser != "" {
+		return controller.ErrOutOfScope.WithMsg("only an unscoped administrator may do this")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// Package background builds the context of work that does not come in through the HTTP
+// server, such as gRPC calls and background loops.
+package background
+
+import (
+	"ac/custom/meta"
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// NewContext returns a copy of ctx carrying the metadata of a request. The uri and a new
+// request ID show up in the log fields of everything logged with it.
+func NewContext(ctx context.Context, method, uri string) context.Context {
+	return meta.NewContext(ctx, meta.Meta{
+		Method:    method,
+		URI:       uri,
+		RequestID: uuid.New().String(),
+	})
+}
@@ -1,12 +1,23 @@
 package define
 
 const (
-	PrefixSystem   = "system"
-	PrefixUser     = "user"
-	PrefixRole     = "role"
-	PrefixResource = "resource"
+	PrefixSystem     = "system"
+	PrefixUser       = "user"
+	PrefixRole       = "role"
+	PrefixResource   = "resource"
+	PrefixGroup      = "group"
+	PrefixWebhook    = "webhook"
+	PrefixSod        = "sod"
+	PrefixBreakGlass = "breakglass"
 )
 
+// HeaderAdminUser names the user administering through a request, trusted only from the
+// holders of a token, see custom/admin.
+const HeaderAdminUser = "X-Admin-User"
+
+// HeaderAPIKey identifies the client of a request to the rate limits when it is one of AC_API_KEYS, see custom/limit.
+const HeaderAPIKey = "X-Api-Key"
+
 var ValidAction2Level = map[string]int{
 	"view":     1,
 	"download": 2,
@@ -1,15 +1,64 @@
 package input
 
 import (
+	"ac/controller"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 )
 
+// BindAndValidate binds the request into input and validates it. It fails with
+// controller.ErrInvalidInput listing the invalid fields.
 func BindAndValidate(c echo.Context, input interface{}) error {
 	if err := c.Bind(input); err != nil {
-		return err
+		// The body went past the limit of limit.BodyLimit
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return controller.ErrRequestTooLarge
+		}
+		return controller.ErrInvalidInput.WithMsg(err.Error())
 	}
 	if err := c.Validate(input); err != nil {
-		return err
+		customErr := controller.ErrInvalidInput.WithMsg(err.Error())
+		var validationErrors validator.ValidationErrors
+		if errors.As(err, &validationErrors) {
+			for _, v := range validationErrors {
+				// The namespace starts with the name of the struct, which means nothing to the client,
+				// nor do the Go names of embedded structs, whose fields JSON flattens
+				_, field, _ := strings.Cut(v.Namespace(), ".")
+				field = strings.Join(slices.DeleteFunc(strings.Split(field, "."), func(s string) bool {
+					return s != "" && unicode.IsUpper(rune(s[0]))
+				}), ".")
+				rule := v.Tag()
+				if v.Param() != "" {
+					rule += "=" + v.Param()
+				}
+				customErr = customErr.WithField(field, fmt.Sprintf("'%s' failed on the '%s' rule", field, rule))
+			}
+		}
+		return customErr
 	}
 	return nil
 }
+
+// IfMatch returns the version named by the If-Match header of the request, 0 when the header is
+// absent or is "*". The versions are sent as ETags by output.ETag.
+func IfMatch(c echo.Context) (int64, error) {
+	tag := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if tag == "" || tag == "*" {
+		return 0, nil
+	}
+	tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, controller.ErrInvalidInput.WithHint("Invalid If-Match header")
+	}
+	return version, nil
+}
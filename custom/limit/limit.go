@@ -0,0 +1,208 @@
+// Package limit protects the service from clients sending too much: a token bucket per client
+// and route group, and a cap on the size of request bodies. A client is known by the API key it
+// sends in X-Api-Key when the key is one of AC_API_KEYS, or else by its IP address. Anything
+// else a client sends is ignored, or forging keys would create a bucket per request.
+package limit
+
+import (
+	"ac/controller"
+	"ac/custom/define"
+	"ac/custom/metrics"
+	"ac/custom/output"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// EnvRatePrefix followed by the upper-cased group, e.g. AC_RATE_LIMIT_DECISION, overrides the
+	// limit of a group as "rate,burst", the rate in requests per second. "0" lifts the limit.
+	EnvRatePrefix = "AC_RATE_LIMIT_"
+	// EnvBodyLimit overrides the maximum size of a request body in bytes.
+	EnvBodyLimit = "AC_BODY_LIMIT"
+	// EnvAPIKeys lists the API keys, comma separated, by which the clients are known.
+	EnvAPIKeys = "AC_API_KEYS"
+	// EnvTrustedProxies lists the CIDRs of the proxies, comma separated, trusted to set
+	// X-Forwarded-For. Unset, the IP address of a client is the remote address.
+	EnvTrustedProxies = "AC_TRUSTED_PROXIES"
+
+	DefaultBodyLimit = 1 << 20
+
+	// expiresIn is how long the bucket of an idle client is kept
+	expiresIn = 3 * time.Minute
+)
+
+// Rate is the token bucket of a client: Burst requests at once, refilled at Rate per second.
+type Rate struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter limits the requests of every client to a route group.
+type Limiter struct {
+	group string
+	keys  map[string]struct{}
+	// store is nil when the group is not limited
+	store middleware.RateLimiterStore
+}
+
+// NewLimiter returns the limiter of the group, with the rate set by the environment or def.
+func NewLimiter(group string, def Rate) (*Limiter, error) {
+	r, err := parseRate(os.Getenv(EnvRatePrefix+strings.ToUpper(group)), def)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit of %s, err: %w", group, err)
+	}
+	l := &Limiter{group: group, keys: map[string]struct{}{}}
+	for _, v := range strings.Split(os.Getenv(EnvAPIKeys), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l.keys[v] = struct{}{}
+		}
+	}
+	if r.Rate > 0 {
+		l.store = middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(r.Rate),
+			Burst:     r.Burst,
+			ExpiresIn: expiresIn,
+		})
+	}
+	return l, nil
+}
+
+func parseRate(s string, def Rate) (Rate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, nil
+	}
+	rateStr, burstStr, _ := strings.Cut(s, ",")
+	r := Rate{}
+	var err error
+	if r.Rate, err = strconv.ParseFloat(strings.TrimSpace(rateStr), 64); err != nil || r.Rate < 0 {
+		return Rate{}, fmt.Errorf("invalid rate: %s", s)
+	}
+	r.Burst = int(r.Rate)
+	if burstStr != "" {
+		if r.Burst, err = strconv.Atoi(strings.TrimSpace(burstStr)); err != nil || r.Burst < 0 {
+			return Rate{}, fmt.Errorf("invalid burst: %s", s)
+		}
+	}
+	if r.Rate > 0 && r.Burst < 1 {
+		r.Burst = 1
+	}
+	return r, nil
+}
+
+// client returns the bucket key of a client, its API key if known, else its IP address.
+func (l *Limiter) client(apiKey, ip string) string {
+	if _, ok := l.keys[strings.TrimSpace(apiKey)]; ok {
+		return "key:" + strings.TrimSpace(apiKey)
+	}
+	return "ip:" + ip
+}
+
+// allow takes a token from the bucket of the client.
+func (l *Limiter) allow(client string) bool {
+	if l.store == nil {
+		return true
+	}
+	// The memory store never fails
+	ok, _ := l.store.Allow(client)
+	if !ok {
+		metrics.ObserveRateLimited(l.group)
+	}
+	return ok
+}
+
+// Middleware limits the HTTP requests of the group. A limited request fails with
+// controller.ErrRateLimited and a Retry-After header.
+func (l *Limiter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !l.allow(l.client(c.Request().Header.Get(define.HeaderAPIKey), c.RealIP())) {
+				c.Response().Header().Set("Retry-After", "1")
+				return output.Failure(c, controller.ErrRateLimited)
+			}
+			return next(c)
+		}
+	}
+}
+
+// UnaryServerInterceptor limits the gRPC calls, which share the buckets of the HTTP requests
+// of the group. A limited call fails with codes.ResourceExhausted.
+func (l *Limiter) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	host, apiKey := "", ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		var err error
+		if host, _, err = net.SplitHostPort(p.Addr.String()); err != nil {
+			host = p.Addr.String()
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(strings.ToLower(define.HeaderAPIKey)); len(v) > 0 {
+			apiKey = v[0]
+		}
+	}
+	if !l.allow(l.client(apiKey, host)) {
+		return nil, status.Error(codes.ResourceExhausted, controller.ErrRateLimited.Hint)
+	}
+	return handler(ctx, req)
+}
+
+// IPExtractor returns how echo finds the IP address of a client: the remote address, or the
+// X-Forwarded-For header as far as it is set by the proxies listed in EnvTrustedProxies.
+func IPExtractor() (echo.IPExtractor, error) {
+	v := strings.TrimSpace(os.Getenv(EnvTrustedProxies))
+	if v == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, s := range strings.Split(v, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", EnvTrustedProxies, s)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// BodyLimit caps the size of request bodies at the limit set by EnvBodyLimit, DefaultBodyLimit
+// if unset. A larger body fails with controller.ErrRequestTooLarge, up front when the request
+// declares its length, or else once reading it goes past the limit, see input.BindAndValidate.
+func BodyLimit() (echo.MiddlewareFunc, error) {
+	limit := int64(DefaultBodyLimit)
+	if v := strings.TrimSpace(os.Getenv(EnvBodyLimit)); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s: %s", EnvBodyLimit, v)
+		}
+		limit = n
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.ContentLength > limit {
+				return output.Failure(c, controller.ErrRequestTooLarge)
+			}
+			req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+			return next(c)
+		}
+	}, nil
+}
@@ -0,0 +1,76 @@
+// Package meta carries the metadata of a request in its context.Context, so that the services
+// and the DAL log and authorize a request without depending on the server it came in through.
+package meta
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Meta is the metadata of a request.
+type Meta struct {
+	Method    string
+	URI       string
+	RequestID string
+	// SystemCode is the system the request is about, when the route or the query names it
+	SystemCode string
+	// Authenticated is set once custom/admin has identified the caller by its token
+	Authenticated bool
+	// Token is the kind of token the caller authenticated with, TokenAdmin or TokenGateway
+	Token string
+	// AdminUser is the user administering through the request, scoped by service/delegation,
+	// empty for an unscoped caller
+	AdminUser string
+}
+
+// The kinds of token a caller authenticates with, see custom/admin.
+const (
+	TokenAdmin   = "admin"
+	TokenGateway = "gateway"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying m.
+func NewContext(ctx context.Context, m Meta) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns the metadata carried by ctx, zero when there is none.
+func FromContext(ctx context.Context) Meta {
+	m, _ := ctx.Value(contextKey{}).(Meta)
+	return m
+}
+
+// WithSystemCode returns a copy of ctx whose metadata names the system code, for the requests
+// carrying it in their body.
+func WithSystemCode(ctx context.Context, systemCode string) context.Context {
+	m := FromContext(ctx)
+	m.SystemCode = systemCode
+	return NewContext(ctx, m)
+}
+
+// Middleware puts the metadata of every HTTP request into the context of the request. It must
+// run after the request ID middleware.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			c.SetRequest(req.WithContext(NewContext(req.Context(), Meta{
+				Method:     req.Method,
+				URI:        req.RequestURI,
+				RequestID:  c.Response().Header().Get(echo.HeaderXRequestID),
+				SystemCode: systemCode(c),
+			})))
+			return next(c)
+		}
+	}
+}
+
+func systemCode(c echo.Context) string {
+	if v := c.Param("system_code"); v != "" {
+		return v
+	}
+	return c.QueryParam("system_code")
+}
@@ -0,0 +1,218 @@
+// Package metrics exports the Prometheus metrics of the service on /metrics: the decisions and
+// their enforce latency, the reloads of the shared enforcer, the size of the policy, the
+// database connection pool and the HTTP requests per route.
+package metrics
+
+import (
+	"ac/model"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const namespace = "ac"
+
+// The results of a decision
+const (
+	ResultAllow = "allow"
+	ResultDeny  = "deny"
+	// ResultInvalid is a request naming an unknown user, resource or action
+	ResultInvalid = "invalid"
+	ResultError   = "error"
+)
+
+// Registry holds every metric of the service, the default registry of the client library is
+// left alone so that libraries cannot add to the output unasked.
+var Registry = prometheus.NewRegistry()
+
+var (
+	decisionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decisions_total",
+		Help:      "Number of authorization decisions by system, action and result.",
+	}, []string{"system_code", "action", "result"})
+
+	enforceDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "enforce_duration_seconds",
+		Help:      "Time taken by a Casbin enforce call.",
+		Buckets:   []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+	})
+
+	reloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "enforcer_reload_duration_seconds",
+		Help:      "Time taken to load the policy into the shared enforcer, by result.",
+		Buckets:   prometheus.ExponentialBuckets(.01, 2, 12),
+	}, []string{"result"})
+
+	lastReload = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "enforcer_last_reload_timestamp_seconds",
+		Help:      "Unix time of the last successful policy load of the shared enforcer.",
+	})
+
+	decisionLogDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decision_log_dropped_total",
+		Help:      "Number of decisions left out of the decision log, by reason.",
+	}, []string{"reason"})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of requests refused by the rate limits, by route group.",
+	}, []string{"group"})
+
+	httpRequestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve an HTTP request, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		decisionTotal,
+		enforceDuration,
+		reloadDuration,
+		lastReload,
+		decisionLogDropped,
+		rateLimitedTotal,
+		httpRequestTotal,
+		httpRequestDuration,
+	)
+}
+
+// RegisterDB adds the stats of the connection pool of db and the number of policy rows by
+// ptype, both read on every scrape.
+func RegisterDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get sql.DB, err: %w", err)
+	}
+	if err := Registry.Register(collectors.NewDBStatsCollector(sqlDB, "mysql")); err != nil {
+		return fmt.Errorf("failed to register db stats, err: %w", err)
+	}
+	if err := Registry.Register(&policyCollector{db: db}); err != nil {
+		return fmt.Errorf("failed to register policy rows, err: %w", err)
+	}
+	return nil
+}
+
+// ObserveDecision counts a decision. The system code and action of an invalid request are
+// whatever the client sent, they are counted as "invalid" to keep the number of series bounded.
+func ObserveDecision(systemCode, action, result string) {
+	if result == ResultInvalid {
+		systemCode, action = ResultInvalid, ResultInvalid
+	}
+	decisionTotal.WithLabelValues(systemCode, action, result).Inc()
+}
+
+// ObserveDecisionLogDropped counts n decisions the decision log lost, because its buffer was
+// full or because they could not be written.
+func ObserveDecisionLogDropped(reason string, n int) {
+	decisionLogDropped.WithLabelValues(reason).Add(float64(n))
+}
+
+// ObserveRateLimited counts a request refused by the rate limit of the group.
+func ObserveRateLimited(group string) {
+	rateLimitedTotal.WithLabelValues(group).Inc()
+}
+
+// ObserveEnforce records the latency of an enforce call started at start.
+func ObserveEnforce(start time.Time) {
+	enforceDuration.Observe(time.Since(start).Seconds())
+}
+
+// ObserveReload records a policy load of the shared enforcer started at start.
+func ObserveReload(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	} else {
+		lastReload.SetToCurrentTime()
+	}
+	reloadDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// Middleware records every HTTP request under the route it matched, so that path parameters do
+// not make a series of their own.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			status := c.Response().Status
+			if err != nil {
+				// The error is yet to be written by the error handler
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			httpRequestTotal.WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).Inc()
+			httpRequestDuration.WithLabelValues(c.Request().Method, route).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
+
+// policyCollector counts the rows of casbin_rule by ptype.
+type policyCollector struct {
+	db *gorm.DB
+}
+
+var policyRowsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "policy_rows"),
+	"Number of rows in casbin_rule by ptype.",
+	[]string{"ptype"}, nil,
+)
+
+func (p *policyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- policyRowsDesc
+}
+
+func (p *policyCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var rowList []struct {
+		Ptype string
+		Count int64
+	}
+	err := p.db.WithContext(ctx).Model(&model.CasbinRule{}).
+		Select("ptype, COUNT(*) AS count").Group("ptype").Scan(&rowList).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(policyRowsDesc, fmt.Errorf("failed to count policy rows, err: %w", err))
+		return
+	}
+	for _, v := range rowList {
+		ch <- prometheus.MustNewConstMetric(policyRowsDesc, prometheus.GaugeValue, float64(v.Count), v.Ptype)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TestScrape serves a request and a decision, then scrapes /metrics the way Prometheus does.
+func TestScrape(t *testing.T) {
+	e := echo.New()
+	e.Use(Middleware())
+	e.GET("/metrics", Handler())
+	e.GET("/user/:code", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/user_1", nil))
+	ObserveDecision("system_1", "view", ResultAllow)
+	ObserveDecision("system_forged", "forged", ResultInvalid)
+	ObserveEnforce(time.Now())
+	ObserveReload(time.Now(), nil)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`ac_http_requests_total{method="GET",route="/user/:code",status="204"} 1`,
+		`ac_decisions_total{action="view",result="allow",system_code="system_1"} 1`,
+		`ac_decisions_total{action="invalid",result="invalid",system_code="invalid"} 1`,
+		`ac_enforce_duration_seconds_count 1`,
+		`ac_enforcer_reload_duration_seconds_count{result="success"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape is missing %q", want)
+		}
+	}
+	if strings.Contains(body, "forged") {
+		t.Error("scrape has the labels of an invalid decision")
+	}
+}
@@ -2,7 +2,10 @@ package output
 
 import (
 	"ac/controller"
+	"ac/dal"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
@@ -15,6 +18,12 @@ type Response struct {
 	Data interface{} `json:"data"`
 }
 
+// ETag sets the version of the data about to be written as the ETag of the response, clients
+// send it back in If-Match to make a change conditional on it, see input.IfMatch.
+func ETag(c echo.Context, version int64) {
+	c.Response().Header().Set("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
 func Success(c echo.Context, data interface{}) error {
 	if data == nil {
 		data = struct{}{}
@@ -26,28 +35,52 @@ func Success(c echo.Context, data interface{}) error {
 	})
 }
 
+// List writes a page of a list endpoint.
+func List(c echo.Context, list interface{}, info *dal.PageInfo) error {
+	return Success(c, ListData(list, info))
+}
+
+// ListData returns the data of a page of a list endpoint, for an endpoint adding to it. The
+// total is left out when the request skipped it.
+func ListData(list interface{}, info *dal.PageInfo) map[string]interface{} {
+	data := map[string]interface{}{
+		"list":        list,
+		"next_cursor": info.NextCursor,
+	}
+	if info.Total != nil {
+		data["total"] = *info.Total
+	}
+	return data
+}
+
+// Failure writes err with the HTTP status of its code. An error that is not a *controller.Error
+// is never shown to the client, it becomes ErrDependencyFailure when the database failed,
+// ErrInvalidInput for a list query the list does not support and ErrSystemError otherwise, the
+// caller is expected to have logged it. The invalid fields of the request, if any, are listed
+// in the data.
 func Failure(c echo.Context, err error) error {
-	code := -1
-	msg := ""
-	hint := ""
-
-	// Check if the error is of type *controller.Error
-	if customErr, ok := err.(*controller.Error); ok {
-		// Extract the code, message, and hint from the custom error
-		code = customErr.Code
-		msg = customErr.Msg
-		hint = customErr.Hint
-	} else if err != nil {
-		// If it's not a *controller.Error, fallback to the generic error message
-		msg = err.Error()
+	customErr, ok := err.(*controller.Error)
+	if !ok {
+		switch {
+		case errors.Is(err, dal.ErrDuplicateKey):
+			customErr = controller.ErrAlreadyExists
+		case errors.Is(err, dal.ErrMySQL):
+			customErr = controller.ErrDependencyFailure
+		case errors.Is(err, dal.ErrInvalidListQuery):
+			customErr = controller.ErrInvalidInput.WithMsg(err.Error())
+		default:
+			customErr = controller.ErrSystemError
+		}
 	}
 
-	// Return a JSON response with the error details
-	return c.JSON(http.StatusOK, Response{
-		Code: code,
-		Msg:  msg,
-		Hint: hint,
-		Data: struct{}{}, // Empty data structure
+	var data interface{} = struct{}{}
+	if len(customErr.FieldList) > 0 {
+		data = map[string]interface{}{"field_list": customErr.FieldList}
+	}
+	return c.JSON(customErr.Status(), Response{
+		Code: customErr.Code,
+		Msg:  customErr.Msg,
+		Hint: customErr.Hint,
+		Data: data,
 	})
-
 }
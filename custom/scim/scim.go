@@ -0,0 +1,191 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	ContentType = "application/scim+json"
+
+	ErrTypeInvalidFilter = "invalidFilter"
+	ErrTypeInvalidValue  = "invalidValue"
+	ErrTypeInvalidPath   = "invalidPath"
+	ErrTypeUniqueness    = "uniqueness"
+	ErrTypeNoTarget      = "noTarget"
+)
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// Filter is the only filter form supported by this server: `<attribute> eq "<value>"`.
+type Filter struct {
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses a SCIM filter expression. Attribute names are matched
+// case-insensitively by the caller, so they are returned in lower case.
+func ParseFilter(filter string) (*Filter, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, nil
+	}
+	attribute, rest, ok := strings.Cut(filter, " ")
+	if !ok {
+		return nil, fmt.Errorf("invalid filter: %s", filter)
+	}
+	operator, value, ok := strings.Cut(strings.TrimSpace(rest), " ")
+	if !ok || !strings.EqualFold(operator, "eq") {
+		return nil, fmt.Errorf("unsupported filter operator in: %s", filter)
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("filter value must be a quoted string: %s", filter)
+	}
+	return &Filter{Attribute: strings.ToLower(attribute), Value: value}, nil
+}
+
+// ParseMemberPath extracts the member id from a path such as `members[value eq "user_1"]`.
+// An empty id is returned for the plain `members` path.
+func ParseMemberPath(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if strings.EqualFold(path, "members") {
+		return "", nil
+	}
+	if len(path) < len("members[]") || !strings.EqualFold(path[:len("members[")], "members[") || !strings.HasSuffix(path, "]") {
+		return "", fmt.Errorf("unsupported path: %s", path)
+	}
+	filter, err := ParseFilter(path[len("members[") : len(path)-1])
+	if err != nil {
+		return "", err
+	}
+	if filter == nil || filter.Attribute != "value" {
+		return "", fmt.Errorf("unsupported path: %s", path)
+	}
+	return filter.Value, nil
+}
+
+// ParsePagination converts the 1-based startIndex and count query parameters into an offset and a limit.
+func ParsePagination(startIndex, count string) (int, int, error) {
+	const (
+		defaultCount = 100
+		maxCount     = 500
+	)
+	offset, limit := 0, defaultCount
+	if startIndex != "" {
+		v, err := strconv.Atoi(startIndex)
+		if err != nil {
+			return 0, 0, errors.New("startIndex must be an integer")
+		}
+		if v > 1 {
+			offset = v - 1
+		}
+	}
+	if count != "" {
+		v, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, 0, errors.New("count must be an integer")
+		}
+		if v < 0 {
+			v = 0
+		}
+		limit = min(v, maxCount)
+	}
+	return offset, limit, nil
+}
+
+// Bind decodes the request body. SCIM clients send application/scim+json, which the
+// default echo binder does not accept.
+func Bind(c echo.Context, v interface{}) error {
+	if err := json.NewDecoder(c.Request().Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode request body, err: %w", err)
+	}
+	return nil
+}
+
+func JSON(c echo.Context, status int, v interface{}) error {
+	c.Response().Header().Set(echo.HeaderContentType, ContentType)
+	return c.JSON(status, v)
+}
+
+func Failure(c echo.Context, status int, scimType, detail string) error {
+	return JSON(c, status, Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+func NoContent(c echo.Context) error {
+	return c.NoContent(http.StatusNoContent)
+}
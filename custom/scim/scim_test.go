@@ -0,0 +1,102 @@
+package scim
+
+import (
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		output  *Filter
+		wantErr bool
+	}{
+		{
+			name:   "empty filter",
+			filter: "",
+			output: nil,
+		},
+		{
+			name:   "user name",
+			filter: `userName eq "alice@example.com"`,
+			output: &Filter{Attribute: "username", Value: "alice@example.com"},
+		},
+		{
+			name:   "value with spaces",
+			filter: `displayName EQ "Payment Approvers"`,
+			output: &Filter{Attribute: "displayname", Value: "Payment Approvers"},
+		},
+		{
+			name:    "unsupported operator",
+			filter:  `userName sw "alice"`,
+			wantErr: true,
+		},
+		{
+			name:    "unquoted value",
+			filter:  `userName eq alice`,
+			wantErr: true,
+		},
+		{
+			name:    "missing operator",
+			filter:  `userName`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (result == nil) != (tt.output == nil) || (result != nil && *result != *tt.output) {
+				t.Errorf("expected %+v, got %+v", tt.output, result)
+			}
+		})
+	}
+}
+
+func TestParseMemberPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		output  string
+		wantErr bool
+	}{
+		{
+			name:   "all members",
+			path:   "members",
+			output: "",
+		},
+		{
+			name:   "single member",
+			path:   `members[value eq "user_1"]`,
+			output: "user_1",
+		},
+		{
+			name:    "other attribute",
+			path:    `members[display eq "Alice"]`,
+			wantErr: true,
+		},
+		{
+			name:    "other path",
+			path:    "displayName",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseMemberPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+			if result != tt.output {
+				t.Errorf("expected %q, got %q", tt.output, result)
+			}
+		})
+	}
+}
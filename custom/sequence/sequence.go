@@ -0,0 +1,83 @@
+// Package sequence tracks how far an auto-increment sequence can be read without missing a
+// value. IDs are allocated when a row is inserted but become visible when its transaction
+// commits, so a lower ID can show up after a higher one. A reader that resumes from the
+// highest ID it has seen would skip it.
+package sequence
+
+import (
+	"time"
+)
+
+// Tracker keeps the head of the sequence, the highest ID below which every ID is either
+// visible or given up on, and the visible IDs above it. A missing ID is given up on, taken as
+// rolled back, once the ID after it has been visible for longer than the gap timeout: its
+// transaction would have been open for longer than that. Tracker is not safe for concurrent
+// use.
+type Tracker struct {
+	head       int64
+	gapTimeout time.Duration
+	pending    map[int64]time.Time
+}
+
+func NewTracker(head int64, gapTimeout time.Duration) *Tracker {
+	return &Tracker{
+		head:       head,
+		gapTimeout: gapTimeout,
+		pending:    map[int64]time.Time{},
+	}
+}
+
+// Head returns the highest ID up to which the sequence can be read without missing an ID.
+func (t *Tracker) Head() int64 {
+	return t.head
+}
+
+// Observe records that id is visible since createdAt. It reports whether the ID is new to
+// the tracker.
+func (t *Tracker) Observe(id int64, createdAt time.Time) bool {
+	if id <= t.head {
+		return false
+	}
+	if _, ok := t.pending[id]; ok {
+		return false
+	}
+	t.pending[id] = createdAt
+	return true
+}
+
+// Skip moves the head to id, e.g. to start from a position known to have no open gaps.
+func (t *Tracker) Skip(id int64) {
+	if id <= t.head {
+		return
+	}
+	t.head = id
+	for k := range t.pending {
+		if k <= id {
+			delete(t.pending, k)
+		}
+	}
+}
+
+// Advance moves the head over the visible IDs following it and over the gaps older than the
+// gap timeout at now. It reports whether the head moved.
+func (t *Tracker) Advance(now time.Time) bool {
+	head := t.head
+	for len(t.pending) > 0 {
+		if _, ok := t.pending[t.head+1]; ok {
+			delete(t.pending, t.head+1)
+			t.head++
+			continue
+		}
+		next := int64(0)
+		for k := range t.pending {
+			if next == 0 || k < next {
+				next = k
+			}
+		}
+		if now.Sub(t.pending[next]) < t.gapTimeout {
+			break
+		}
+		t.head = next - 1
+	}
+	return t.head != head
+}
@@ -0,0 +1,76 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerOutOfOrderCommit(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker(0, time.Minute)
+
+	// Two transactions take IDs 1 and 2, the one holding 2 commits first
+	if !tracker.Observe(2, now) {
+		t.Fatal("Observe(2) = false, want true")
+	}
+	if tracker.Advance(now) {
+		t.Fatal("Advance moved the head past the missing ID 1")
+	}
+	if got := tracker.Head(); got != 0 {
+		t.Fatalf("Head() = %d, want 0", got)
+	}
+
+	// The transaction holding 1 commits
+	if !tracker.Observe(1, now.Add(time.Second)) {
+		t.Fatal("Observe(1) = false, want true")
+	}
+	if !tracker.Advance(now.Add(time.Second)) {
+		t.Fatal("Advance did not move the head")
+	}
+	if got := tracker.Head(); got != 2 {
+		t.Fatalf("Head() = %d, want 2", got)
+	}
+	if tracker.Observe(2, now) {
+		t.Fatal("Observe(2) = true for an ID below the head")
+	}
+}
+
+func TestTrackerRolledBackGap(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker(10, time.Minute)
+
+	tracker.Observe(11, now)
+	tracker.Observe(13, now)
+	tracker.Observe(14, now)
+	tracker.Advance(now)
+	if got := tracker.Head(); got != 11 {
+		t.Fatalf("Head() = %d, want 11", got)
+	}
+	tracker.Advance(now.Add(30 * time.Second))
+	if got := tracker.Head(); got != 11 {
+		t.Fatalf("Head() = %d before the gap timeout, want 11", got)
+	}
+
+	// 12 never shows up, its transaction was rolled back
+	tracker.Advance(now.Add(time.Minute))
+	if got := tracker.Head(); got != 14 {
+		t.Fatalf("Head() = %d after the gap timeout, want 14", got)
+	}
+}
+
+func TestTrackerSkip(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker(0, time.Minute)
+
+	tracker.Observe(3, now)
+	tracker.Observe(6, now)
+	tracker.Skip(5)
+	tracker.Advance(now)
+	if got := tracker.Head(); got != 6 {
+		t.Fatalf("Head() = %d, want 6", got)
+	}
+	tracker.Skip(4)
+	if got := tracker.Head(); got != 6 {
+		t.Fatalf("Head() = %d after skipping backwards, want 6", got)
+	}
+}
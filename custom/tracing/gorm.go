@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// GormPlugin returns a gorm plugin starting a client span for every statement, as a child of
+// the span in the context the statement runs with. The SQL is recorded without its values.
+func GormPlugin() gorm.Plugin {
+	return gormPlugin{}
+}
+
+type gormPlugin struct{}
+
+func (gormPlugin) Name() string {
+	return "tracing"
+}
+
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("tracing:before_create", startStatement("create")),
+		callback.Create().After("gorm:create").Register("tracing:after_create", endStatement),
+		callback.Query().Before("gorm:query").Register("tracing:before_query", startStatement("query")),
+		callback.Query().After("gorm:query").Register("tracing:after_query", endStatement),
+		callback.Update().Before("gorm:update").Register("tracing:before_update", startStatement("update")),
+		callback.Update().After("gorm:update").Register("tracing:after_update", endStatement),
+		callback.Delete().Before("gorm:delete").Register("tracing:before_delete", startStatement("delete")),
+		callback.Delete().After("gorm:delete").Register("tracing:after_delete", endStatement),
+		callback.Row().Before("gorm:row").Register("tracing:before_row", startStatement("row")),
+		callback.Row().After("gorm:row").Register("tracing:after_row", endStatement),
+		callback.Raw().Before("gorm:raw").Register("tracing:before_raw", startStatement("raw")),
+		callback.Raw().After("gorm:raw").Register("tracing:after_raw", endStatement),
+	)
+}
+
+func startStatement(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil {
+			return
+		}
+		ctx, span := tracer.Start(db.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.operation", operation),
+		))
+		db.Statement.Context = ctx
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func endStatement(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("db.sql.table", db.Statement.Table),
+		attribute.String("db.statement", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		Fail(span, db.Error)
+	}
+}
@@ -0,0 +1,179 @@
+// Package tracing sets up OpenTelemetry tracing: a span for every HTTP request and gRPC call,
+// continuing the W3C trace context of the caller, for every service call, every Casbin
+// enforce and, through a gorm plugin, every SQL statement.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceName = "ac"
+
+	// EnvExporter names the exporter: "otlp" sends the spans over gRPC to the collector set by
+	// the standard OTEL_EXPORTER_OTLP_* variables, "stdout" writes them to standard output,
+	// for tests, and "none" or nothing turns the export off.
+	EnvExporter = "OTEL_TRACES_EXPORTER"
+)
+
+var (
+	tracer   = otel.Tracer(serviceName)
+	provider *sdktrace.TracerProvider
+)
+
+// Init installs the tracer provider with the exporter named by EnvExporter. The trace context
+// of callers is propagated even when the export is off.
+func Init(ctx context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := strings.TrimSpace(os.Getenv(EnvExporter)); name {
+	case "", "none":
+		return nil
+	case "otlp":
+		exporter, err = otlptracegrpc.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return fmt.Errorf("unknown trace exporter: %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create trace exporter, err: %w", err)
+	}
+	return install(ctx, exporter)
+}
+
+func install(ctx context.Context, exporter sdktrace.SpanExporter) error {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create resource, err: %w", err)
+	}
+	provider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Shutdown exports the spans still buffered and stops the tracer provider.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down tracer provider, err: %w", err)
+	}
+	return nil
+}
+
+// Start starts a span named name as a child of the span in ctx. The caller ends it.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Fail records err on the span and marks it failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Middleware starts the server span of every HTTP request, named by the route it matched, and
+// puts it into the context of the request.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			ctx, span := tracer.Start(ctx, req.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", req.URL.Path),
+			))
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			code := c.Response().Status
+			var he *echo.HTTPError
+			if errors.As(err, &he) {
+				code = he.Code
+			} else if err != nil {
+				code = http.StatusInternalServerError
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", code))
+			if err != nil {
+				Fail(span, err)
+			} else if code >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(code))
+			}
+			return err
+		}
+	}
+}
+
+// UnaryServerInterceptor starts the server span of every gRPC call.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(info.FullMethod, "/"), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.method", info.FullMethod),
+	))
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", status.Code(err).String()))
+	if err != nil {
+		Fail(span, err)
+	}
+	return resp, err
+}
+
+// metadataCarrier reads the trace context from the metadata of a gRPC call.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if v := metadata.MD(m).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keyList := make([]string, 0, len(m))
+	for k := range m {
+		keyList = append(keyList, k)
+	}
+	return keyList
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TestMiddlewarePropagation continues the trace of a caller and nests a service span under the
+// request span, exporting both to stdout.
+func TestMiddlewarePropagation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(buf))
+	if err != nil {
+		t.Fatalf("stdouttrace.New() error = %v", err)
+	}
+	if err := install(context.Background(), exporter); err != nil {
+		t.Fatalf("install() error = %v", err)
+	}
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	e := echo.New()
+	e.Use(Middleware())
+	e.POST("/auth/authenticate", func(c echo.Context) error {
+		_, span := Start(c.Request().Context(), "decision.Check")
+		span.End()
+		return c.NoContent(http.StatusOK)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/auth/authenticate", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{`"Name":"POST /auth/authenticate"`, `"Name":"decision.Check"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exported spans are missing %s", want)
+		}
+	}
+	if n := strings.Count(out, `"TraceID":"`+traceID+`"`); n < 2 {
+		t.Errorf("got %d spans in the trace of the caller, want 2", n)
+	}
+}
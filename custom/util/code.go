@@ -1,11 +1,40 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
+// maxCodeLength matches the width of the code columns in the database.
+const maxCodeLength = 50
+
 func GenerateCode(prefix string) string {
 	return fmt.Sprintf("%s_%s", prefix, uuid.New().String())
 }
+
+// ValidateCode checks a caller-supplied code. The code must start with "<prefix>_",
+// fit into the code column and only contain characters that are safe to use
+// inside a resource index (letters, digits, '_', '-' and '.').
+func ValidateCode(prefix, code string) error {
+	if code == "" {
+		return errors.New("code is empty")
+	}
+	if len(code) > maxCodeLength {
+		return fmt.Errorf("code must not be longer than %d characters", maxCodeLength)
+	}
+	if !strings.HasPrefix(code, prefix+"_") || len(code) == len(prefix)+1 {
+		return fmt.Errorf("code must start with the prefix '%s_'", prefix)
+	}
+	for _, r := range code {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return fmt.Errorf("code contains invalid character '%c'", r)
+		}
+	}
+	return nil
+}
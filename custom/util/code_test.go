@@ -0,0 +1,77 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		code    string
+		wantErr bool
+	}{
+		{
+			name:   "generated code",
+			prefix: "user",
+			code:   GenerateCode("user"),
+		},
+		{
+			name:   "employee id",
+			prefix: "user",
+			code:   "user_E-10086.cn",
+		},
+		{
+			name:    "empty code",
+			prefix:  "user",
+			code:    "",
+			wantErr: true,
+		},
+		{
+			name:    "missing separator",
+			prefix:  "user",
+			code:    "user10086",
+			wantErr: true,
+		},
+		{
+			name:    "prefix only",
+			prefix:  "user",
+			code:    "user_",
+			wantErr: true,
+		},
+		{
+			name:    "wrong prefix",
+			prefix:  "role",
+			code:    "user_10086",
+			wantErr: true,
+		},
+		{
+			name:    "path separator",
+			prefix:  "resource",
+			code:    "resource_a/b",
+			wantErr: true,
+		},
+		{
+			name:    "wildcard",
+			prefix:  "resource",
+			code:    "resource_*",
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			prefix:  "user",
+			code:    "user_" + strings.Repeat("a", 50),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCode(tt.prefix, tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package validator
 
 import (
+	"reflect"
+	"strings"
+
 	"github.com/go-playground/validator/v10"
 )
 
@@ -9,8 +12,17 @@ type CustomValidator struct {
 }
 
 func NewCustomValidator() *CustomValidator {
+	v := validator.New()
+	// Fields are reported by their JSON name, as the client knows them
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
 	return &CustomValidator{
-		validator: validator.New(),
+		validator: v,
 	}
 }
 
@@ -0,0 +1,293 @@
+package dal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrInvalidListQuery is returned by List for a query the list does not support, such as an
+// unknown sort key or a cursor of another query.
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// The filters of a list query, a list supports those its ListSpec maps to a column.
+const (
+	FilterSystemCode = "system_code"
+	FilterNamePrefix = "name_prefix"
+	FilterParentCode = "parent_code"
+	FilterUpdatedAt  = "updated_at"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// ListQuery holds the parameters shared by the list endpoints, their request bodies embed it.
+// A page is picked either by Page or, for lists too long to page through by offset, by Cursor,
+// the NextCursor of the previous page. A zero filter is left out.
+type ListQuery struct {
+	Page     int    `json:"page" validate:"gte=0"`
+	PageSize int    `json:"page_size" validate:"gte=0"`
+	Cursor   string `json:"cursor"`
+	// Sort key, prefixed with "-" for descending order, e.g. "-updated_at"
+	Sort      string `json:"sort"`
+	SkipTotal bool   `json:"skip_total"`
+
+	SystemCode string `json:"system_code"`
+	NamePrefix string `json:"name_prefix"`
+	// A pointer, so that the top level, an empty parent code, can be asked for
+	ParentCode *string `json:"parent_code"`
+	// Range of the update time in unix seconds, from inclusive and to exclusive
+	UpdatedFrom int64 `json:"updated_from" validate:"gte=0"`
+	UpdatedTo   int64 `json:"updated_to" validate:"gte=0"`
+}
+
+// ListSpec describes what a list supports.
+type ListSpec struct {
+	// Columns maps the supported filters to the column they apply to. An empty column accepts
+	// the filter and leaves it to the caller, for a list of a single system whose table has
+	// no system code.
+	Columns map[string]string
+	// SortKeys are the columns the list may be sorted by, id is always allowed and breaks ties
+	SortKeys []string
+	// DefaultSort is the sort of a query naming none, "id" if empty
+	DefaultSort string
+}
+
+// PageInfo describes the page returned by List.
+type PageInfo struct {
+	// Total is the number of records matching the filters, nil when the query skipped it
+	Total *int64
+	// NextCursor picks the next page, empty on the last one
+	NextCursor string
+}
+
+// cursor is the position of the last record of a page. It is handed out base64 encoded, so
+// that clients treat it as opaque.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+var schemaCache = &sync.Map{}
+
+// List returns a page of the records of T matching funcs and the filters of q, in the order of
+// its sort key. Every supported filter and sort key must be a column of T, as must id.
+func List[T Entity](ctx context.Context, db *gorm.DB, q ListQuery, spec ListSpec, funcs ...func(db *gorm.DB) *gorm.DB) ([]T, *PageInfo, error) {
+	filter, err := q.filter(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort, sortKey, desc, err := q.sort(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	conditions := append(append([]func(db *gorm.DB) *gorm.DB{}, funcs...), filter)
+
+	info := &PageInfo{}
+	if !q.SkipTotal {
+		total, err := NewRepo[T]().Count(ctx, db, conditions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		info.Total = &total
+	}
+
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	scopes := conditions
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor, sort)
+		if err != nil {
+			return nil, nil, err
+		}
+		scopes = append(scopes, keyset(sortKey, desc, after))
+	} else if q.Page > 1 {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+			return db.Offset((q.Page - 1) * pageSize)
+		})
+	}
+	scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+		if sortKey != "id" {
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: sortKey}, Desc: desc})
+		}
+		// One more than asked for tells whether there is a next page
+		return db.Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc}).Limit(pageSize + 1)
+	})
+	recordList, err := NewRepo[T]().QueryList(ctx, db, scopes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(recordList) > pageSize {
+		recordList = recordList[:pageSize]
+		info.NextCursor, err = encodeCursor(ctx, db, sort, sortKey, &recordList[pageSize-1])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return recordList, info, nil
+}
+
+// filter returns the condition of the filters of q, failing on a filter the list lacks.
+func (q ListQuery) filter(spec ListSpec) (func(db *gorm.DB) *gorm.DB, error) {
+	column := func(filter string) (string, error) {
+		if c, ok := spec.Columns[filter]; ok {
+			return c, nil
+		}
+		return "", fmt.Errorf("%w: filter %s is not supported", ErrInvalidListQuery, filter)
+	}
+	conditionList := make([]func(db *gorm.DB) *gorm.DB, 0)
+	if q.SystemCode != "" {
+		c, err := column(FilterSystemCode)
+		if err != nil {
+			return nil, err
+		}
+		if c != "" {
+			conditionList = append(conditionList, func(db *gorm.DB) *gorm.DB {
+				return db.Where(clause.Eq{Column: clause.Column{Name: c}, Value: q.SystemCode})
+			})
+		}
+	}
+	if q.NamePrefix != "" {
+		c, err := column(FilterNamePrefix)
+		if err != nil {
+			return nil, err
+		}
+		conditionList = append(conditionList, func(db *gorm.DB) *gorm.DB {
+			return db.Where(clause.Like{Column: clause.Column{Name: c}, Value: escapeLike(q.NamePrefix) + "%"})
+		})
+	}
+	if q.ParentCode != nil {
+		c, err := column(FilterParentCode)
+		if err != nil {
+			return nil, err
+		}
+		conditionList = append(conditionList, func(db *gorm.DB) *gorm.DB {
+			return db.Where(clause.Eq{Column: clause.Column{Name: c}, Value: *q.ParentCode})
+		})
+	}
+	if q.UpdatedFrom > 0 || q.UpdatedTo > 0 {
+		c, err := column(FilterUpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if q.UpdatedFrom > 0 && q.UpdatedTo > 0 && q.UpdatedTo <= q.UpdatedFrom {
+			return nil, fmt.Errorf("%w: updated_to must be after updated_from", ErrInvalidListQuery)
+		}
+		conditionList = append(conditionList, func(db *gorm.DB) *gorm.DB {
+			if q.UpdatedFrom > 0 {
+				db = db.Where(clause.Gte{Column: clause.Column{Name: c}, Value: time.Unix(q.UpdatedFrom, 0).UTC()})
+			}
+			if q.UpdatedTo > 0 {
+				db = db.Where(clause.Lt{Column: clause.Column{Name: c}, Value: time.Unix(q.UpdatedTo, 0).UTC()})
+			}
+			return db
+		})
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		for _, condition := range conditionList {
+			db = condition(db)
+		}
+		return db
+	}, nil
+}
+
+// sort returns the sort of q as given, its key and direction, failing on a key the list lacks.
+func (q ListQuery) sort(spec ListSpec) (string, string, bool, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	if sort == "" {
+		sort = "id"
+	}
+	key, desc := strings.CutPrefix(sort, "-")
+	if key != "id" && !slices.Contains(spec.SortKeys, key) {
+		return "", "", false, fmt.Errorf("%w: sort key %s is not supported", ErrInvalidListQuery, key)
+	}
+	return sort, key, desc, nil
+}
+
+// keyset selects the records after the cursor in the order of the sort key, with the id
+// breaking ties between records sharing a value.
+func keyset(sortKey string, desc bool, after *cursor) func(db *gorm.DB) *gorm.DB {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		if sortKey == "id" {
+			return db.Where(fmt.Sprintf("id %s ?", op), after.ID)
+		}
+		return db.Where(fmt.Sprintf("%s %s ? OR (%s = ? AND id %s ?)", sortKey, op, sortKey, op), after.Value, after.Value, after.ID)
+	}
+}
+
+func encodeCursor[T Entity](ctx context.Context, db *gorm.DB, sort, sortKey string, record *T) (string, error) {
+	sch, err := schema.Parse(record, schemaCache, db.NamingStrategy)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse schema, err: %w", err)
+	}
+	idField := sch.LookUpField("id")
+	sortField := sch.LookUpField(sortKey)
+	if idField == nil || sortField == nil {
+		return "", fmt.Errorf("%w: %s has no column %s or id", ErrInvalidListQuery, sch.Table, sortKey)
+	}
+	rv := reflect.ValueOf(record).Elem()
+	id, _ := idField.ValueOf(ctx, rv)
+	value, _ := sortField.ValueOf(ctx, rv)
+	c := cursor{Sort: sort}
+	c.ID, _ = id.(int64)
+	switch v := value.(type) {
+	case time.Time:
+		c.Value = v.UTC().Format(time.DateTime)
+	case *time.Time:
+		if v != nil {
+			c.Value = v.UTC().Format(time.DateTime)
+		}
+	default:
+		c.Value = fmt.Sprint(v)
+	}
+	raw, err := sonic.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cursor, err: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s, sort string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	c := &cursor{}
+	if err := sonic.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	if c.Sort != sort {
+		return nil, fmt.Errorf("%w: the cursor belongs to a list sorted by %s", ErrInvalidListQuery, c.Sort)
+	}
+	return c, nil
+}
+
+// escapeLike escapes the wildcards of LIKE in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
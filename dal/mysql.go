@@ -2,10 +2,10 @@ package dal
 
 import (
 	"ac/bootstrap/logger"
+	"context"
 	"errors"
 	"fmt"
 
-	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
@@ -13,36 +13,41 @@ type Entity interface {
 	// model.UserChange | model.UserChangeRemind | model.Task | model.User | model.Revoke | model.Transfer | model.TaskV2 | model.SubtaskV2
 }
 
+// Repository reads and writes the records of T. Every method runs on db, pass the tx of
+// Transaction to make it part of a unit of work.
 type Repository[T Entity] interface {
-	Insert(ctx echo.Context, db *gorm.DB, newValue *T) error
-	BatchInsert(ctx echo.Context, db *gorm.DB, valuesToAdd []*T, batchSize int) error
-	Update(ctx echo.Context, db *gorm.DB, newValue *T, funcs ...func(db *gorm.DB) *gorm.DB) error
-	UpdateWithMap(ctx echo.Context, db *gorm.DB, newValue map[string]interface{}, funcs ...func(db *gorm.DB) *gorm.DB) error
-	Query(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (*T, error)
-	QueryList(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) ([]T, error)
-	Count(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (int64, error)
+	Insert(ctx context.Context, db *gorm.DB, newValue *T) error
+	BatchInsert(ctx context.Context, db *gorm.DB, valuesToAdd []*T, batchSize int) error
+	Update(ctx context.Context, db *gorm.DB, newValue *T, funcs ...func(db *gorm.DB) *gorm.DB) error
+	UpdateWithMap(ctx context.Context, db *gorm.DB, newValue map[string]interface{}, funcs ...func(db *gorm.DB) *gorm.DB) error
+	Query(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (*T, error)
+	QueryList(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) ([]T, error)
+	Count(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (int64, error)
 }
 
 var ErrMySQL = errors.New("MySQL error occurred")
 
+// ErrDuplicateKey is returned along with ErrMySQL by a write breaking a unique key.
+var ErrDuplicateKey = gorm.ErrDuplicatedKey
+
 type Repo[T Entity] struct{}
 
 func NewRepo[T Entity]() *Repo[T] {
 	return &Repo[T]{}
 }
 
-func logWithError(ctx echo.Context, operation string, err error) {
+func logWithError(ctx context.Context, operation string, err error) {
 	if err == nil {
 		return
 	}
 	logger.Errorf(ctx, "operation: %s, error: %s", operation, err)
 }
 
-func (r *Repo[T]) Insert(ctx echo.Context, db *gorm.DB, newValue *T) error {
+func (r *Repo[T]) Insert(ctx context.Context, db *gorm.DB, newValue *T) error {
 	if newValue == nil {
 		return fmt.Errorf("invalid argument: newValue is nil")
 	}
-	result := db.WithContext(ctx.Request().Context()).Create(newValue)
+	result := db.WithContext(ctx).Create(newValue)
 	if result.Error != nil {
 		logWithError(ctx, "insert", result.Error)
 		return errors.Join(ErrMySQL, fmt.Errorf("failed to insert record, err: %w", result.Error))
@@ -50,7 +55,7 @@ func (r *Repo[T]) Insert(ctx echo.Context, db *gorm.DB, newValue *T) error {
 	return nil
 }
 
-func (r *Repo[T]) BatchInsert(ctx echo.Context, db *gorm.DB, valuesToAdd []*T, batchSize int) error {
+func (r *Repo[T]) BatchInsert(ctx context.Context, db *gorm.DB, valuesToAdd []*T, batchSize int) error {
 	if len(valuesToAdd) == 0 {
 		return fmt.Errorf("invalid argument: valuesToAdd is empty")
 	}
@@ -62,7 +67,7 @@ func (r *Repo[T]) BatchInsert(ctx echo.Context, db *gorm.DB, valuesToAdd []*T, b
 	if batchSize <= 0 {
 		batchSize = 10
 	}
-	result := db.WithContext(ctx.Request().Context()).CreateInBatches(valuesToAdd, batchSize)
+	result := db.WithContext(ctx).CreateInBatches(valuesToAdd, batchSize)
 	if result.Error != nil {
 		logWithError(ctx, "batch insert", result.Error)
 		return errors.Join(ErrMySQL, fmt.Errorf("failed to batch insert records, err: %w", result.Error))
@@ -70,11 +75,11 @@ func (r *Repo[T]) BatchInsert(ctx echo.Context, db *gorm.DB, valuesToAdd []*T, b
 	return nil
 }
 
-func (r *Repo[T]) Update(ctx echo.Context, db *gorm.DB, newValue *T, funcs ...func(db *gorm.DB) *gorm.DB) error {
+func (r *Repo[T]) Update(ctx context.Context, db *gorm.DB, newValue *T, funcs ...func(db *gorm.DB) *gorm.DB) error {
 	if newValue == nil {
 		return fmt.Errorf("invalid argument: newValue is nil")
 	}
-	result := db.WithContext(ctx.Request().Context()).Model(new(T)).Scopes(funcs...).Updates(newValue)
+	result := db.WithContext(ctx).Model(new(T)).Scopes(funcs...).Updates(newValue)
 	if result.Error != nil {
 		logWithError(ctx, "update", result.Error)
 		return errors.Join(ErrMySQL, fmt.Errorf("failed to update record, err: %w", result.Error))
@@ -82,11 +87,11 @@ func (r *Repo[T]) Update(ctx echo.Context, db *gorm.DB, newValue *T, funcs ...fu
 	return nil
 }
 
-func (r *Repo[T]) UpdateWithMap(ctx echo.Context, db *gorm.DB, newValue map[string]interface{}, funcs ...func(db *gorm.DB) *gorm.DB) error {
+func (r *Repo[T]) UpdateWithMap(ctx context.Context, db *gorm.DB, newValue map[string]interface{}, funcs ...func(db *gorm.DB) *gorm.DB) error {
 	if newValue == nil {
 		return fmt.Errorf("invalid argument: newValue is nil")
 	}
-	result := db.WithContext(ctx.Request().Context()).Model(new(T)).Scopes(funcs...).Updates(newValue)
+	result := db.WithContext(ctx).Model(new(T)).Scopes(funcs...).Updates(newValue)
 	if result.Error != nil {
 		logWithError(ctx, "update with map", result.Error)
 		return errors.Join(ErrMySQL, fmt.Errorf("failed to update with map, err: %w", result.Error))
@@ -94,8 +99,8 @@ func (r *Repo[T]) UpdateWithMap(ctx echo.Context, db *gorm.DB, newValue map[stri
 	return nil
 }
 
-func (r *Repo[T]) Delete(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) error {
-	result := db.WithContext(ctx.Request().Context()).Model(new(T)).Scopes(funcs...).Delete(new(T))
+func (r *Repo[T]) Delete(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) error {
+	result := db.WithContext(ctx).Model(new(T)).Scopes(funcs...).Delete(new(T))
 	if result.Error != nil {
 		logWithError(ctx, "delete", result.Error)
 		return errors.Join(ErrMySQL, fmt.Errorf("failed to delete record, err: %w", result.Error))
@@ -103,9 +108,9 @@ func (r *Repo[T]) Delete(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.D
 	return nil
 }
 
-func (r *Repo[T]) Query(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (*T, error) {
+func (r *Repo[T]) Query(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (*T, error) {
 	var record T
-	result := db.WithContext(ctx.Request().Context()).Scopes(funcs...).Limit(1).Find(&record)
+	result := db.WithContext(ctx).Scopes(funcs...).Limit(1).Find(&record)
 	if result.Error != nil {
 		logWithError(ctx, "query one", result.Error)
 		return nil, errors.Join(ErrMySQL, fmt.Errorf("failed to query one record, err: %w", result.Error))
@@ -117,9 +122,9 @@ func (r *Repo[T]) Query(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB
 	return &record, nil
 }
 
-func (r *Repo[T]) QueryList(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) ([]T, error) {
+func (r *Repo[T]) QueryList(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) ([]T, error) {
 	var recordList []T
-	result := db.WithContext(ctx.Request().Context()).Scopes(funcs...).Find(&recordList)
+	result := db.WithContext(ctx).Scopes(funcs...).Find(&recordList)
 	if result.Error != nil {
 		logWithError(ctx, "query list", result.Error)
 		return nil, errors.Join(ErrMySQL, fmt.Errorf("failed to query list of records, err: %w", result.Error))
@@ -127,9 +132,9 @@ func (r *Repo[T]) QueryList(ctx echo.Context, db *gorm.DB, funcs ...func(db *gor
 	return recordList, nil
 }
 
-func (r *Repo[T]) Count(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (int64, error) {
+func (r *Repo[T]) Count(ctx context.Context, db *gorm.DB, funcs ...func(db *gorm.DB) *gorm.DB) (int64, error) {
 	var count int64
-	result := db.WithContext(ctx.Request().Context()).Model(new(T)).Scopes(funcs...).Count(&count)
+	result := db.WithContext(ctx).Model(new(T)).Scopes(funcs...).Count(&count)
 	if result.Error != nil {
 		logWithError(ctx, "count", result.Error)
 		return 0, errors.Join(ErrMySQL, fmt.Errorf("failed to count records, err: %w", result.Error))
@@ -137,21 +142,9 @@ func (r *Repo[T]) Count(ctx echo.Context, db *gorm.DB, funcs ...func(db *gorm.DB
 	return count, nil
 }
 
-func Paginate(page, pageSize int) func(db *gorm.DB) *gorm.DB {
-	const (
-		defaultPageSize = 10
-		maxPageSize     = 100
-	)
-	return func(db *gorm.DB) *gorm.DB {
-		if page <= 0 {
-			page = 1
-		}
-		if pageSize <= 0 {
-			pageSize = defaultPageSize
-		} else if pageSize > maxPageSize {
-			pageSize = maxPageSize
-		}
-		offset := (page - 1) * pageSize
-		return db.Offset(offset).Limit(pageSize)
-	}
+// Transaction runs fn as a unit of work, committed when fn returns nil and rolled back
+// otherwise. The repository calls of fn take part in it when given tx, a Transaction called
+// with tx nests as a savepoint.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return db.WithContext(ctx).Transaction(fn)
 }
@@ -2,23 +2,43 @@ package main
 
 import (
 	"ac/bootstrap"
+	"ac/bootstrap/database"
+	"ac/bootstrap/lifecycle"
 	"ac/bootstrap/logger"
 	"ac/controller/auth"
+	"ac/controller/break_glass"
+	"ac/controller/changefeed"
+	"ac/controller/health"
 	"ac/controller/permission"
 	"ac/controller/resource"
 	"ac/controller/role"
+	"ac/controller/rpc"
+	"ac/controller/scim"
+	"ac/controller/sod"
 
 	"ac/controller/system"
 	"ac/controller/user"
 
 	"ac/controller/user_role"
+	"ac/controller/webhook"
+	"ac/custom/admin"
+	"ac/custom/limit"
+	"ac/custom/meta"
+	"ac/custom/metrics"
 	"ac/custom/output"
+	"ac/custom/tracing"
 	"ac/custom/validator"
+	changefeedService "ac/service/changefeed"
+	"ac/service/decisionlog"
+	"ac/service/idempotency"
+	ruleService "ac/service/rule"
+	webhookService "ac/service/webhook"
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -26,16 +46,32 @@ import (
 )
 
 func main() {
-	// Initialize the system
-	err := bootstrap.Initialize()
+	if err := run(); err != nil {
+		// The logger may have failed to start, or be closed already
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run starts the service and serves until it receives SIGINT or SIGTERM, or a component fails.
+func run() error {
+	app, err := lifecycle.New()
 	if err != nil {
-		panic(fmt.Errorf("failed to initialize, err: %w", err))
+		return fmt.Errorf("failed to create app, err: %w", err)
 	}
 
 	e := echo.New()
 	e.HideBanner = true
 	e.Validator = validator.NewCustomValidator()
+	ipExtractor, err := limit.IPExtractor()
+	if err != nil {
+		return fmt.Errorf("failed to create IP extractor, err: %w", err)
+	}
+	e.IPExtractor = ipExtractor
 	e.Use(middleware.RequestID())
+	e.Use(tracing.Middleware())
+	e.Use(meta.Middleware())
+	e.Use(metrics.Middleware())
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus:   true,
 		LogLatency:  true,
@@ -47,47 +83,128 @@ func main() {
 				"status":    v.Status,
 			}
 			if v.Error == nil {
-				logger.LogWith(c, logger.LevelInfo, "success", kv)
+				logger.LogWith(c.Request().Context(), logger.LevelInfo, "success", kv)
 			} else {
 				kv["error"] = v.Error.Error()
-				logger.LogWith(c, logger.LevelError, "failure", kv)
+				logger.LogWith(c.Request().Context(), logger.LevelError, "failure", kv)
 			}
 			return v.Error
 		},
 	}))
 	e.Use(middleware.Recover())
+	bodyLimit, err := limit.BodyLimit()
+	if err != nil {
+		return fmt.Errorf("failed to create body limit, err: %w", err)
+	}
+	e.Use(bodyLimit)
 
-	system.RegisterRoutes(e.Group("/system"))
-	user.RegisterRoutes(e.Group("/user"))
-	role.RegisterRoutes(e.Group("/role"))
-	resource.RegisterRoutes(e.Group("/resource"))
-	user_role.RegisterRoutes(e.Group("/user-role"))
-	permission.RegisterRoutes(e.Group("/permission"))
-	auth.RegisterRoutes(e.Group("/auth"))
+	// Every client gets a token bucket per route group, the decisions are cheap and called far
+	// more often than the administration endpoints, whose writes hold the MySQL pool longer
+	decisionLimiter, err := limit.NewLimiter("decision", limit.Rate{Rate: 1000, Burst: 2000})
+	if err != nil {
+		return fmt.Errorf("failed to create rate limiter, err: %w", err)
+	}
+	adminLimiter, err := limit.NewLimiter("admin", limit.Rate{Rate: 50, Burst: 100})
+	if err != nil {
+		return fmt.Errorf("failed to create rate limiter, err: %w", err)
+	}
+	decisionLimit, adminLimit := decisionLimiter.Middleware(), adminLimiter.Middleware()
+
+	// The administration API refuses the callers it cannot identify
+	authenticate := admin.Authenticate()
+	// Retried writes with the same Idempotency-Key get the stored response for a day
+	idempotent := idempotency.Middleware(24 * time.Hour)
+	system.RegisterRoutes(e.Group("/system", adminLimit, authenticate, idempotent))
+	user.RegisterRoutes(e.Group("/user", adminLimit, authenticate, idempotent))
+	role.RegisterRoutes(e.Group("/role", adminLimit, authenticate, idempotent))
+	resource.RegisterRoutes(e.Group("/resource", adminLimit, authenticate, idempotent))
+	user_role.RegisterRoutes(e.Group("/user-role", adminLimit, authenticate, idempotent))
+	permission.RegisterRoutes(e.Group("/permission", adminLimit, authenticate, idempotent))
+	auth.RegisterRoutes(e.Group("/auth", decisionLimit))
+	scim.RegisterRoutes(e.Group("/scim/v2/:system_code", adminLimit))
+	scim.RegisterMappingRoutes(e.Group("/scim-mapping", adminLimit, authenticate, idempotent))
+	changefeed.RegisterRoutes(e.Group("/changefeed", adminLimit, authenticate))
+	webhook.RegisterRoutes(e.Group("/webhook", adminLimit, authenticate))
+	sod.RegisterRoutes(e.Group("/sod", adminLimit, authenticate))
+	break_glass.RegisterRoutes(e.Group("/break-glass", adminLimit, authenticate))
+	health.RegisterRoutes(e.Group(""))
 
 	// Output all routes
 	printRoutes(e)
 
+	e.GET("/metrics", metrics.Handler())
 	e.GET("/", func(c echo.Context) error {
 		return output.Success(c, nil)
 	})
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
-	// Start server
-	go func() {
-		if err := e.Start(":8080"); err != nil && err != http.ErrServerClosed {
-			panic(fmt.Errorf("failed to start server, err: %w", err))
-		}
-	}()
+	grpcServer := rpc.NewServer(decisionLimiter)
+	var httpListener, grpcListener net.Listener
 
-	// Wait for interrupt signal to gracefully shut down the server with a timeout of 10 seconds.
-	<-ctx.Done()
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		panic(fmt.Errorf("failed to shutdown server, err: %w", err))
-	}
+	// The components start in this order and stop in the reverse one: the servers stop taking
+	// requests and drain those in flight before the loops finish their round and MySQL closes
+	app.Append(lifecycle.Hook{
+		Name: "bootstrap",
+		Start: func(ctx context.Context) error {
+			if err := bootstrap.Initialize(); err != nil {
+				return fmt.Errorf("failed to initialize, err: %w", err)
+			}
+			if err := metrics.RegisterDB(database.DB); err != nil {
+				return fmt.Errorf("failed to register metrics, err: %w", err)
+			}
+			return nil
+		},
+		Stop: bootstrap.Shutdown,
+	})
+	// Follow the policy changes written by other instances
+	app.Append(lifecycle.Loop("changefeed", func(ctx context.Context) {
+		changefeedService.Run(ctx, time.Second)
+	}))
+	// Write the decision log
+	app.Append(lifecycle.Loop("decision log", func(ctx context.Context) {
+		decisionlog.Run(ctx, time.Second)
+	}))
+	// Deliver the webhook events
+	app.Append(lifecycle.Loop("webhook", func(ctx context.Context) {
+		webhookService.Run(ctx, time.Second)
+	}))
+	// Clean up the expired role memberships and break-glass grants
+	app.Append(lifecycle.Loop("expiry", func(ctx context.Context) {
+		ruleService.RunExpiry(ctx, time.Minute)
+	}))
+	// Drop the expired idempotency keys
+	app.Append(lifecycle.Loop("idempotency purge", func(ctx context.Context) {
+		idempotency.RunPurge(ctx, time.Hour)
+	}))
+	// The gRPC decision server listens on its own port
+	app.Append(lifecycle.Hook{
+		Name: "grpc server",
+		Start: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", ":9090")
+			grpcListener = listener
+			return err
+		},
+		Serve: func() error {
+			return grpcServer.Serve(grpcListener)
+		},
+		Stop: grpcServer.Shutdown,
+	})
+	app.Append(lifecycle.Hook{
+		Name: "http server",
+		Start: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", ":8080")
+			httpListener = listener
+			return err
+		},
+		Serve: func() error {
+			e.Listener = httpListener
+			if err := e.Start(""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		Stop: e.Shutdown,
+	})
+	return app.Run(context.Background())
 }
 
 func printRoutes(e *echo.Echo) {
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+)
+
+// BreakGlass represents the break_glass table, an emergency grant of access to a user. The
+// access itself is a policy marked with MarkerBreakGlass, the record stays for the review.
+type BreakGlass struct {
+	ID            int64      `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode    string     `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code;comment:'system_code'"`
+	Code          string     `gorm:"column:code;type:varchar(50);not null;default:'';uniqueIndex:uk_code;comment:'code'"`
+	LogID         int64      `gorm:"column:log_id;type:int;not null;default:0;comment:'casbin_rule_log ID'"`
+	UserCode      string     `gorm:"column:user_code;type:varchar(50);not null;default:'';index:idx_user_code;comment:'user_code'"`
+	ResourceIndex string     `gorm:"column:resource_index;type:varchar(255);not null;default:'';comment:'resource_index'"`
+	Action        string     `gorm:"column:action;type:varchar(50);not null;default:'';comment:'action'"`
+	Justification string     `gorm:"column:justification;type:varchar(500);not null;default:'';comment:'justification'"`
+	RequestedBy   string     `gorm:"column:requested_by;type:varchar(50);not null;default:'';comment:'requested_by'"`
+	BeginTime     time.Time  `gorm:"column:begin_time;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'begin_time'"`
+	EndTime       time.Time  `gorm:"column:end_time;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'end_time'"`
+	ReviewedBy    string     `gorm:"column:reviewed_by;type:varchar(50);not null;default:'';comment:'reviewed_by'"`
+	ReviewNote    string     `gorm:"column:review_note;type:varchar(500);not null;default:'';comment:'review_note'"`
+	ReviewedAt    *time.Time `gorm:"column:reviewed_at;type:datetime;index:idx_reviewed_at;comment:'reviewed_at'"`
+	CreatedAt     time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (BreakGlass) TableName() string {
+	return "break_glass"
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// DecisionLog represents the decision_log table, a record of every authorization decision.
+type DecisionLog struct {
+	ID            int64  `gorm:"column:id;type:bigint;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode    string `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code_created_at;comment:'system_code'"`
+	UserCode      string `gorm:"column:user_code;type:varchar(50);not null;default:'';index:idx_user_code;comment:'user_code'"`
+	ResourceIndex string `gorm:"column:resource_index;type:varchar(255);not null;default:'';comment:'resource_index'"`
+	Action        string `gorm:"column:action;type:varchar(50);not null;default:'';comment:'action'"`
+	Result        string `gorm:"column:result;type:enum('allow','deny','invalid','error');not null;default:deny;comment:'result'"`
+	// MatchedRule is the policy that granted an allowed decision, its fields joined by ", "
+	MatchedRule string    `gorm:"column:matched_rule;type:varchar(500);not null;default:'';comment:'matched_rule'"`
+	LatencyUs   int64     `gorm:"column:latency_us;type:int;not null;default:0;comment:'latency in microseconds'"`
+	RequestID   string    `gorm:"column:request_id;type:varchar(64);not null;default:'';comment:'request_id'"`
+	CreatedAt   time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;index:idx_system_code_created_at;comment:'created_at'"`
+}
+
+func (DecisionLog) TableName() string {
+	return "decision_log"
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+)
+
+// IdempotencyKey represents the idempotency_key table, a mutating request identified by the
+// Idempotency-Key header its client sent, scoped to the caller. Status stays 0 while the request is being served,
+// afterwards the response is kept until ExpiresAt to be replayed on a retry.
+type IdempotencyKey struct {
+	ID          int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	Key         string    `gorm:"column:key;type:varchar(255);not null;default:'';uniqueIndex:uk_key_path_caller;comment:'key'"`
+	Path        string    `gorm:"column:path;type:varchar(100);not null;default:'';uniqueIndex:uk_key_path_caller;comment:'path'"`
+	Caller      string    `gorm:"column:caller;type:varchar(100);not null;default:'';uniqueIndex:uk_key_path_caller;comment:'token kind and admin user of the caller'"`
+	RequestHash string    `gorm:"column:request_hash;type:varchar(64);not null;default:'';comment:'sha256 of the request body'"`
+	Status      int       `gorm:"column:status;type:int;not null;default:0;comment:'http status of the response'"`
+	Response    string    `gorm:"column:response;type:mediumtext;comment:'response body'"`
+	ExpiresAt   time.Time `gorm:"column:expires_at;type:datetime;not null;default:CURRENT_TIMESTAMP;index:idx_expires_at;comment:'expires_at'"`
+	CreatedAt   time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (IdempotencyKey) TableName() string {
+	return "idempotency_key"
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+)
+
+// ScimGroup represents the scim_group table. Groups are provisioned by an identity provider
+// and their members are granted the role mapped to the display name in scim_role_mapping.
+type ScimGroup struct {
+	ID          int64      `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode  string     `gorm:"column:system_code;type:varchar(50);not null;default:'';uniqueIndex:uk_system_code_code;index:idx_system_code_display_name;comment:'system_code'"`
+	Code        string     `gorm:"column:code;type:varchar(50);not null;default:'';uniqueIndex:uk_system_code_code;comment:'code'"`
+	DisplayName string     `gorm:"column:display_name;type:varchar(100);not null;default:'';index:idx_system_code_display_name;comment:'display_name'"`
+	ExternalID  string     `gorm:"column:external_id;type:varchar(100);not null;default:'';comment:'external_id'"`
+	CreatedAt   time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;comment:'updated_at'"`
+	DeletedAt   *time.Time `gorm:"column:deleted_at;type:datetime;index;comment:'deleted_at'"`
+}
+
+func (ScimGroup) TableName() string {
+	return "scim_group"
+}
+
+// ScimGroupMember represents the scim_group_member table.
+type ScimGroupMember struct {
+	ID        int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	GroupCode string    `gorm:"column:group_code;type:varchar(50);not null;default:'';uniqueIndex:uk_group_code_user_code;comment:'group_code'"`
+	UserCode  string    `gorm:"column:user_code;type:varchar(50);not null;default:'';uniqueIndex:uk_group_code_user_code;index:idx_user_code;comment:'user_code'"`
+	CreatedAt time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (ScimGroupMember) TableName() string {
+	return "scim_group_member"
+}
+
+// ScimRoleMapping represents the scim_role_mapping table. It is configured by the
+// administrators: the members of the SCIM groups named display_name are granted the role.
+type ScimRoleMapping struct {
+	ID          int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode  string    `gorm:"column:system_code;type:varchar(50);not null;default:'';uniqueIndex:uk_system_code_display_name;index:idx_system_code_role_code;comment:'system_code'"`
+	DisplayName string    `gorm:"column:display_name;type:varchar(100);not null;default:'';uniqueIndex:uk_system_code_display_name;comment:'display_name'"`
+	RoleCode    string    `gorm:"column:role_code;type:varchar(50);not null;default:'';index:idx_system_code_role_code;comment:'role_code'"`
+	CreatedAt   time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (ScimRoleMapping) TableName() string {
+	return "scim_role_mapping"
+}
+
+// ScimRoleGrant represents the scim_role_grant table. It records the groupings added by SCIM,
+// so that removing a user from a group does not revoke a role granted by an administrator.
+type ScimRoleGrant struct {
+	ID        int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	UserCode  string    `gorm:"column:user_code;type:varchar(50);not null;default:'';uniqueIndex:uk_user_code_role_code;comment:'user_code'"`
+	RoleCode  string    `gorm:"column:role_code;type:varchar(50);not null;default:'';uniqueIndex:uk_user_code_role_code;comment:'role_code'"`
+	CreatedAt time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (ScimRoleGrant) TableName() string {
+	return "scim_role_grant"
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+)
+
+// SodConstraint represents the sod_constraint table, a set of mutually exclusive roles of a
+// system. A user may hold at most one role of the set, directly or through inheritance.
+type SodConstraint struct {
+	ID          int64      `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode  string     `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code;comment:'system_code'"`
+	Code        string     `gorm:"column:code;type:varchar(50);not null;default:'';uniqueIndex:uk_code;comment:'code'"`
+	Name        string     `gorm:"column:name;type:varchar(50);not null;default:'';comment:'name'"`
+	Description string     `gorm:"column:description;type:varchar(200);not null;default:'';comment:'description'"`
+	ModifiedBy  string     `gorm:"column:modified_by;type:varchar(50);not null;default:'';comment:'modified_by'"`
+	CreatedAt   time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;comment:'updated_at'"`
+	DeletedAt   *time.Time `gorm:"column:deleted_at;type:datetime;index;comment:'deleted_at'"`
+}
+
+func (SodConstraint) TableName() string {
+	return "sod_constraint"
+}
+
+// SodConstraintRole represents the sod_constraint_role table, the roles of a constraint.
+type SodConstraintRole struct {
+	ID             int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	ConstraintCode string    `gorm:"column:constraint_code;type:varchar(50);not null;default:'';uniqueIndex:uk_constraint_code_role_code;comment:'constraint_code'"`
+	RoleCode       string    `gorm:"column:role_code;type:varchar(50);not null;default:'';uniqueIndex:uk_constraint_code_role_code;index:idx_role_code;comment:'role_code'"`
+	CreatedAt      time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (SodConstraintRole) TableName() string {
+	return "sod_constraint_role"
+}
@@ -11,16 +11,23 @@ const (
 
 // Subject represents the subject table.
 type Subject struct {
-	ID          int64      `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
-	SystemCode  string     `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code_name_type;comment:'id'"`
-	Type        string     `gorm:"column:type;type:enum('user','role');not null;default:user;index:idx_system_code_name_type;comment:'type'"`
-	Name        string     `gorm:"column:name;type:varchar(50);not null;default:'';index:idx_system_code_name_type;comment:'name'"`
-	Code        string     `gorm:"column:code;type:varchar(50);not null;default:'';uniqueIndex:uk_system_code_code;comment:'code'"`
-	Description string     `gorm:"column:description;type:varchar(50);not null;default:'';comment:'description'"`
-	ModifiedBy  string     `gorm:"column:modified_by;type:varchar(50);not null;default:'';comment:'modified_by'"`
-	CreatedAt   time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
-	UpdatedAt   time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;comment:'updated_at'"`
-	DeletedAt   *time.Time `gorm:"column:deleted_at;type:datetime;index;comment:'deleted_at'"`
+	ID         int64  `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode string `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code_name_type;uniqueIndex:uk_system_code_external_id;comment:'id'"`
+	Type       string `gorm:"column:type;type:enum('user','role');not null;default:user;index:idx_system_code_name_type;comment:'type'"`
+	Name       string `gorm:"column:name;type:varchar(50);not null;default:'';index:idx_system_code_name_type;comment:'name'"`
+	Code       string `gorm:"column:code;type:varchar(50);not null;default:'';uniqueIndex:uk_system_code_code;comment:'code'"`
+	ExternalID string `gorm:"column:external_id;type:varchar(100);not null;default:'';comment:'external_id'"`
+	// ExternalIDKey is the external id of a live subject, NULL when it is empty or the subject is
+	// deleted. Its unique key lets no two live subjects of a system share an external id, while
+	// any number of them may have none.
+	ExternalIDKey     *string    `gorm:"column:external_id_key;type:varchar(100) GENERATED ALWAYS AS (IF(external_id = '' OR deleted_at IS NOT NULL, NULL, external_id)) VIRTUAL;->;uniqueIndex:uk_system_code_external_id;comment:'external_id_key'"`
+	Description       string     `gorm:"column:description;type:varchar(50);not null;default:'';comment:'description'"`
+	PermissionVersion int64      `gorm:"column:permission_version;type:int;not null;default:1;comment:'permission_version'"`
+	RoleVersion       int64      `gorm:"column:role_version;type:int;not null;default:1;comment:'role_version'"`
+	ModifiedBy        string     `gorm:"column:modified_by;type:varchar(50);not null;default:'';comment:'modified_by'"`
+	CreatedAt         time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+	UpdatedAt         time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;comment:'updated_at'"`
+	DeletedAt         *time.Time `gorm:"column:deleted_at;type:datetime;index;comment:'deleted_at'"`
 }
 
 func (Subject) TableName() string {
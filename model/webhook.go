@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+)
+
+// Webhook represents the webhook table, a subscription of a URL to the events of one system.
+type Webhook struct {
+	ID            int64      `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode    string     `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code;comment:'system_code'"`
+	Code          string     `gorm:"column:code;type:varchar(50);not null;default:'';uniqueIndex:uk_code;comment:'code'"`
+	URL           string     `gorm:"column:url;type:varchar(500);not null;default:'';comment:'url'"`
+	Secret        string     `gorm:"column:secret;type:varchar(100);not null;default:'';comment:'secret'"`
+	EventTypeList string     `gorm:"column:event_type_list;type:varchar(500);not null;default:'';comment:'comma separated event types, empty means all'"`
+	ModifiedBy    string     `gorm:"column:modified_by;type:varchar(50);not null;default:'';comment:'modified_by'"`
+	CreatedAt     time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;comment:'updated_at'"`
+	DeletedAt     *time.Time `gorm:"column:deleted_at;type:datetime;index;comment:'deleted_at'"`
+}
+
+func (Webhook) TableName() string {
+	return "webhook"
+}
+
+// WebhookOutbox represents the webhook_outbox table. Events are written in the transaction of
+// the change they describe and fanned out to the subscriptions afterwards.
+type WebhookOutbox struct {
+	ID           int64      `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode   string     `gorm:"column:system_code;type:varchar(50);not null;default:'';comment:'system_code'"`
+	EventType    string     `gorm:"column:event_type;type:varchar(50);not null;default:'';comment:'event_type'"`
+	Payload      string     `gorm:"column:payload;type:text;not null;comment:'payload'"`
+	CreatedAt    time.Time  `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+	DispatchedAt *time.Time `gorm:"column:dispatched_at;type:datetime;index:idx_dispatched_at;comment:'dispatched_at'"`
+}
+
+func (WebhookOutbox) TableName() string {
+	return "webhook_outbox"
+}
+
+// WebhookDelivery represents the webhook_delivery table, an event still to be delivered to a webhook.
+type WebhookDelivery struct {
+	ID            int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	OutboxID      int64     `gorm:"column:outbox_id;type:int;not null;default:0;comment:'webhook_outbox ID'"`
+	WebhookCode   string    `gorm:"column:webhook_code;type:varchar(50);not null;default:'';comment:'webhook_code'"`
+	Attempt       int       `gorm:"column:attempt;type:int;not null;default:0;comment:'attempt'"`
+	NextAttemptAt time.Time `gorm:"column:next_attempt_at;type:datetime;not null;default:CURRENT_TIMESTAMP;index:idx_next_attempt_at;comment:'next_attempt_at'"`
+	LastError     string    `gorm:"column:last_error;type:varchar(500);not null;default:'';comment:'last_error'"`
+	CreatedAt     time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_delivery"
+}
+
+// WebhookDeadLetter represents the webhook_dead_letter table, deliveries that ran out of attempts.
+type WebhookDeadLetter struct {
+	ID          int64     `gorm:"column:id;type:int;primaryKey;autoIncrement;comment:'id'"`
+	SystemCode  string    `gorm:"column:system_code;type:varchar(50);not null;default:'';index:idx_system_code;comment:'system_code'"`
+	OutboxID    int64     `gorm:"column:outbox_id;type:int;not null;default:0;comment:'webhook_outbox ID'"`
+	WebhookCode string    `gorm:"column:webhook_code;type:varchar(50);not null;default:'';comment:'webhook_code'"`
+	Attempt     int       `gorm:"column:attempt;type:int;not null;default:0;comment:'attempt'"`
+	LastError   string    `gorm:"column:last_error;type:varchar(500);not null;default:'';comment:'last_error'"`
+	CreatedAt   time.Time `gorm:"column:created_at;type:datetime;not null;default:CURRENT_TIMESTAMP;comment:'created_at'"`
+}
+
+func (WebhookDeadLetter) TableName() string {
+	return "webhook_dead_letter"
+}
@@ -0,0 +1,149 @@
+// Package breakglass grants emergency access during incidents. A grant is a policy marked with
+// model.MarkerBreakGlass that expires through its time window like any other policy, it is
+// logged and announced loudly and stays in the report until somebody reviews it. The marker
+// lets the grant sit next to a policy the user already holds on the resource, so that it can
+// elevate it, and leaves that policy as it is once the grant expires.
+package breakglass
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/define"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/rule"
+	"ac/service/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxDuration caps how long a grant lasts.
+const MaxDuration = 4 * time.Hour
+
+var (
+	ErrAlreadyGranted  = errors.New("the user already holds a break-glass grant on the resource")
+	ErrNotFound        = errors.New("break-glass grant not found")
+	ErrAlreadyReviewed = errors.New("break-glass grant already reviewed")
+)
+
+// Grant is the data of the webhook event.
+type Grant struct {
+	Code          string `json:"code"`
+	UserCode      string `json:"user_code"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+	Justification string `json:"justification"`
+	RequestedBy   string `json:"requested_by"`
+	BeginTime     string `json:"begin_time"`
+	EndTime       string `json:"end_time"`
+}
+
+func IsCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "breakglass.IsCodeAvailable")
+	defer span.End()
+	if code == "" {
+		return false, errors.New("code is empty")
+	}
+	if !strings.HasPrefix(code, define.PrefixBreakGlass) {
+		return false, fmt.Errorf("code must start with the prefix '%s'", define.PrefixBreakGlass)
+	}
+	record, err := dal.NewRepo[model.BreakGlass]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.BreakGlass{Code: code})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query, err: %w, code: %s", err, code)
+	}
+	return record == nil, nil
+}
+
+// Add grants the access described by record, whose resource index is relative to the system
+// and may end with "/*" to cover the resources below. The policy, the record and the webhook
+// event are written in one transaction.
+func Add(ctx context.Context, record *model.BreakGlass) error {
+	ctx, span := tracing.Start(ctx, "breakglass.Add")
+	defer span.End()
+	if record.EndTime.Sub(record.BeginTime) > MaxDuration {
+		return fmt.Errorf("break-glass access lasts at most %s", MaxDuration)
+	}
+	ruleToAdd := []rule.Rule{{
+		PType: model.PTypePolicy,
+		V0:    record.UserCode,
+		V1:    record.SystemCode + "/" + record.ResourceIndex,
+		V2:    record.Action,
+		V3:    record.BeginTime,
+		V4:    record.EndTime,
+		V5:    model.MarkerBreakGlass,
+	}}
+	err := rule.AddWith(ctx, ruleToAdd, func(tx *gorm.DB, logID int64) error {
+		record.LogID = logID
+		if err := dal.NewRepo[model.BreakGlass]().Insert(ctx, tx, record); err != nil {
+			return fmt.Errorf("failed to insert break-glass grant, err: %w", err)
+		}
+		return webhook.Enqueue(ctx, tx, record.SystemCode, webhook.EventBreakGlass, Grant{
+			Code:          record.Code,
+			UserCode:      record.UserCode,
+			ResourceIndex: record.ResourceIndex,
+			Action:        record.Action,
+			Justification: record.Justification,
+			RequestedBy:   record.RequestedBy,
+			BeginTime:     record.BeginTime.Format(time.RFC3339),
+			EndTime:       record.EndTime.Format(time.RFC3339),
+		})
+	})
+	if errors.Is(err, rule.ErrDuplicateRule) {
+		return ErrAlreadyGranted
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.LogWith(ctx, logger.LevelWarn, "break-glass access granted", map[string]interface{}{
+		"severity":       "high",
+		"code":           record.Code,
+		"system_code":    record.SystemCode,
+		"user_code":      record.UserCode,
+		"resource_index": record.ResourceIndex,
+		"action":         record.Action,
+		"justification":  record.Justification,
+		"requested_by":   record.RequestedBy,
+		"end_time":       record.EndTime.Format(time.RFC3339),
+	})
+	return nil
+}
+
+// Review closes a grant, it leaves the report but the access keeps running until it expires.
+func Review(ctx context.Context, systemCode, code, reviewedBy, note string) error {
+	ctx, span := tracing.Start(ctx, "breakglass.Review")
+	defer span.End()
+	record, err := dal.NewRepo[model.BreakGlass]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.BreakGlass{SystemCode: systemCode, Code: code})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query, err: %w, code: %s", err, code)
+	}
+	if record == nil {
+		return ErrNotFound
+	}
+	if record.ReviewedAt != nil {
+		return ErrAlreadyReviewed
+	}
+	now := util.UTCNow()
+	err = dal.NewRepo[model.BreakGlass]().UpdateWithMap(ctx, database.DB, map[string]interface{}{
+		"reviewed_by": reviewedBy,
+		"review_note": note,
+		"reviewed_at": now,
+	}, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.BreakGlass{ID: record.ID}).Where("reviewed_at IS NULL").Limit(1)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update, err: %w, code: %s", err, code)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package casbin
+
+import (
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/model"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	casebinModel "github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	gormAdapterV3 "github.com/casbin/gorm-adapter/v3"
+	"gorm.io/gorm"
+)
+
+// policyAdapter loads the policy from casbin_rule like the gorm adapter with two differences.
+// v5 is left out, it marks how a rule was granted and is not part of the model. And the role
+// memberships outside their time window are dropped, so that g() in the matcher only follows
+// the current ones. A membership keeps its window in v3 and v4 like a policy, both are empty
+// when it is permanent.
+type policyAdapter struct {
+	*gormAdapterV3.Adapter
+	db         *gorm.DB
+	mu         sync.Mutex
+	nextChange time.Time
+}
+
+func newPolicyAdapter(adapter *gormAdapterV3.Adapter, db *gorm.DB) *policyAdapter {
+	return &policyAdapter{Adapter: adapter, db: db}
+}
+
+func (a *policyAdapter) LoadPolicy(m casebinModel.Model) error {
+	var ruleList []model.CasbinRule
+	if err := a.db.Order("id asc").Find(&ruleList).Error; err != nil {
+		return fmt.Errorf("failed to query rule, err: %w", err)
+	}
+	skipped := make([]int64, 0)
+	for _, v := range ruleList {
+		// Rules stored before the policies were written with ptype p have none, LoadPolicyArray
+		// would panic on them. They are left out until UPDATE casbin_rule SET ptype = 'p' WHERE
+		// ptype = '' fixes them.
+		if v.PType == "" {
+			skipped = append(skipped, v.ID)
+			continue
+		}
+		line := []string{v.PType, v.V0, v.V1, v.V2, v.V3, v.V4}
+		for len(line) > 1 && line[len(line)-1] == "" {
+			line = line[:len(line)-1]
+		}
+		if err := persist.LoadPolicyArray(line, m); err != nil {
+			return fmt.Errorf("failed to load rule, err: %w, id: %d", err, v.ID)
+		}
+	}
+	if len(skipped) > 0 {
+		logger.Errorf(background.NewContext(context.Background(), http.MethodGet, "policy adapter"), "skipped rules without ptype, ids: %v", skipped)
+	}
+	nextChange, err := filterMemberships(m, time.Now())
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextChange = nextChange
+	return nil
+}
+
+// NextChange returns when the next loaded window opens or closes, the policy has to be loaded
+// again by then. It is zero when no window lies ahead.
+func (a *policyAdapter) NextChange() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.nextChange
+}
+
+func filterMemberships(m casebinModel.Model, now time.Time) (time.Time, error) {
+	groupingList, err := m.GetPolicy("g", model.PTypeGroup)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get grouping policy, err: %w", err)
+	}
+	var nextChange time.Time
+	removeList := make([][]string, 0)
+	for _, v := range groupingList {
+		// The adapter drops the empty trailing fields, so an open end shortens the rule
+		if len(v) < 4 {
+			continue
+		}
+		endTime := ""
+		if len(v) >= 5 {
+			endTime = v[4]
+		}
+		begin, end, err := ParseWindow(v[3], endTime)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse membership window, err: %w, rule: %v", err, v)
+		}
+		for _, t := range []time.Time{begin, end} {
+			if t.After(now) && (nextChange.IsZero() || t.Before(nextChange)) {
+				nextChange = t
+			}
+		}
+		if (!begin.IsZero() && now.Before(begin)) || (!end.IsZero() && now.After(end)) {
+			removeList = append(removeList, v)
+		}
+	}
+	// Removing shifts the policy slice, so it waits until the walk is done
+	for _, v := range removeList {
+		if _, err := m.RemovePolicy("g", model.PTypeGroup, v); err != nil {
+			return time.Time{}, fmt.Errorf("failed to remove grouping policy, err: %w", err)
+		}
+	}
+	return nextChange, nil
+}
+
+// ParseWindow parses the optional bounds of a membership window, a zero time is an open side.
+func ParseWindow(beginTime, endTime string) (time.Time, time.Time, error) {
+	var begin, end time.Time
+	var err error
+	if beginTime != "" {
+		if begin, err = time.Parse(time.RFC3339, beginTime); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if endTime != "" {
+		if end, err = time.Parse(time.RFC3339, endTime); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return begin, end, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	casebinV2 "github.com/casbin/casbin/v2"
 	casebinModel "github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/util"
 	gormAdapterV3 "github.com/casbin/gorm-adapter/v3"
 	"gorm.io/gorm"
 )
@@ -17,6 +18,63 @@ func NewEnforcer(db *gorm.DB) (*casebinV2.Enforcer, error) {
 		return nil, fmt.Errorf("failed to create adapter, err: %w", err)
 	}
 
+	model, err := newModel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create model, err: %w", err)
+	}
+
+	enforcer, err := casebinV2.NewEnforcer(model, newPolicyAdapter(adapter, db))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+
+	enforcer.AddFunction("actionMatch", actionMatch)
+	enforcer.AddFunction("timeMatch", timeMatch)
+
+	if err := enforcer.LoadPolicy(); err != nil {
+		return nil, fmt.Errorf("failed to load policy, err: %w", err)
+	}
+
+	return enforcer, nil
+}
+
+// Clone copies the policy of the enforcer into a new enforcer without an adapter, so that it
+// can be changed freely without touching casbin_rule.
+func Clone(enforcer *casebinV2.SyncedEnforcer) (*casebinV2.Enforcer, error) {
+	policyList, err := enforcer.GetPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get policy, err: %w", err)
+	}
+	groupingList, err := enforcer.GetGroupingPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get grouping policy, err: %w", err)
+	}
+
+	model, err := newModel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create model, err: %w", err)
+	}
+	clone, err := casebinV2.NewEnforcer(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	clone.AddFunction("actionMatch", actionMatch)
+	clone.AddFunction("timeMatch", timeMatch)
+
+	if len(policyList) > 0 {
+		if _, err := clone.AddPolicies(policyList); err != nil {
+			return nil, fmt.Errorf("failed to add policy, err: %w", err)
+		}
+	}
+	if len(groupingList) > 0 {
+		if _, err := clone.AddGroupingPolicies(groupingList); err != nil {
+			return nil, fmt.Errorf("failed to add grouping policy, err: %w", err)
+		}
+	}
+	return clone, nil
+}
+
+func newModel() (casebinModel.Model, error) {
 	// 定义 Casbin 模型
 	modelText := `
 		[request_definition]
@@ -38,24 +96,27 @@ func NewEnforcer(db *gorm.DB) (*casebinV2.Enforcer, error) {
 				&& timeMatch(p.begin_time, p.end_time)
 	`
 
-	model, err := casebinModel.NewModelFromString(modelText)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create model, err: %w", err)
-	}
+	return casebinModel.NewModelFromString(modelText)
+}
 
-	enforcer, err := casebinV2.NewEnforcer(model, adapter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+// PolicyMatches applies the resource, action and time conditions of the matcher to a policy
+// (subject, resource, action, begin_time, end_time), leaving out the subject.
+func PolicyMatches(policy []string, resource, action string) (bool, error) {
+	if len(policy) < 5 {
+		return false, fmt.Errorf("invalid policy, expected 5 fields, got %d", len(policy))
 	}
-
-	enforcer.AddFunction("actionMatch", actionMatch)
-	enforcer.AddFunction("timeMatch", timeMatch)
-
-	if err := enforcer.LoadPolicy(); err != nil {
-		return nil, fmt.Errorf("failed to load policy, err: %w", err)
+	if !util.KeyMatch(resource, policy[1]) {
+		return false, nil
 	}
-
-	return enforcer, nil
+	ok, err := actionMatch(action, policy[2])
+	if err != nil || !ok.(bool) {
+		return false, err
+	}
+	ok, err = timeMatch(policy[3], policy[4])
+	if err != nil {
+		return false, err
+	}
+	return ok.(bool), nil
 }
 
 func timeMatch(args ...interface{}) (interface{}, error) {
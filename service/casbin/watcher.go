@@ -0,0 +1,224 @@
+package casbin
+
+import (
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/custom/metrics"
+	"ac/custom/tracing"
+	"ac/service/changefeed"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	casebinV2 "github.com/casbin/casbin/v2"
+	gormAdapterV3 "github.com/casbin/gorm-adapter/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+const (
+	minRetryBackoff = time.Second
+	maxRetryBackoff = time.Minute
+)
+
+// Watcher implements persist.Watcher on top of the change feed. Every instance writes its
+// changes to casbin_rule_log, so there is nothing to publish and the watcher only listens.
+type Watcher struct {
+	mu         sync.Mutex
+	callback   func(string) error
+	loaded     int64
+	nextChange func() time.Time
+	reloadAt   time.Time
+	// retryAt is when to try again after a failed reload, backoff doubles with every failure
+	retryAt time.Time
+	backoff time.Duration
+	cancel  context.CancelFunc
+}
+
+// NewWatcher starts a watcher calling the update callback whenever the version of the change
+// feed moves past loaded, the version the policy was loaded at. nextChange, if not nil, tells
+// when the loaded policy goes stale without a change, e.g. as a role membership expires.
+func NewWatcher(loaded int64, nextChange func() time.Time) *Watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Watcher{
+		loaded:     loaded,
+		nextChange: nextChange,
+		cancel:     cancel,
+	}
+	if nextChange != nil {
+		w.reloadAt = nextChange()
+	}
+	go func() {
+		for {
+			version, reloadAt, retryAt := w.state()
+			if !retryAt.IsZero() {
+				// The last reload failed, changes meanwhile do not bring the retry forward
+				timer := time.NewTimer(time.Until(retryAt))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				w.Sync(ctx)
+				continue
+			}
+			waitCtx, cancel := ctx, context.CancelFunc(func() {})
+			if !reloadAt.IsZero() {
+				waitCtx, cancel = context.WithDeadline(ctx, reloadAt)
+			}
+			changefeed.WaitVersion(waitCtx, version)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			w.Sync(ctx)
+		}
+	}()
+	return w
+}
+
+func (w *Watcher) SetUpdateCallback(callback func(string)) error {
+	return w.SetReloadCallback(func(id string) error {
+		callback(id)
+		return nil
+	})
+}
+
+// SetReloadCallback is SetUpdateCallback with a callback reporting whether the reload
+// succeeded. A failed reload is retried with a backoff, the policy is not taken as loaded.
+func (w *Watcher) SetReloadCallback(callback func(string) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update is a no-op, the change is already in casbin_rule_log once the transaction commits.
+func (w *Watcher) Update() error {
+	return nil
+}
+
+func (w *Watcher) Close() {
+	w.cancel()
+}
+
+// Sync calls the update callback if the feed moved since the last call or the loaded policy
+// went stale. The watcher goroutine calls it, the enforcer guards the reload against the
+// decisions running meanwhile. After a failed reload it does nothing until the retry is due.
+func (w *Watcher) Sync(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if now.Before(w.retryAt) {
+		return
+	}
+	version := changefeed.Version()
+	stale := !w.reloadAt.IsZero() && !now.Before(w.reloadAt)
+	if version <= w.loaded && !stale {
+		return
+	}
+	if w.callback != nil {
+		_, span := tracing.Start(ctx, "casbin.LoadPolicy", attribute.Int64("change_id", changefeed.Latest()))
+		err := w.callback(strconv.FormatInt(changefeed.Latest(), 10))
+		span.End()
+		if err != nil {
+			w.backoff = min(max(2*w.backoff, minRetryBackoff), maxRetryBackoff)
+			w.retryAt = now.Add(w.backoff)
+			return
+		}
+	}
+	w.retryAt, w.backoff = time.Time{}, 0
+	w.loaded = max(version, w.loaded)
+	if w.nextChange != nil {
+		w.reloadAt = w.nextChange()
+	}
+}
+
+func (w *Watcher) state() (int64, time.Time, time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.loaded, w.reloadAt, w.retryAt
+}
+
+var (
+	// sharedMu serializes the creation of the shared enforcer, not its use
+	sharedMu       sync.Mutex
+	sharedEnforcer atomic.Pointer[casebinV2.SyncedEnforcer]
+	// lastLoad is the unix nano time of the last successful policy load
+	lastLoad atomic.Int64
+)
+
+// SharedEnforcer returns the enforcer shared by the whole process. It is created on first use
+// and its policy is kept up to date by a Watcher instead of being loaded on every request.
+// A change committed by this instance wakes the watcher right away, but the decisions do not
+// wait for the reload.
+func SharedEnforcer(ctx context.Context, db *gorm.DB) (*casebinV2.SyncedEnforcer, error) {
+	ctx, span := tracing.Start(ctx, "casbin.SharedEnforcer")
+	defer span.End()
+	if enforcer := sharedEnforcer.Load(); enforcer != nil {
+		return enforcer, nil
+	}
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if enforcer := sharedEnforcer.Load(); enforcer != nil {
+		return enforcer, nil
+	}
+
+	adapter, err := gormAdapterV3.NewAdapterByDB(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create adapter, err: %w", err)
+	}
+	model, err := newModel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create model, err: %w", err)
+	}
+	// The policy is loaded by the constructor, changes from here on are picked up by the watcher
+	loaded := changefeed.Version()
+	policyAdapter := newPolicyAdapter(adapter, db)
+	_, loadSpan := tracing.Start(ctx, "casbin.LoadPolicy")
+	start := time.Now()
+	enforcer, err := casebinV2.NewSyncedEnforcer(model, policyAdapter)
+	metrics.ObserveReload(start, err)
+	loadSpan.End()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	enforcer.AddFunction("actionMatch", actionMatch)
+	enforcer.AddFunction("timeMatch", timeMatch)
+
+	watcher := NewWatcher(loaded, policyAdapter.NextChange)
+	if err := enforcer.SetWatcher(watcher); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to set watcher, err: %w", err)
+	}
+	// Replace the default callback, which drops the error of LoadPolicy
+	_ = watcher.SetReloadCallback(func(id string) error {
+		start := time.Now()
+		err := enforcer.LoadPolicy()
+		metrics.ObserveReload(start, err)
+		if err != nil {
+			logger.Errorf(background.NewContext(context.Background(), http.MethodGet, "watcher"), "failed to reload policy, err: %v, change id: %s", err, id)
+			return err
+		}
+		lastLoad.Store(time.Now().UnixNano())
+		return nil
+	})
+
+	sharedEnforcer.Store(enforcer)
+	lastLoad.Store(start.UnixNano())
+	return enforcer, nil
+}
+
+// LastLoad returns when the policy of the shared enforcer was last loaded, zero before the
+// enforcer is created.
+func LastLoad() time.Time {
+	if v := lastLoad.Load(); v != 0 {
+		return time.Unix(0, v)
+	}
+	return time.Time{}
+}
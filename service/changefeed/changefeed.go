@@ -0,0 +1,235 @@
+// Package changefeed exposes the policy changes recorded in casbin_rule_log as an ordered
+// feed. Every write to casbin_rule goes through service/rule, which logs it in the same
+// transaction, so the log IDs are the positions of the feed. IDs are allocated on insert but
+// become visible on commit, so the feed is only handed out up to the first ID still missing.
+package changefeed
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/custom/sequence"
+	"ac/custom/tracing"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"gorm.io/gorm"
+)
+
+type Rule struct {
+	PType string `json:"ptype"`
+	V0    string `json:"v0"`
+	V1    string `json:"v1"`
+	V2    string `json:"v2"`
+	V3    string `json:"v3"`
+	V4    string `json:"v4"`
+}
+
+type Change struct {
+	ID        int64     `json:"id"`
+	Operate   string    `json:"operate"`
+	RuleList  []Rule    `json:"rule_list"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GapTimeout is how long a missing log ID holds the feed back before it is taken as the ID
+// of a rolled back transaction. It has to be longer than any transaction writing rules.
+const GapTimeout = time.Minute
+
+// pollLimit is the number of log IDs read per query while polling.
+const pollLimit = 1000
+
+// List returns at most limit changes with an ID greater than afterID, oldest first. It stops
+// at the head of the feed, so a change committed late with a lower ID is not skipped.
+func List(ctx context.Context, afterID int64, limit int) ([]Change, error) {
+	ctx, span := tracing.Start(ctx, "changefeed.List")
+	defer span.End()
+	head := Latest()
+	if head <= afterID {
+		return []Change{}, nil
+	}
+	recordList, err := dal.NewRepo[model.CasbinRuleLog]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id > ? AND id <= ?", afterID, head).Order("id asc").Limit(limit)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule log, err: %w", err)
+	}
+	list := make([]Change, 0, len(recordList))
+	for _, v := range recordList {
+		ruleList := []model.CasbinRule{}
+		if err := sonic.UnmarshalString(v.Content, &ruleList); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rule log, err: %w, id: %d", err, v.ID)
+		}
+		change := Change{
+			ID:        v.ID,
+			Operate:   v.Operate,
+			RuleList:  make([]Rule, 0, len(ruleList)),
+			CreatedAt: v.CreatedAt,
+		}
+		for _, r := range ruleList {
+			change.RuleList = append(change.RuleList, Rule{PType: r.PType, V0: r.V0, V1: r.V1, V2: r.V2, V3: r.V3, V4: r.V4})
+		}
+		list = append(list, change)
+	}
+	return list, nil
+}
+
+// poll reads the IDs of the log above the head of the feed and feeds them to the tracker.
+// The first poll starts from the newest change older than GapTimeout, every gap below it has
+// been given up on already.
+func poll(ctx context.Context, first bool) error {
+	ctx, span := tracing.Start(ctx, "changefeed.poll")
+	defer span.End()
+	repo := dal.NewRepo[model.CasbinRuleLog]()
+	if first {
+		record, err := repo.Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Select("id").Where("created_at < ?", time.Now().Add(-GapTimeout)).Order("id desc")
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query rule log, err: %w", err)
+		}
+		if record != nil {
+			feed.skip(record.ID)
+		}
+	}
+	afterID := Latest()
+	for {
+		recordList, err := repo.QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "created_at").Where("id > ?", afterID).Order("id asc").Limit(pollLimit)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query rule log, err: %w", err)
+		}
+		feed.observe(recordList, time.Now())
+		if len(recordList) < pollLimit {
+			return nil
+		}
+		afterID = recordList[len(recordList)-1].ID
+	}
+}
+
+// broadcaster tracks the head of the feed for this instance and wakes the waiters when it
+// moves forward or a new change becomes visible.
+type broadcaster struct {
+	mu      sync.Mutex
+	cursor  *sequence.Tracker
+	version int64
+	changed chan struct{}
+}
+
+var feed = &broadcaster{
+	cursor:  sequence.NewTracker(0, GapTimeout),
+	changed: make(chan struct{}),
+}
+
+func (b *broadcaster) observe(recordList []model.CasbinRuleLog, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := false
+	for _, v := range recordList {
+		if b.cursor.Observe(v.ID, v.CreatedAt) {
+			seen = true
+		}
+	}
+	if seen {
+		b.version++
+	}
+	if b.cursor.Advance(now) || seen {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+}
+
+func (b *broadcaster) skip(id int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id <= b.cursor.Head() {
+		return
+	}
+	b.cursor.Skip(id)
+	b.cursor.Advance(time.Now())
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// wait blocks until ready returns true or ctx is done and reports whether it did.
+func (b *broadcaster) wait(ctx context.Context, ready func() bool) bool {
+	for {
+		b.mu.Lock()
+		ok, changed := ready(), b.changed
+		b.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// Notify announces a change written by this instance. service/rule calls it after commit so
+// local waiters do not have to wait for the next poll.
+func Notify(id int64) {
+	feed.observe([]model.CasbinRuleLog{{ID: id, CreatedAt: time.Now()}}, time.Now())
+}
+
+// Latest returns the head of the feed: every change up to it is known to this instance and
+// none can show up below it any more.
+func Latest() int64 {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	return feed.cursor.Head()
+}
+
+// Version returns a counter moving forward whenever a change becomes visible to this
+// instance, including a change committed late below IDs already seen. The policy has to be
+// reloaded when it moves, Latest alone would miss such a change.
+func Version() int64 {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	return feed.version
+}
+
+// Wait blocks until the head of the feed moves past afterID or ctx is done. It reports
+// whether it did.
+func Wait(ctx context.Context, afterID int64) bool {
+	return feed.wait(ctx, func() bool {
+		return feed.cursor.Head() > afterID
+	})
+}
+
+// WaitVersion blocks until Version moves past version or ctx is done. It reports whether it
+// did.
+func WaitVersion(ctx context.Context, version int64) bool {
+	return feed.wait(ctx, func() bool {
+		return feed.version > version
+	})
+}
+
+// Run polls the log for changes written by other instances until ctx is done.
+func Run(ctx context.Context, interval time.Duration) {
+	c := background.NewContext(ctx, http.MethodGet, "changefeed")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	first := true
+	for {
+		if err := poll(c, first); err != nil {
+			logger.Errorf(c, "failed to poll change feed, err: %v", err)
+		} else {
+			first = false
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package decision
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/define"
+	"ac/custom/meta"
+	"ac/custom/metrics"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/model"
+	"ac/service/casbin"
+	"ac/service/decisionlog"
+	"ac/service/resource"
+	"ac/service/subject"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	casebinV2 "github.com/casbin/casbin/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// maxMatchedRuleLength is the length of the matched_rule column of decision_log.
+const maxMatchedRuleLength = 500
+
+var (
+	ErrInvalidAction        = errors.New("invalid action")
+	ErrInvalidUser          = errors.New("invalid user code")
+	ErrInvalidResourceIndex = errors.New("invalid resource index")
+)
+
+type Request struct {
+	SystemCode    string `json:"system_code"`
+	UserCode      string `json:"user_code"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+}
+
+type Result struct {
+	Request    Request `json:"request"`
+	Authorized bool    `json:"authorized"`
+	Err        error   `json:"-"`
+}
+
+type Permission struct {
+	FromCode      string `json:"from_code"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+	BeginTime     string `json:"begin_time"`
+	EndTime       string `json:"end_time"`
+}
+
+// Check decides whether the user may perform the action on the resource index.
+func Check(ctx context.Context, req Request) (bool, error) {
+	ctx, span := tracing.Start(ctx, "decision.Check")
+	defer span.End()
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return false, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	return check(ctx, enforcer, req)
+}
+
+// BatchCheck decides several requests in one call. A request that is
+// invalid only fails its own result.
+func BatchCheck(ctx context.Context, reqList []Request) ([]Result, error) {
+	ctx, span := tracing.Start(ctx, "decision.BatchCheck")
+	defer span.End()
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	resultList := make([]Result, 0, len(reqList))
+	for _, v := range reqList {
+		authorized, err := check(ctx, enforcer, v)
+		resultList = append(resultList, Result{Request: v, Authorized: authorized, Err: err})
+	}
+	return resultList, nil
+}
+
+// Explain decides the request like Check and also returns the policy that granted it.
+func Explain(ctx context.Context, req Request) (bool, *Permission, error) {
+	ctx, span := tracing.Start(ctx, "decision.Explain")
+	defer span.End()
+	if err := validate(ctx, req); err != nil {
+		return false, nil, err
+	}
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	_, enforceSpan := tracing.Start(ctx, "casbin.EnforceEx", attribute.String("system_code", req.SystemCode), attribute.String("action", req.Action))
+	start := time.Now()
+	authorized, explainList, err := enforcer.EnforceEx(req.UserCode, req.SystemCode+req.ResourceIndex, req.Action)
+	metrics.ObserveEnforce(start)
+	enforceSpan.SetAttributes(attribute.Bool("authorized", authorized))
+	enforceSpan.End()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to enforce, err: %w", err)
+	}
+	if !authorized || len(explainList) < 5 {
+		return authorized, nil, nil
+	}
+	return authorized, toPermission(explainList), nil
+}
+
+// ListPermissions returns the direct and inherited policies of the user within the system.
+func ListPermissions(ctx context.Context, systemCode, userCode string) ([]Permission, error) {
+	ctx, span := tracing.Start(ctx, "decision.ListPermissions")
+	defer span.End()
+	if ok, err := subject.ValidateUser(ctx, systemCode, userCode); !ok {
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate user, err: %w", err)
+		}
+		return nil, ErrInvalidUser
+	}
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	ruleList, err := enforcer.GetImplicitPermissionsForUser(userCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule list, err: %w", err)
+	}
+	list := make([]Permission, 0, len(ruleList))
+	for _, v := range ruleList {
+		if len(v) < 5 || !strings.HasPrefix(v[1], systemCode+"/") {
+			continue
+		}
+		list = append(list, *toPermission(v))
+	}
+	return list, nil
+}
+
+func check(ctx context.Context, enforcer *casebinV2.SyncedEnforcer, req Request) (bool, error) {
+	ctx = meta.WithSystemCode(ctx, req.SystemCode)
+	if err := validate(ctx, req); err != nil {
+		observe(ctx, req, metrics.ResultInvalid, nil, 0, zap.Error(err))
+		return false, err
+	}
+	_, enforceSpan := tracing.Start(ctx, "casbin.EnforceEx", attribute.String("system_code", req.SystemCode), attribute.String("action", req.Action))
+	start := time.Now()
+	// EnforceEx costs the same as Enforce and tells which rule matched, for the decision log
+	authorized, explainList, err := enforcer.EnforceEx(req.UserCode, req.SystemCode+req.ResourceIndex, req.Action)
+	metrics.ObserveEnforce(start)
+	latency := time.Since(start)
+	enforceSpan.SetAttributes(attribute.Bool("authorized", authorized))
+	enforceSpan.End()
+	if err != nil {
+		observe(ctx, req, metrics.ResultError, nil, latency, zap.Error(err))
+		logger.Errorf(ctx, "failed to enforce, err: %v, system code: %s, user code: %s, resource code: %s", err, req.SystemCode, req.UserCode, req.ResourceIndex)
+		return false, fmt.Errorf("failed to enforce, err: %w", err)
+	}
+	if authorized {
+		observe(ctx, req, metrics.ResultAllow, explainList, latency)
+	} else {
+		observe(ctx, req, metrics.ResultDeny, nil, latency)
+	}
+	return authorized, nil
+}
+
+// observe counts the decision, writes it to the sampled decision log of the logger and records
+// it in the decision log table.
+func observe(ctx context.Context, req Request, result string, matchedRule []string, latency time.Duration, fields ...zap.Field) {
+	metrics.ObserveDecision(req.SystemCode, req.Action, result)
+	rule := strings.Join(matchedRule, ", ")
+	if len(rule) > maxMatchedRuleLength {
+		rule = rule[:maxMatchedRuleLength]
+	}
+	logger.Decision(ctx, result, append([]zap.Field{
+		zap.String("user_code", req.UserCode),
+		zap.String("resource_index", req.ResourceIndex),
+		zap.String("action", req.Action),
+		zap.String("matched_rule", rule),
+		zap.Duration("latency", latency),
+	}, fields...)...)
+	decisionlog.Record(&model.DecisionLog{
+		SystemCode:    req.SystemCode,
+		UserCode:      req.UserCode,
+		ResourceIndex: req.ResourceIndex,
+		Action:        req.Action,
+		Result:        result,
+		MatchedRule:   rule,
+		LatencyUs:     latency.Microseconds(),
+		RequestID:     meta.FromContext(ctx).RequestID,
+		CreatedAt:     util.UTCNow(),
+	})
+}
+
+func validate(ctx context.Context, req Request) error {
+	if _, ok := define.ValidAction2Level[req.Action]; !ok {
+		return ErrInvalidAction
+	}
+	if ok, err := subject.ValidateUser(ctx, req.SystemCode, req.UserCode); !ok {
+		if err != nil {
+			logger.Errorf(ctx, "failed to validate user, err: %v, system code: %s, code: %s", err, req.SystemCode, req.UserCode)
+		}
+		return ErrInvalidUser
+	}
+	return validateResourceIndex(ctx, req.SystemCode, req.ResourceIndex)
+}
+
+func validateResourceIndex(ctx context.Context, systemCode, resourceIndex string) error {
+	partList := strings.Split(resourceIndex, "/")
+	resourceCodeList := make([]string, 0, len(partList))
+	for _, v := range partList {
+		if !strings.HasPrefix(v, define.PrefixResource) {
+			continue
+		}
+		resourceCodeList = append(resourceCodeList, v)
+	}
+	if len(resourceCodeList) == 0 {
+		return ErrInvalidResourceIndex
+	}
+
+	validateResult, err := resource.ValidateBatch(ctx, systemCode, resourceCodeList)
+	if err != nil {
+		return fmt.Errorf("failed to validate resource, err: %w", err)
+	}
+	for _, v := range resourceCodeList {
+		if valid, ok := validateResult[v]; ok && valid {
+			continue
+		}
+		return ErrInvalidResourceIndex
+	}
+	return nil
+}
+
+func toPermission(rule []string) *Permission {
+	return &Permission{
+		FromCode:      rule[0],
+		ResourceIndex: rule[1],
+		Action:        rule[2],
+		BeginTime:     rule[3],
+		EndTime:       rule[4],
+	}
+}
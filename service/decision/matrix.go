@@ -0,0 +1,260 @@
+package decision
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/define"
+	"ac/custom/tracing"
+	"ac/dal"
+	"ac/model"
+	"ac/service/casbin"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const matrixBatchSize = 500
+
+// MatrixColumn is a resource of the matrix. ResourceIndex is the full index, the system code
+// followed by the codes from the root down to the resource.
+type MatrixColumn struct {
+	ResourceIndex string
+	ResourceName  string
+}
+
+// MatrixRow holds the highest action level of a user per column, 0 when there is none.
+type MatrixRow struct {
+	UserCode  string
+	UserName  string
+	LevelList []uint8
+}
+
+type levelGrant struct {
+	pattern string
+	level   uint8
+}
+
+// Matrix computes the highest action level of every user of the system on every resource at
+// the given moment, expanding roles and "/*" policies across the resource tree. Both the
+// policies and the role memberships are taken as they are at that moment. The header is
+// written once everything but the users is loaded, the users are then read and written in
+// batches so the matrix is never held in memory as a whole.
+func Matrix(ctx context.Context, systemCode string, at time.Time, writeHeader func([]MatrixColumn) error, writeRow func(MatrixRow) error) error {
+	ctx, span := tracing.Start(ctx, "decision.Matrix")
+	defer span.End()
+	columnList, err := matrixColumns(ctx, systemCode)
+	if err != nil {
+		return err
+	}
+	pathList := make([]string, 0, len(columnList))
+	for _, v := range columnList {
+		pathList = append(pathList, v.ResourceIndex)
+	}
+
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	policyList, err := enforcer.GetPolicy()
+	if err != nil {
+		return fmt.Errorf("failed to get policy list, err: %w", err)
+	}
+	subjectCode2GrantList := make(map[string][]levelGrant)
+	for _, v := range policyList {
+		grant, ok, err := toLevelGrant(v, systemCode, at)
+		if err != nil {
+			return err
+		}
+		if ok {
+			subjectCode2GrantList[v[0]] = append(subjectCode2GrantList[v[0]], grant)
+		}
+	}
+
+	subjectCode2RoleList, err := groupingsAt(ctx, systemCode, at)
+	if err != nil {
+		return err
+	}
+
+	if err := writeHeader(columnList); err != nil {
+		return err
+	}
+
+	// Roles are shared by many users, so their levels are computed once
+	roleCode2LevelList := make(map[string][]uint8)
+	var lastID int64
+	for {
+		userList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser}).
+				Where("deleted_at IS NULL").Where("id > ?", lastID).Order("id asc").Limit(matrixBatchSize)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query user, err: %w", err)
+		}
+		for _, v := range userList {
+			levelList := make([]uint8, len(columnList))
+			applyGrants(levelList, pathList, subjectCode2GrantList[v.Code])
+
+			for _, roleCode := range implicitRoles(subjectCode2RoleList, v.Code) {
+				roleLevelList, ok := roleCode2LevelList[roleCode]
+				if !ok {
+					roleLevelList = make([]uint8, len(columnList))
+					applyGrants(roleLevelList, pathList, subjectCode2GrantList[roleCode])
+					roleCode2LevelList[roleCode] = roleLevelList
+				}
+				for i, level := range roleLevelList {
+					levelList[i] = max(levelList[i], level)
+				}
+			}
+
+			if err := writeRow(MatrixRow{UserCode: v.Code, UserName: v.Name, LevelList: levelList}); err != nil {
+				return err
+			}
+		}
+		if len(userList) < matrixBatchSize {
+			return nil
+		}
+		lastID = userList[len(userList)-1].ID
+	}
+}
+
+// groupingsAt returns the roles every subject of the system holds directly at the given moment.
+// The enforcer only keeps the memberships in force now, so they are read from casbin_rule.
+func groupingsAt(ctx context.Context, systemCode string, at time.Time) (map[string][]string, error) {
+	groupingList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.CasbinRule{PType: model.PTypeGroup}).
+			Where("v0 IN (?)", database.DB.Model(&model.Subject{}).Select("code").Where(model.Subject{SystemCode: systemCode}))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grouping, err: %w", err)
+	}
+	subjectCode2RoleList := make(map[string][]string)
+	for _, v := range groupingList {
+		begin, end, err := casbin.ParseWindow(v.V3, v.V4)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse membership window, err: %w, id: %d", err, v.ID)
+		}
+		if (!begin.IsZero() && at.Before(begin)) || (!end.IsZero() && at.After(end)) {
+			continue
+		}
+		subjectCode2RoleList[v.V0] = append(subjectCode2RoleList[v.V0], v.V1)
+	}
+	return subjectCode2RoleList, nil
+}
+
+// implicitRoles returns the roles the subject holds directly or through other roles.
+func implicitRoles(subjectCode2RoleList map[string][]string, subjectCode string) []string {
+	seen := map[string]struct{}{subjectCode: {}}
+	list := make([]string, 0)
+	pending := subjectCode2RoleList[subjectCode]
+	for len(pending) > 0 {
+		next := make([]string, 0)
+		for _, v := range pending {
+			if _, ok := seen[v]; ok {
+				continue
+			}
+			seen[v] = struct{}{}
+			list = append(list, v)
+			next = append(next, subjectCode2RoleList[v]...)
+		}
+		pending = next
+	}
+	return list
+}
+
+// matrixColumns returns the resources of the system sorted by index, so the resources below
+// a "/*" policy are a contiguous range.
+func matrixColumns(ctx context.Context, systemCode string) ([]MatrixColumn, error) {
+	resourceList, err := dal.NewRepo[model.Resource]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Resource{SystemCode: systemCode}).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query resource, err: %w", err)
+	}
+	code2Resource := make(map[string]model.Resource, len(resourceList))
+	for _, v := range resourceList {
+		code2Resource[v.Code] = v
+	}
+
+	columnList := make([]MatrixColumn, 0, len(resourceList))
+	for _, v := range resourceList {
+		codeList, nameList := []string{}, []string{}
+		for current := v; ; {
+			codeList = append(codeList, current.Code)
+			nameList = append(nameList, current.Name)
+			if current.ParentCode == "" {
+				break
+			}
+			// A parent that is gone or a loop leaves the resource out of the tree
+			parent, ok := code2Resource[current.ParentCode]
+			if !ok || len(codeList) > len(resourceList) {
+				codeList = nil
+				break
+			}
+			current = parent
+		}
+		if codeList == nil {
+			continue
+		}
+		slices.Reverse(codeList)
+		slices.Reverse(nameList)
+		columnList = append(columnList, MatrixColumn{
+			ResourceIndex: systemCode + "/" + strings.Join(codeList, "/"),
+			ResourceName:  strings.Join(nameList, "/"),
+		})
+	}
+	sort.Slice(columnList, func(i, j int) bool {
+		return columnList[i].ResourceIndex < columnList[j].ResourceIndex
+	})
+	return columnList, nil
+}
+
+// applyGrants raises levelList to the level of every grant matching the sorted paths, with the
+// keyMatch semantics of the casbin model.
+func applyGrants(levelList []uint8, pathList []string, grantList []levelGrant) {
+	for _, v := range grantList {
+		prefix, wildcard := strings.CutSuffix(v.pattern, "*")
+		if !wildcard {
+			if i := sort.SearchStrings(pathList, v.pattern); i < len(pathList) && pathList[i] == v.pattern {
+				levelList[i] = max(levelList[i], v.level)
+			}
+			continue
+		}
+		for i := sort.SearchStrings(pathList, prefix); i < len(pathList) && strings.HasPrefix(pathList[i], prefix); i++ {
+			if len(pathList[i]) > len(prefix) {
+				levelList[i] = max(levelList[i], v.level)
+			}
+		}
+	}
+}
+
+// toLevelGrant turns a policy of the system that is active at the given moment into a levelGrant.
+func toLevelGrant(policy []string, systemCode string, at time.Time) (levelGrant, bool, error) {
+	if len(policy) < 5 || !strings.HasPrefix(policy[1], systemCode+"/") {
+		return levelGrant{}, false, nil
+	}
+	active, err := activeAt(policy[3], policy[4], at)
+	if err != nil {
+		return levelGrant{}, false, fmt.Errorf("failed to parse policy time, err: %w, policy: %v", err, policy)
+	}
+	level, ok := define.ValidAction2Level[policy[2]]
+	if !ok || !active {
+		return levelGrant{}, false, nil
+	}
+	return levelGrant{pattern: policy[1], level: uint8(level)}, true, nil
+}
+
+func activeAt(beginTime, endTime string, at time.Time) (bool, error) {
+	begin, err := time.Parse(time.RFC3339, beginTime)
+	if err != nil {
+		return false, err
+	}
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return false, err
+	}
+	return !at.Before(begin) && !at.After(end), nil
+}
@@ -0,0 +1,317 @@
+package decision
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/define"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/casbin"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	casebinV2 "github.com/casbin/casbin/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidSubject = errors.New("invalid subject code")
+	ErrInvalidRole    = errors.New("invalid role code")
+	ErrRuleExists     = errors.New("rule already exists")
+	ErrRuleNotFound   = errors.New("rule not found")
+)
+
+// Kinds of a change found by WhatIf.
+const (
+	ChangeGained = "gained"
+	ChangeLost   = "lost"
+)
+
+// ProposedPolicy is a p rule as taken by /permission/add, the resource index is relative to the system.
+type ProposedPolicy struct {
+	SubjectCode   string
+	ResourceIndex string
+	Action        string
+	BeginTime     time.Time
+	EndTime       time.Time
+}
+
+// ProposedUserRole is a g rule.
+type ProposedUserRole struct {
+	UserCode string
+	RoleCode string
+}
+
+type Proposal struct {
+	PolicyAddList      []ProposedPolicy
+	PolicyDeleteList   []ProposedPolicy
+	UserRoleAddList    []ProposedUserRole
+	UserRoleDeleteList []ProposedUserRole
+}
+
+type AccessChange struct {
+	UserCode      string `json:"user_code"`
+	UserName      string `json:"user_name"`
+	ResourceIndex string `json:"resource_index"`
+	ResourceName  string `json:"resource_name"`
+	Action        string `json:"action"`
+	Change        string `json:"change"`
+}
+
+// WhatIf applies the proposal to an in-memory copy of the live policy and returns the
+// (resource, action) pairs that users of the system would gain or lose at the given moment.
+// Nothing is written to casbin_rule.
+func WhatIf(ctx context.Context, systemCode string, proposal Proposal, at time.Time) ([]AccessChange, error) {
+	ctx, span := tracing.Start(ctx, "decision.WhatIf")
+	defer span.End()
+	policyAddList, err := toPolicyRules(ctx, systemCode, proposal.PolicyAddList)
+	if err != nil {
+		return nil, err
+	}
+	policyDeleteList, err := toPolicyRules(ctx, systemCode, proposal.PolicyDeleteList)
+	if err != nil {
+		return nil, err
+	}
+	groupingAddList, err := toGroupingRules(ctx, systemCode, proposal.UserRoleAddList)
+	if err != nil {
+		return nil, err
+	}
+	groupingDeleteList, err := toGroupingRules(ctx, systemCode, proposal.UserRoleDeleteList)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	before, err := casbin.Clone(live)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone enforcer, err: %w", err)
+	}
+	after, err := casbin.Clone(live)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone enforcer, err: %w", err)
+	}
+	if err := applyProposal(after, policyAddList, policyDeleteList, groupingAddList, groupingDeleteList); err != nil {
+		return nil, err
+	}
+
+	// Only the users named by the proposal or holding one of its roles may be affected
+	candidateList := make([]string, 0)
+	for _, v := range append(policyAddList, policyDeleteList...) {
+		candidateList = append(candidateList, v[0])
+		for _, enforcer := range []*casebinV2.Enforcer{before, after} {
+			userCodeList, err := enforcer.GetImplicitUsersForRole(v[0])
+			if err != nil {
+				return nil, fmt.Errorf("failed to get users for role, err: %w, role code: %s", err, v[0])
+			}
+			candidateList = append(candidateList, userCodeList...)
+		}
+	}
+	for _, v := range append(groupingAddList, groupingDeleteList...) {
+		candidateList = append(candidateList, v[0])
+	}
+	if len(candidateList) == 0 {
+		return []AccessChange{}, nil
+	}
+	userList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser}).
+			Where("code IN ?", candidateList).Where("deleted_at IS NULL").Order("code asc")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user, err: %w", err)
+	}
+
+	columnList, err := matrixColumns(ctx, systemCode)
+	if err != nil {
+		return nil, err
+	}
+	pathList := make([]string, 0, len(columnList))
+	for _, v := range columnList {
+		pathList = append(pathList, v.ResourceIndex)
+	}
+	level2Action := make(map[int]string, len(define.ValidAction2Level))
+	for k, v := range define.ValidAction2Level {
+		level2Action[v] = k
+	}
+
+	list := make([]AccessChange, 0)
+	for _, user := range userList {
+		beforeLevelList, err := userLevels(before, systemCode, user.Code, pathList, at)
+		if err != nil {
+			return nil, err
+		}
+		afterLevelList, err := userLevels(after, systemCode, user.Code, pathList, at)
+		if err != nil {
+			return nil, err
+		}
+		for i, column := range columnList {
+			low, high, change := beforeLevelList[i], afterLevelList[i], ChangeGained
+			if low > high {
+				low, high, change = high, low, ChangeLost
+			}
+			// Each level carries the actions below it, so the actions in between change hands
+			for level := int(low) + 1; level <= int(high); level++ {
+				list = append(list, AccessChange{
+					UserCode:      user.Code,
+					UserName:      user.Name,
+					ResourceIndex: column.ResourceIndex,
+					ResourceName:  column.ResourceName,
+					Action:        level2Action[level],
+					Change:        change,
+				})
+			}
+		}
+	}
+	return list, nil
+}
+
+func applyProposal(enforcer *casebinV2.Enforcer, policyAddList, policyDeleteList, groupingAddList, groupingDeleteList [][]string) error {
+	if len(policyDeleteList) > 0 {
+		if ok, err := enforcer.RemovePolicies(policyDeleteList); err != nil {
+			return fmt.Errorf("failed to remove policy, err: %w", err)
+		} else if !ok {
+			return ErrRuleNotFound
+		}
+	}
+	if len(groupingDeleteList) > 0 {
+		// A loaded membership may carry its window after the user and the role
+		storedList := make([][]string, 0, len(groupingDeleteList))
+		for _, v := range groupingDeleteList {
+			ruleList, err := enforcer.GetFilteredGroupingPolicy(0, v...)
+			if err != nil {
+				return fmt.Errorf("failed to get grouping policy, err: %w", err)
+			}
+			if len(ruleList) == 0 {
+				return ErrRuleNotFound
+			}
+			storedList = append(storedList, ruleList...)
+		}
+		groupingDeleteList = storedList
+		if ok, err := enforcer.RemoveGroupingPolicies(groupingDeleteList); err != nil {
+			return fmt.Errorf("failed to remove grouping policy, err: %w", err)
+		} else if !ok {
+			return ErrRuleNotFound
+		}
+	}
+	if len(policyAddList) > 0 {
+		if ok, err := enforcer.AddPolicies(policyAddList); err != nil {
+			return fmt.Errorf("failed to add policy, err: %w", err)
+		} else if !ok {
+			return ErrRuleExists
+		}
+	}
+	if len(groupingAddList) > 0 {
+		for _, v := range groupingAddList {
+			if ruleList, err := enforcer.GetFilteredGroupingPolicy(0, v...); err != nil {
+				return fmt.Errorf("failed to get grouping policy, err: %w", err)
+			} else if len(ruleList) > 0 {
+				return ErrRuleExists
+			}
+		}
+		if ok, err := enforcer.AddGroupingPolicies(groupingAddList); err != nil {
+			return fmt.Errorf("failed to add grouping policy, err: %w", err)
+		} else if !ok {
+			return ErrRuleExists
+		}
+	}
+	return nil
+}
+
+// userLevels returns the highest action level of the user per path, like a row of Matrix.
+func userLevels(enforcer *casebinV2.Enforcer, systemCode, userCode string, pathList []string, at time.Time) ([]uint8, error) {
+	ruleList, err := enforcer.GetImplicitPermissionsForUser(userCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permissions for user, err: %w, user code: %s", err, userCode)
+	}
+	grantList := make([]levelGrant, 0, len(ruleList))
+	for _, v := range ruleList {
+		grant, ok, err := toLevelGrant(v, systemCode, at)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			grantList = append(grantList, grant)
+		}
+	}
+	levelList := make([]uint8, len(pathList))
+	applyGrants(levelList, pathList, grantList)
+	return levelList, nil
+}
+
+func toPolicyRules(ctx context.Context, systemCode string, policyList []ProposedPolicy) ([][]string, error) {
+	ruleList := make([][]string, 0, len(policyList))
+	subjectCodeList := make([]string, 0, len(policyList))
+	for _, v := range policyList {
+		if _, ok := define.ValidAction2Level[v.Action]; !ok {
+			return nil, ErrInvalidAction
+		}
+		resourceIndex := strings.Trim(strings.TrimSpace(v.ResourceIndex), "/")
+		if err := validateResourceIndex(ctx, systemCode, resourceIndex); err != nil {
+			return nil, err
+		}
+		subjectCodeList = append(subjectCodeList, v.SubjectCode)
+		ruleList = append(ruleList, []string{
+			v.SubjectCode,
+			systemCode + "/" + resourceIndex,
+			v.Action,
+			v.BeginTime.UTC().Format(time.RFC3339),
+			v.EndTime.UTC().Format(time.RFC3339),
+		})
+	}
+	if len(subjectCodeList) == 0 {
+		return ruleList, nil
+	}
+	count, err := dal.NewRepo[model.Subject]().Count(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode}).Where("code IN ?", subjectCodeList).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count subject, err: %w", err)
+	}
+	if int(count) != len(util.Deduplicate(subjectCodeList)) {
+		return nil, ErrInvalidSubject
+	}
+	return ruleList, nil
+}
+
+func toGroupingRules(ctx context.Context, systemCode string, userRoleList []ProposedUserRole) ([][]string, error) {
+	ruleList := make([][]string, 0, len(userRoleList))
+	userCodeList := make([]string, 0, len(userRoleList))
+	roleCodeList := make([]string, 0, len(userRoleList))
+	for _, v := range userRoleList {
+		userCodeList = append(userCodeList, v.UserCode)
+		roleCodeList = append(roleCodeList, v.RoleCode)
+		ruleList = append(ruleList, []string{v.UserCode, v.RoleCode})
+	}
+	if len(ruleList) == 0 {
+		return ruleList, nil
+	}
+	if ok, err := countSubjects(ctx, systemCode, model.SubjectTypeUser, userCodeList); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidUser
+	}
+	if ok, err := countSubjects(ctx, systemCode, model.SubjectTypeRole, roleCodeList); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidRole
+	}
+	return ruleList, nil
+}
+
+// countSubjects reports whether every code is a subject of the type in the system.
+func countSubjects(ctx context.Context, systemCode, subjectType string, codeList []string) (bool, error) {
+	codeList = util.Deduplicate(codeList)
+	count, err := dal.NewRepo[model.Subject]().Count(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Type: subjectType}).Where("code IN ?", codeList).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to count subject, err: %w", err)
+	}
+	return int(count) == len(codeList), nil
+}
@@ -0,0 +1,149 @@
+package decision
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/define"
+	"ac/custom/tracing"
+	"ac/dal"
+	"ac/model"
+	"ac/service/casbin"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// How a user is granted the access asked about in WhoCan.
+const (
+	// ViaDirect is a policy of the user on the resource itself.
+	ViaDirect = "direct"
+	// ViaAncestor is a "/*" policy of the user on an ancestor of the resource.
+	ViaAncestor = "ancestor"
+	// ViaRole is a policy of one of the user's roles, Inherited tells whether it is a "/*" policy.
+	ViaRole = "role"
+)
+
+type Grant struct {
+	Via           string `json:"via"`
+	RoleCode      string `json:"role_code"`
+	RoleName      string `json:"role_name"`
+	Inherited     bool   `json:"inherited"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+	BeginTime     string `json:"begin_time"`
+	EndTime       string `json:"end_time"`
+}
+
+type Access struct {
+	UserCode  string  `json:"user_code"`
+	UserName  string  `json:"user_name"`
+	GrantList []Grant `json:"grant_list"`
+}
+
+// WhoCan returns the users of the system that may currently perform the action on the resource
+// index, with every policy that grants it. It applies the same conditions as Check.
+func WhoCan(ctx context.Context, systemCode, resourceIndex, action string) ([]Access, error) {
+	ctx, span := tracing.Start(ctx, "decision.WhoCan")
+	defer span.End()
+	if _, ok := define.ValidAction2Level[action]; !ok {
+		return nil, ErrInvalidAction
+	}
+	if err := validateResourceIndex(ctx, systemCode, resourceIndex); err != nil {
+		return nil, err
+	}
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	policyList, err := enforcer.GetPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get policy list, err: %w", err)
+	}
+
+	target := systemCode + resourceIndex
+	subjectCode2GrantList := make(map[string][]Grant)
+	for _, v := range policyList {
+		if len(v) < 5 || !strings.HasPrefix(v[1], systemCode+"/") {
+			continue
+		}
+		ok, err := casbin.PolicyMatches(v, target, action)
+		if err != nil {
+			return nil, fmt.Errorf("failed to match policy, err: %w, policy: %v", err, v)
+		}
+		if !ok {
+			continue
+		}
+		subjectCode2GrantList[v[0]] = append(subjectCode2GrantList[v[0]], Grant{
+			Inherited:     v[1] != target,
+			ResourceIndex: v[1],
+			Action:        v[2],
+			BeginTime:     v[3],
+			EndTime:       v[4],
+		})
+	}
+	if len(subjectCode2GrantList) == 0 {
+		return []Access{}, nil
+	}
+
+	subjectCodeList := make([]string, 0, len(subjectCode2GrantList))
+	for k := range subjectCode2GrantList {
+		subjectCodeList = append(subjectCodeList, k)
+	}
+	subjectList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode}).Where("code IN ?", subjectCodeList).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subject, err: %w", err)
+	}
+
+	userCode2GrantList := make(map[string][]Grant)
+	for _, v := range subjectList {
+		grantList := subjectCode2GrantList[v.Code]
+		if v.Type == model.SubjectTypeUser {
+			for _, grant := range grantList {
+				grant.Via = ViaDirect
+				if grant.Inherited {
+					grant.Via = ViaAncestor
+				}
+				userCode2GrantList[v.Code] = append(userCode2GrantList[v.Code], grant)
+			}
+			continue
+		}
+		userCodeList, err := enforcer.GetUsersForRole(v.Code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get users for role, err: %w, role code: %s", err, v.Code)
+		}
+		for _, userCode := range userCodeList {
+			for _, grant := range grantList {
+				grant.Via, grant.RoleCode, grant.RoleName = ViaRole, v.Code, v.Name
+				userCode2GrantList[userCode] = append(userCode2GrantList[userCode], grant)
+			}
+		}
+	}
+
+	userCodeList := make([]string, 0, len(userCode2GrantList))
+	for k := range userCode2GrantList {
+		userCodeList = append(userCodeList, k)
+	}
+	userList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser}).
+			Where("code IN ?", userCodeList).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user, err: %w", err)
+	}
+	list := make([]Access, 0, len(userList))
+	for _, v := range userList {
+		list = append(list, Access{
+			UserCode:  v.Code,
+			UserName:  v.Name,
+			GrantList: userCode2GrantList[v.Code],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UserCode < list[j].UserCode
+	})
+	return list, nil
+}
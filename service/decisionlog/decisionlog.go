@@ -0,0 +1,86 @@
+// Package decisionlog keeps a durable record of every authorization decision in the
+// decision_log table. Record only queues a decision and Run writes the queue in batches, so
+// that the decision path never waits on the database.
+package decisionlog
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/custom/metrics"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// bufferSize bounds the decisions waiting to be written, those beyond it are dropped
+	bufferSize = 10000
+	batchSize  = 500
+)
+
+// The reasons a decision is dropped
+const (
+	DropBufferFull  = "buffer_full"
+	DropWriteFailed = "write_failed"
+)
+
+var queue = make(chan *model.DecisionLog, bufferSize)
+
+// Record queues the decision for Run to write. It never blocks: when the queue is full, because
+// the database is slow or down, the decision is dropped and counted.
+func Record(entry *model.DecisionLog) {
+	select {
+	case queue <- entry:
+	default:
+		metrics.ObserveDecisionLogDropped(DropBufferFull, 1)
+	}
+}
+
+// Run writes the queued decisions every interval, or as soon as a batch is full, until ctx is
+// done. The decisions still queued then are written before it returns.
+func Run(ctx context.Context, interval time.Duration) {
+	// The last batch is written after ctx is done
+	c := background.NewContext(context.WithoutCancel(ctx), http.MethodPost, "decision-log")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]*model.DecisionLog, 0, batchSize)
+	flush := func() {
+		write(c, batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-queue:
+					if batch = append(batch, entry); len(batch) == batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case entry := <-queue:
+			if batch = append(batch, entry); len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func write(ctx context.Context, batch []*model.DecisionLog) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := dal.NewRepo[model.DecisionLog]().BatchInsert(ctx, database.DB, batch, batchSize); err != nil {
+		metrics.ObserveDecisionLogDropped(DropWriteFailed, len(batch))
+		logger.Errorf(ctx, "failed to write decision log, err: %v, count: %d", err, len(batch))
+	}
+}
@@ -0,0 +1,100 @@
+// Package delegation scopes the administration of a system to resource subtrees. The caller
+// is the user custom/admin authenticated the request as. A caller may administer the resources
+// it holds "manage" on, a caller with "manage" on "<system>/*" administers the whole system.
+// Only the holders of the admin token are not scoped, a request that was not authenticated is
+// refused.
+package delegation
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/meta"
+	"ac/custom/tracing"
+	"ac/service/casbin"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const actionManage = "manage"
+
+var (
+	ErrOutOfScope      = errors.New("out of administration scope")
+	ErrUnauthenticated = errors.New("caller is not authenticated")
+)
+
+// Caller returns the user administering through the request, empty when it is not scoped.
+func Caller(ctx context.Context) string {
+	return meta.FromContext(ctx).AdminUser
+}
+
+// caller returns the user administering through the request, failing with ErrUnauthenticated
+// when the request was not authenticated.
+func caller(ctx context.Context) (string, error) {
+	m := meta.FromContext(ctx)
+	if !m.Authenticated {
+		return "", ErrUnauthenticated
+	}
+	return m.AdminUser, nil
+}
+
+// Authorize fails with ErrOutOfScope unless the caller may administer the resource index,
+// relative to the system. An index ending with "/*" is checked against the resource above,
+// an empty index stands for the top level of the system.
+func Authorize(ctx context.Context, systemCode, resourceIndex string) error {
+	ctx, span := tracing.Start(ctx, "delegation.Authorize")
+	defer span.End()
+	callerCode, err := caller(ctx)
+	if err != nil || callerCode == "" {
+		return err
+	}
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	resource := systemCode + "/*"
+	if index := strings.TrimSuffix(strings.Trim(resourceIndex, "/"), "/*"); index != "" && index != "*" {
+		resource = systemCode + "/" + index
+	}
+	_, enforceSpan := tracing.Start(ctx, "casbin.Enforce", attribute.String("system_code", systemCode), attribute.String("action", actionManage))
+	authorized, err := enforcer.Enforce(callerCode, resource, actionManage)
+	enforceSpan.End()
+	if err != nil {
+		return fmt.Errorf("failed to enforce, err: %w, caller: %s, resource: %s", err, callerCode, resource)
+	}
+	if !authorized {
+		return fmt.Errorf("%w, caller: %s, resource: %s", ErrOutOfScope, callerCode, resource)
+	}
+	return nil
+}
+
+// AuthorizeRole fails with ErrOutOfScope unless the caller may administer every resource the
+// role grants access to, directly or through the roles it inherits, so that handing out the
+// role gives nothing beyond the caller's scope.
+func AuthorizeRole(ctx context.Context, systemCode, roleCode string) error {
+	ctx, span := tracing.Start(ctx, "delegation.AuthorizeRole")
+	defer span.End()
+	if callerCode, err := caller(ctx); err != nil || callerCode == "" {
+		return err
+	}
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	ruleList, err := enforcer.GetImplicitPermissionsForUser(roleCode)
+	if err != nil {
+		return fmt.Errorf("failed to get permissions for role, err: %w, role code: %s", err, roleCode)
+	}
+	for _, v := range ruleList {
+		resourceIndex, ok := strings.CutPrefix(v[1], systemCode+"/")
+		if !ok {
+			continue
+		}
+		if err := Authorize(ctx, systemCode, resourceIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
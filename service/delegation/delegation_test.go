@@ -0,0 +1,117 @@
+package delegation
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/meta"
+	"ac/model"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestMain loads the policy the shared enforcer starts from: manage on the whole system, on
+// one subtree, through a role, and expired.
+func TestMain(m *testing.M) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	// Every connection would open a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		panic(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&model.CasbinRule{}); err != nil {
+		panic(err)
+	}
+	now := time.Now().UTC()
+	begin, end := now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)
+	expired := now.Add(-time.Minute).Format(time.RFC3339)
+	ruleList := []model.CasbinRule{
+		{PType: model.PTypePolicy, V0: "user_system", V1: "sys/*", V2: "manage", V3: begin, V4: end},
+		{PType: model.PTypePolicy, V0: "user_subtree", V1: "sys/res_a/*", V2: "manage", V3: begin, V4: end},
+		{PType: model.PTypePolicy, V0: "user_viewer", V1: "sys/*", V2: "view", V3: begin, V4: end},
+		{PType: model.PTypePolicy, V0: "user_expired", V1: "sys/*", V2: "manage", V3: begin, V4: expired},
+		{PType: model.PTypeGroup, V0: "user_role", V1: "role_manager"},
+		{PType: model.PTypePolicy, V0: "role_manager", V1: "sys/res_b/*", V2: "manage", V3: begin, V4: end},
+		{PType: model.PTypePolicy, V0: "role_a", V1: "sys/res_a/res_c", V2: "view", V3: begin, V4: end},
+		{PType: model.PTypePolicy, V0: "role_b", V1: "sys/res_b/res_d", V2: "view", V3: begin, V4: end},
+		{PType: model.PTypeGroup, V0: "role_inheriting_b", V1: "role_b"},
+		{PType: model.PTypePolicy, V0: "role_other_system", V1: "other/res_e", V2: "view", V3: begin, V4: end},
+	}
+	if err := db.Create(&ruleList).Error; err != nil {
+		panic(err)
+	}
+	database.DB = db
+	os.Exit(m.Run())
+}
+
+func callerContext(authenticated bool, adminUser string) context.Context {
+	return meta.NewContext(context.Background(), meta.Meta{Authenticated: authenticated, AdminUser: adminUser})
+}
+
+func TestAuthorize(t *testing.T) {
+	testCases := []struct {
+		name          string
+		authenticated bool
+		adminUser     string
+		resourceIndex string
+		wantErr       error
+	}{
+		{name: "not authenticated", resourceIndex: "res_a", wantErr: ErrUnauthenticated},
+		{name: "unscoped", authenticated: true, resourceIndex: ""},
+		{name: "system on the system", authenticated: true, adminUser: "user_system", resourceIndex: ""},
+		{name: "system on a subtree", authenticated: true, adminUser: "user_system", resourceIndex: "res_b/res_d/*"},
+		{name: "subtree on the system", authenticated: true, adminUser: "user_subtree", resourceIndex: "", wantErr: ErrOutOfScope},
+		{name: "subtree on the system wildcard", authenticated: true, adminUser: "user_subtree", resourceIndex: "*", wantErr: ErrOutOfScope},
+		{name: "subtree inside", authenticated: true, adminUser: "user_subtree", resourceIndex: "res_a/res_c"},
+		{name: "subtree below inside", authenticated: true, adminUser: "user_subtree", resourceIndex: "res_a/res_c/*"},
+		{name: "subtree outside", authenticated: true, adminUser: "user_subtree", resourceIndex: "res_b/res_d", wantErr: ErrOutOfScope},
+		{name: "view only", authenticated: true, adminUser: "user_viewer", resourceIndex: "res_a/res_c", wantErr: ErrOutOfScope},
+		{name: "expired", authenticated: true, adminUser: "user_expired", resourceIndex: "res_a/res_c", wantErr: ErrOutOfScope},
+		{name: "through a role", authenticated: true, adminUser: "user_role", resourceIndex: "res_b/res_d"},
+		{name: "through a role outside", authenticated: true, adminUser: "user_role", resourceIndex: "res_a/res_c", wantErr: ErrOutOfScope},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Authorize(callerContext(tc.authenticated, tc.adminUser), "sys", tc.resourceIndex)
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+				t.Errorf("Authorize() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRole(t *testing.T) {
+	testCases := []struct {
+		name      string
+		adminUser string
+		roleCode  string
+		wantErr   error
+	}{
+		{name: "unscoped", roleCode: "role_b"},
+		{name: "system", adminUser: "user_system", roleCode: "role_inheriting_b"},
+		{name: "subtree inside", adminUser: "user_subtree", roleCode: "role_a"},
+		{name: "subtree outside", adminUser: "user_subtree", roleCode: "role_b", wantErr: ErrOutOfScope},
+		{name: "subtree outside through inheritance", adminUser: "user_subtree", roleCode: "role_inheriting_b", wantErr: ErrOutOfScope},
+		{name: "through a role inside through inheritance", adminUser: "user_role", roleCode: "role_inheriting_b"},
+		{name: "other system left out", adminUser: "user_subtree", roleCode: "role_other_system"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := AuthorizeRole(callerContext(true, tc.adminUser), "sys", tc.roleCode)
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+				t.Errorf("AuthorizeRole() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+	if err := AuthorizeRole(callerContext(false, ""), "sys", "role_a"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("AuthorizeRole() not authenticated = %v, want %v", err, ErrUnauthenticated)
+	}
+}
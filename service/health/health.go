@@ -0,0 +1,207 @@
+// Package health tells whether the service can take traffic and describes the running
+// instance for diagnostics.
+package health
+
+import (
+	"ac/bootstrap"
+	"ac/bootstrap/database"
+	"ac/bootstrap/lifecycle"
+	"ac/bootstrap/logger"
+	"ac/custom/admin"
+	"ac/custom/limit"
+	"ac/custom/tracing"
+	"ac/model"
+	"ac/service/casbin"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// pingTimeout bounds the ping of the database, a probe must answer quickly.
+const pingTimeout = 2 * time.Second
+
+// The names of the readiness checks
+const (
+	CheckDatabase = "database"
+	CheckEnforcer = "enforcer"
+	CheckSchema   = "schema"
+)
+
+// modelList is every table the service reads or writes, created by sql/init.sql.
+var modelList = []interface{}{
+	&model.BreakGlass{},
+	&model.CasbinRule{},
+	&model.CasbinRuleDeleted{},
+	&model.CasbinRuleLog{},
+	&model.DecisionLog{},
+	&model.IdempotencyKey{},
+	&model.Resource{},
+	&model.ScimGroup{},
+	&model.ScimGroupMember{},
+	&model.ScimRoleMapping{},
+	&model.ScimRoleGrant{},
+	&model.SodConstraint{},
+	&model.SodConstraintRole{},
+	&model.Subject{},
+	&model.System{},
+	&model.Webhook{},
+	&model.WebhookOutbox{},
+	&model.WebhookDelivery{},
+	&model.WebhookDeadLetter{},
+}
+
+var (
+	startedAt = time.Now()
+	// schemaChecked is set once the schema has matched the models, it does not change while the
+	// service runs
+	schemaChecked atomic.Bool
+)
+
+// Ready runs the readiness checks and returns the error of each failed one by check name, an
+// empty map when the service can take traffic.
+func Ready(ctx context.Context) map[string]error {
+	ctx, span := tracing.Start(ctx, "health.Ready")
+	defer span.End()
+	db := database.DB
+	failed := map[string]error{}
+	if err := ping(ctx, db); err != nil {
+		failed[CheckDatabase] = err
+		// The other checks need the database
+		return failed
+	}
+	if _, err := casbin.SharedEnforcer(ctx, db); err != nil {
+		failed[CheckEnforcer] = err
+	}
+	if err := checkSchema(ctx, db); err != nil {
+		failed[CheckSchema] = err
+	}
+	return failed
+}
+
+func ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get sql.DB, err: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database, err: %w", err)
+	}
+	return nil
+}
+
+// checkSchema reports the tables and columns of the models missing from the database, which
+// means sql/init.sql has not been applied in its current version.
+func checkSchema(ctx context.Context, db *gorm.DB) error {
+	if schemaChecked.Load() {
+		return nil
+	}
+	db = db.WithContext(ctx)
+	var errList []error
+	cache := &sync.Map{}
+	for _, m := range modelList {
+		s, err := schema.Parse(m, cache, db.NamingStrategy)
+		if err != nil {
+			return fmt.Errorf("failed to parse model, err: %w", err)
+		}
+		if !db.Migrator().HasTable(s.Table) {
+			errList = append(errList, fmt.Errorf("table %s is missing", s.Table))
+			continue
+		}
+		columnTypeList, err := db.Migrator().ColumnTypes(s.Table)
+		if err != nil {
+			return fmt.Errorf("failed to get columns of %s, err: %w", s.Table, err)
+		}
+		columnSet := make(map[string]bool, len(columnTypeList))
+		for _, v := range columnTypeList {
+			columnSet[v.Name()] = true
+		}
+		for _, name := range s.DBNames {
+			if !columnSet[name] {
+				errList = append(errList, fmt.Errorf("column %s.%s is missing", s.Table, name))
+			}
+		}
+	}
+	if err := errors.Join(errList...); err != nil {
+		return err
+	}
+	schemaChecked.Store(true)
+	return nil
+}
+
+type Info struct {
+	Version       string            `json:"version"`
+	Revision      string            `json:"revision"`
+	GoVersion     string            `json:"go_version"`
+	StartedAt     time.Time         `json:"started_at"`
+	Config        map[string]string `json:"config"`
+	PolicyCount   int               `json:"policy_count"`
+	GroupingCount int               `json:"grouping_count"`
+	LastReload    time.Time         `json:"last_reload"`
+}
+
+// GetInfo describes the running instance: its build, its configuration without the secrets and
+// the policy loaded into the shared enforcer.
+func GetInfo(ctx context.Context) (Info, error) {
+	ctx, span := tracing.Start(ctx, "health.GetInfo")
+	defer span.End()
+	info := Info{
+		Version:   bootstrap.Version,
+		Revision:  revision(),
+		GoVersion: runtime.Version(),
+		StartedAt: startedAt,
+		Config:    map[string]string{},
+	}
+	for k, v := range database.Summary() {
+		info.Config["mysql."+k] = v
+	}
+	info.Config[logger.EnvLevel] = string(logger.GetLevel())
+	for _, k := range []string{logger.EnvDecisionSample, lifecycle.EnvShutdownTimeout, limit.EnvBodyLimit, limit.EnvTrustedProxies, tracing.EnvExporter, "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+		info.Config[k] = os.Getenv(k)
+	}
+	for _, k := range []string{admin.EnvToken, admin.EnvGatewayToken, limit.EnvAPIKeys} {
+		if os.Getenv(k) != "" {
+			info.Config[k] = "[redacted]"
+		}
+	}
+
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to get enforcer, err: %w", err)
+	}
+	policyList, err := enforcer.GetPolicy()
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to get policy, err: %w", err)
+	}
+	groupingList, err := enforcer.GetGroupingPolicy()
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to get grouping policy, err: %w", err)
+	}
+	info.PolicyCount, info.GroupingCount = len(policyList), len(groupingList)
+	info.LastReload = casbin.LastLoad()
+	return info, nil
+}
+
+// revision returns the VCS revision the binary was built from, if Go recorded it.
+func revision() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, v := range buildInfo.Settings {
+		if v.Key == "vcs.revision" {
+			return v.Value
+		}
+	}
+	return ""
+}
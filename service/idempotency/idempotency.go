@@ -0,0 +1,210 @@
+// Package idempotency makes retried mutating requests safe. A client sends the same
+// Idempotency-Key header with every attempt of a request, the first attempt is served and its
+// response stored, the later ones get the stored response back without running again. A key
+// belongs to the caller who sent it, the same key from another caller is another request.
+package idempotency
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/controller"
+	"ac/custom/background"
+	"ac/custom/meta"
+	"ac/custom/output"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	// lockTimeout is how long a request may hold its key before it is taken to have died with
+	// the instance serving it, so that the key does not stay blocked until it expires
+	lockTimeout = time.Minute
+)
+
+var (
+	ErrKeyReused  = errors.New("idempotency key reused with a different request")
+	ErrInProgress = errors.New("request with the same idempotency key in progress")
+)
+
+// Middleware honours the Idempotency-Key header of the POST requests of the routes it is used
+// on. The response of a request is stored for ttl, unless it failed with a server error, which
+// the retry should get a chance to fix. It must run after admin.Authenticate, which identifies
+// the caller.
+func Middleware(ttl time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(HeaderKey)
+			if key == "" || c.Request().Method != http.MethodPost {
+				return next(c)
+			}
+			if len(key) > maxKeyLength {
+				return output.Failure(c, controller.ErrInvalidInput.WithHint(fmt.Sprintf("The idempotency key must not exceed %d characters", maxKeyLength)))
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return output.Failure(c, controller.ErrInvalidInput.WithMsg(err.Error()))
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+
+			ctx := c.Request().Context()
+			record, err := acquire(ctx, key, c.Path(), caller(ctx), hex.EncodeToString(sum[:]), ttl)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrKeyReused):
+					return output.Failure(c, controller.ErrInvalidInput.WithHint("The idempotency key has been used with a different request"))
+				case errors.Is(err, ErrInProgress):
+					return output.Failure(c, controller.ErrConflict.WithHint("A request with the same idempotency key is in progress. Please try again later"))
+				}
+				logger.Errorf(ctx, "failed to acquire idempotency key, err: %v, key: %s", err, key)
+				return output.Failure(c, err)
+			}
+			if record.Status != 0 {
+				c.Response().Header().Set(HeaderReplayed, "true")
+				return c.JSONBlob(record.Status, []byte(record.Response))
+			}
+
+			writer := &recordingWriter{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = writer
+			err = next(c)
+			status := c.Response().Status
+			if err != nil || status >= http.StatusInternalServerError {
+				if releaseErr := release(ctx, record); releaseErr != nil {
+					logger.Errorf(ctx, "failed to release idempotency key, err: %v, key: %s", releaseErr, key)
+				}
+				return err
+			}
+			if err := complete(ctx, record, status, writer.body.String()); err != nil {
+				// The response is out already, a retry will run the request again
+				logger.Errorf(ctx, "failed to store idempotent response, err: %v, key: %s", err, key)
+			}
+			return nil
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// caller identifies who sent the request by the kind of token and the admin user it names.
+func caller(ctx context.Context) string {
+	m := meta.FromContext(ctx)
+	return m.Token + ":" + m.AdminUser
+}
+
+// acquire returns the record of the key of the caller. A record with a status holds the
+// response to replay, otherwise it is new and the request is to be served.
+func acquire(ctx context.Context, key, path, caller, requestHash string, ttl time.Duration) (*model.IdempotencyKey, error) {
+	condition := &model.IdempotencyKey{Key: key, Path: path, Caller: caller}
+	now := util.UTCNow()
+	record, err := dal.NewRepo[model.IdempotencyKey]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(condition)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query idempotency key, err: %w", err)
+	}
+	if record != nil {
+		abandoned := record.Status == 0 && record.CreatedAt.Add(lockTimeout).Before(now)
+		if record.ExpiresAt.After(now) && !abandoned {
+			if record.RequestHash != requestHash {
+				return nil, ErrKeyReused
+			}
+			if record.Status == 0 {
+				return nil, ErrInProgress
+			}
+			return record, nil
+		}
+		if err := release(ctx, record); err != nil {
+			return nil, err
+		}
+	}
+
+	record = &model.IdempotencyKey{
+		Key:         key,
+		Path:        path,
+		Caller:      caller,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(ttl),
+		CreatedAt:   now,
+	}
+	if err := dal.NewRepo[model.IdempotencyKey]().Insert(ctx, database.DB, record); err != nil {
+		// Most likely a concurrent attempt inserted the key first
+		existing, queryErr := dal.NewRepo[model.IdempotencyKey]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Where(condition)
+		})
+		if queryErr == nil && existing != nil {
+			return nil, ErrInProgress
+		}
+		return nil, fmt.Errorf("failed to add idempotency key, err: %w", err)
+	}
+	return record, nil
+}
+
+func complete(ctx context.Context, record *model.IdempotencyKey, status int, response string) error {
+	err := dal.NewRepo[model.IdempotencyKey]().UpdateWithMap(ctx, database.DB, map[string]interface{}{
+		"status":   status,
+		"response": response,
+	}, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", record.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update idempotency key, err: %w", err)
+	}
+	return nil
+}
+
+func release(ctx context.Context, record *model.IdempotencyKey) error {
+	err := dal.NewRepo[model.IdempotencyKey]().Delete(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", record.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency key, err: %w", err)
+	}
+	return nil
+}
+
+// RunPurge deletes the expired keys every interval until ctx is done.
+func RunPurge(ctx context.Context, interval time.Duration) {
+	c := background.NewContext(ctx, http.MethodPost, "idempotency purge")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := dal.NewRepo[model.IdempotencyKey]().Delete(c, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Where("expires_at < ?", util.UTCNow())
+		})
+		if err != nil {
+			logger.Errorf(ctx, "failed to purge idempotency keys, err: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
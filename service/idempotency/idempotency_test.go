@@ -0,0 +1,167 @@
+package idempotency
+
+import (
+	"ac/bootstrap/database"
+	"ac/controller"
+	"ac/custom/meta"
+	"ac/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// request is a request sent with the same idempotency key as the others of its case.
+type request struct {
+	adminUser string
+	body      string
+	// status is what the handler responds with when it runs
+	status int
+	// during is sent while the handler serves this request
+	during *request
+
+	wantStatus   int
+	wantReplayed bool
+}
+
+func TestMiddleware(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requests  []request
+		wantCalls int
+	}{
+		{
+			name: "replay",
+			requests: []request{
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK},
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK, wantReplayed: true},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "reuse with a different body",
+			requests: []request{
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK},
+				{adminUser: "user_1", body: `{"a":2}`, status: http.StatusOK, wantStatus: controller.ErrInvalidInput.Status()},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "same key from another caller",
+			requests: []request{
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK},
+				{adminUser: "user_2", body: `{"a":1}`, status: http.StatusCreated, wantStatus: http.StatusCreated},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "in progress",
+			requests: []request{
+				{
+					adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK,
+					during: &request{adminUser: "user_1", body: `{"a":1}`, wantStatus: controller.ErrConflict.Status()},
+				},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "release on a server error",
+			requests: []request{
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusInternalServerError, wantStatus: http.StatusInternalServerError},
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK},
+				{adminUser: "user_1", body: `{"a":1}`, status: http.StatusOK, wantStatus: http.StatusOK, wantReplayed: true},
+			},
+			wantCalls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			openDB(t)
+			e := echo.New()
+			calls := 0
+			var send func(r request) *httptest.ResponseRecorder
+			handler := func(c echo.Context) error {
+				calls++
+				r := c.Get("request").(request)
+				if r.during != nil {
+					checkResponse(t, *r.during, send(*r.during))
+				}
+				return c.JSON(r.status, map[string]interface{}{"calls": calls})
+			}
+			// Stands in for admin.Authenticate
+			authenticate := func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					req := c.Request()
+					m := meta.Meta{Authenticated: true, Token: meta.TokenGateway, AdminUser: req.Header.Get("X-Test-User")}
+					c.SetRequest(req.WithContext(meta.NewContext(req.Context(), m)))
+					return next(c)
+				}
+			}
+			requests := map[string]request{}
+			e.POST("/user/add", handler, func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.Set("request", requests[c.Request().Header.Get("X-Test-Request")])
+					return next(c)
+				}
+			}, authenticate, Middleware(time.Hour))
+			send = func(r request) *httptest.ResponseRecorder {
+				id := r.adminUser + r.body + http.StatusText(r.status) + http.StatusText(r.wantStatus)
+				requests[id] = r
+				req := httptest.NewRequest(http.MethodPost, "/user/add", strings.NewReader(r.body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				req.Header.Set(HeaderKey, "key_1")
+				req.Header.Set("X-Test-User", r.adminUser)
+				req.Header.Set("X-Test-Request", id)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				return rec
+			}
+
+			for _, r := range tc.requests {
+				checkResponse(t, r, send(r))
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func checkResponse(t *testing.T, r request, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != r.wantStatus {
+		t.Errorf("%s %s: status = %d, want %d, body: %s", r.adminUser, r.body, rec.Code, r.wantStatus, rec.Body.String())
+	}
+	if replayed := rec.Header().Get(HeaderReplayed) == "true"; replayed != r.wantReplayed {
+		t.Errorf("%s %s: replayed = %v, want %v", r.adminUser, r.body, replayed, r.wantReplayed)
+	}
+}
+
+// openDB points the database at a fresh in-memory SQLite database for the test.
+func openDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database, err: %v", err)
+	}
+	// Every connection would open a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get connection pool, err: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&model.IdempotencyKey{}); err != nil {
+		t.Fatalf("failed to create table, err: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+	})
+}
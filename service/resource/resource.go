@@ -4,16 +4,17 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/custom/define"
+	"ac/custom/tracing"
 	"ac/custom/util"
 	"ac/dal"
 	"ac/model"
+	"context"
 	"errors"
 	"fmt"
 	"slices"
 	"strings"
 	"time"
 
-	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
@@ -23,11 +24,14 @@ type Resource struct {
 	Description string    `json:"description"`
 	SystemCode  string    `json:"system_code"`
 	Code        string    `json:"code"`
+	ParentCode  string    `json:"parent_code"`
 	ModifiedBy  string    `json:"modified_by"`
 	UpdatedAt   time.Time `json:"update_at"`
 }
 
-func Validate(ctx echo.Context, systemCode, code string) (bool, error) {
+func Validate(ctx context.Context, systemCode, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "resource.Validate")
+	defer span.End()
 	if systemCode == "" || code == "" {
 		return false, errors.New("systemCode or code is empty")
 	}
@@ -45,7 +49,9 @@ func Validate(ctx echo.Context, systemCode, code string) (bool, error) {
 	return true, nil
 }
 
-func ValidateBatch(ctx echo.Context, systemCode string, codeList []string) (map[string]bool, error) {
+func ValidateBatch(ctx context.Context, systemCode string, codeList []string) (map[string]bool, error) {
+	ctx, span := tracing.Start(ctx, "resource.ValidateBatch")
+	defer span.End()
 	if systemCode == "" || len(codeList) == 0 {
 		return nil, errors.New("systemCode or code is empty")
 	}
@@ -84,7 +90,9 @@ func ValidateBatch(ctx echo.Context, systemCode string, codeList []string) (map[
 	return result, nil
 }
 
-func IsCodeAvailable(ctx echo.Context, code string) (bool, error) {
+func IsCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "resource.IsCodeAvailable")
+	defer span.End()
 	if code == "" {
 		return false, errors.New("code is empty")
 	}
@@ -100,7 +108,9 @@ func IsCodeAvailable(ctx echo.Context, code string) (bool, error) {
 	return record == nil, nil
 }
 
-func QueryResourceByCode(ctx echo.Context, systemCode string, codeList []string) (map[string]Resource, error) {
+func QueryResourceByCode(ctx context.Context, systemCode string, codeList []string) (map[string]Resource, error) {
+	ctx, span := tracing.Start(ctx, "resource.QueryResourceByCode")
+	defer span.End()
 	codeList = util.Deduplicate(slices.DeleteFunc(codeList, func(s string) bool {
 		return strings.TrimSpace(s) == ""
 	}))
@@ -118,9 +128,70 @@ func QueryResourceByCode(ctx echo.Context, systemCode string, codeList []string)
 			Description: v.Description,
 			SystemCode:  v.SystemCode,
 			Code:        v.Code,
+			ParentCode:  v.ParentCode,
 			ModifiedBy:  v.ModifiedBy,
 			UpdatedAt:   v.UpdatedAt,
 		}
 	}
 	return resourceCodeMap, nil
 }
+
+// QueryByName returns the resources with the given name, optionally restricted to the children of parentCode.
+func QueryByName(ctx context.Context, systemCode, name, parentCode string) ([]Resource, error) {
+	ctx, span := tracing.Start(ctx, "resource.QueryByName")
+	defer span.End()
+	if systemCode == "" || name == "" {
+		return nil, errors.New("systemCode or name is empty")
+	}
+	recordList, err := dal.NewRepo[model.Resource]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		db = db.Where(model.Resource{SystemCode: systemCode, Name: name})
+		if parentCode != "" {
+			db = db.Where(model.Resource{ParentCode: parentCode})
+		}
+		return db.Order("id asc")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query, err: %w, system code: %s, name: %s", err, systemCode, name)
+	}
+	list := make([]Resource, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, Resource{
+			ID:          v.ID,
+			Name:        v.Name,
+			Description: v.Description,
+			SystemCode:  v.SystemCode,
+			Code:        v.Code,
+			ParentCode:  v.ParentCode,
+			ModifiedBy:  v.ModifiedBy,
+			UpdatedAt:   v.UpdatedAt,
+		})
+	}
+	return list, nil
+}
+
+// Index returns the index of the resource relative to the system, the codes from the top level
+// down to the resource joined by "/".
+func Index(ctx context.Context, systemCode, code string) (string, error) {
+	ctx, span := tracing.Start(ctx, "resource.Index")
+	defer span.End()
+	codeList := make([]string, 0)
+	for current := code; current != ""; {
+		// A loop in the tree would never reach the top level
+		if slices.Contains(codeList, current) {
+			return "", fmt.Errorf("loop in the resource tree, code: %s", current)
+		}
+		record, err := dal.NewRepo[model.Resource]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Resource{SystemCode: systemCode, Code: current})
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to query, err: %w, system code: %s, code: %s", err, systemCode, current)
+		}
+		if record == nil {
+			return "", fmt.Errorf("resource not found, system code: %s, code: %s", systemCode, current)
+		}
+		codeList = append(codeList, record.Code)
+		current = record.ParentCode
+	}
+	slices.Reverse(codeList)
+	return strings.Join(codeList, "/"), nil
+}
@@ -0,0 +1,73 @@
+package rule
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const expiryBatchSize = 100
+
+// RunExpiry deletes the role memberships and break-glass policies whose window has closed every
+// interval until ctx is done. They no longer grant anything, the enforcer leaves them out as
+// soon as they expire, but they would block a new grant to the same user.
+func RunExpiry(ctx context.Context, interval time.Duration) {
+	c := background.NewContext(ctx, http.MethodPost, "expiry")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := deleteExpired(c); err != nil {
+			logger.Errorf(c, "failed to delete expired rules, err: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func deleteExpired(ctx context.Context) error {
+	now := util.UTCNow().Format(time.RFC3339)
+	for {
+		recordList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+			return db.Where("ptype = ? OR (ptype = ? AND v5 = ?)", model.PTypeGroup, model.PTypePolicy, model.MarkerBreakGlass).
+				Where("v4 != ''").Where("v4 < ?", now).Order("id asc").Limit(expiryBatchSize)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query expired rule, err: %w", err)
+		}
+		if len(recordList) == 0 {
+			return nil
+		}
+		ruleList := make([]Rule, 0, len(recordList))
+		for _, v := range recordList {
+			// The window goes along so that the deleted rule keeps it, the strings round-trip as
+			// they were written in UTC
+			rule := Rule{PType: v.PType, V0: v.V0, V1: v.V1, V2: v.V2, V5: v.V5}
+			rule.V3, _ = time.Parse(time.RFC3339, v.V3)
+			rule.V4, _ = time.Parse(time.RFC3339, v.V4)
+			ruleList = append(ruleList, rule)
+		}
+		if err := Delete(ctx, ruleList); err != nil {
+			// Another instance got there first, the next round picks up what is left
+			if errors.Is(err, ErrRuleNotFound) {
+				return nil
+			}
+			return err
+		}
+		if len(recordList) < expiryBatchSize {
+			return nil
+		}
+	}
+}
@@ -3,16 +3,21 @@ package rule
 import (
 	"ac/bootstrap/database"
 	"ac/custom/define"
+	"ac/custom/tracing"
 	"ac/custom/util"
 	"ac/dal"
 	"ac/model"
+	"ac/service/changefeed"
+	"ac/service/sod"
+	"ac/service/webhook"
+	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/bytedance/sonic"
-	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
@@ -26,6 +31,7 @@ type Rule struct {
 	V2    string    `json:"v2"`
 	V3    time.Time `json:"v3"`
 	V4    time.Time `json:"v4"`
+	V5    string    `json:"v5"`
 }
 
 func (r *Rule) validate() error {
@@ -36,6 +42,14 @@ func (r *Rule) validate() error {
 		return errors.New("v1 is empty")
 	}
 
+	// Grouping rules carry the user and the role, with an optional window in v3 and v4
+	if r.PType == model.PTypeGroup {
+		if !r.V3.IsZero() && !r.V4.IsZero() && r.V4.Before(r.V3) {
+			return errors.New("v4 must be after v3")
+		}
+		return nil
+	}
+
 	if _, ok := define.ValidAction2Level[r.V2]; !ok {
 		return errors.New("invalid v2")
 	}
@@ -55,157 +69,326 @@ func (r *Rule) validate() error {
 	return nil
 }
 
-func Add(ctx echo.Context, ruleList []Rule) error {
+func (r *Rule) toModel() *model.CasbinRule {
+	if r.PType == model.PTypeGroup {
+		record := &model.CasbinRule{
+			PType: r.PType,
+			V0:    r.V0,
+			V1:    r.V1,
+		}
+		// Kept in UTC so that the expired memberships can be found by comparing strings
+		if !r.V3.IsZero() {
+			record.V3 = r.V3.UTC().Format(time.RFC3339)
+		}
+		if !r.V4.IsZero() {
+			record.V4 = r.V4.UTC().Format(time.RFC3339)
+		}
+		return record
+	}
+	return &model.CasbinRule{
+		PType: r.PType,
+		V0:    r.V0,
+		V1:    r.V1,
+		V2:    r.V2,
+		V3:    r.V3.Format(time.RFC3339),
+		V4:    r.V4.Format(time.RFC3339),
+		V5:    r.V5,
+	}
+}
+
+func Add(ctx context.Context, ruleList []Rule) error {
+	ctx, span := tracing.Start(ctx, "rule.Add")
+	defer span.End()
+	return add(ctx, ruleList, 0, nil)
+}
+
+// AddIfMatch adds the rules like Add if the rules of their subject are still at version, see
+// Version. It fails with ErrVersionMismatch otherwise.
+func AddIfMatch(ctx context.Context, ruleList []Rule, version int64) error {
+	ctx, span := tracing.Start(ctx, "rule.AddIfMatch")
+	defer span.End()
+	return add(ctx, ruleList, version, nil)
+}
+
+// AddWith adds the rules like Add and calls fn, if not nil, in the same transaction with the
+// ID of the log of the change, so that records tied to the rules are written along with them.
+func AddWith(ctx context.Context, ruleList []Rule, fn func(tx *gorm.DB, logID int64) error) error {
+	ctx, span := tracing.Start(ctx, "rule.AddWith")
+	defer span.End()
+	return add(ctx, ruleList, 0, fn)
+}
+
+func add(ctx context.Context, ruleList []Rule, version int64, fn func(tx *gorm.DB, logID int64) error) error {
+	ruleListToAdd, log, err := prepareAdd(ruleList)
+	if err != nil {
+		return err
+	}
+	err = dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := addTx(ctx, tx, ruleListToAdd, log, version); err != nil {
+			return err
+		}
+		if fn != nil {
+			return fn(tx, log.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit rule, err: %w", err)
+	}
+	changefeed.Notify(log.ID)
+	return nil
+}
+
+func prepareAdd(ruleList []Rule) ([]*model.CasbinRule, *model.CasbinRuleLog, error) {
 	now := util.UTCNow()
 	ruleListToAdd := make([]*model.CasbinRule, 0, len(ruleList))
 	for _, v := range ruleList {
 		if err := v.validate(); err != nil {
-			return fmt.Errorf("rule is invalid , err: %w", err)
-		}
-		if v.V3.Before(now) && v.V4.Before(now) {
-			return errors.New("rule has expired")
-		}
-		ruleListToAdd = append(ruleListToAdd, &model.CasbinRule{
-			PType: v.PType,
-			V0:    v.V0,
-			V1:    v.V1,
-			V2:    v.V2,
-			V3:    v.V3.Format(time.RFC3339),
-			V4:    v.V4.Format(time.RFC3339),
-		})
+			return nil, nil, fmt.Errorf("rule is invalid , err: %w", err)
+		}
+		if v.PType != model.PTypeGroup && v.V3.Before(now) && v.V4.Before(now) {
+			return nil, nil, errors.New("rule has expired")
+		}
+		if v.PType == model.PTypeGroup && !v.V4.IsZero() && v.V4.Before(now) {
+			return nil, nil, errors.New("rule has expired")
+		}
+		ruleListToAdd = append(ruleListToAdd, v.toModel())
 	}
 	logContent, err := sonic.MarshalString(ruleListToAdd)
 	if err != nil {
-		return fmt.Errorf("failed to marshal rule list, err: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal rule list, err: %w", err)
 	}
+	return ruleListToAdd, &model.CasbinRuleLog{
+		Operate:   model.OperateAdd,
+		Content:   logContent,
+		CreatedAt: now,
+	}, nil
+}
 
-	err = database.DB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
-		log := &model.CasbinRuleLog{
-			Operate:   model.OperateAdd,
-			Content:   logContent,
-			CreatedAt: now,
+func addTx(ctx context.Context, tx *gorm.DB, ruleListToAdd []*model.CasbinRule, log *model.CasbinRuleLog, version int64) error {
+	if err := bumpVersion(ctx, tx, ruleListToAdd, version); err != nil {
+		return err
+	}
+	// The change is rejected before the log takes an ID, a rolled back ID is a gap the
+	// change feed holds its cursor at
+	if err := sod.Check(ctx, tx, ruleListToAdd); err != nil {
+		return err
+	}
+	// A rule marked in v5 sits next to an unmarked one on the same subject and object, e.g. a
+	// break-glass grant elevating the access the user already has
+	seen := make(map[[4]string]struct{}, len(ruleListToAdd))
+	for _, v := range ruleListToAdd {
+		key := [4]string{v.PType, v.V0, v.V1, v.V5}
+		if _, ok := seen[key]; ok {
+			return ErrDuplicateRule
 		}
-		err = dal.NewRepo[model.CasbinRuleLog]().Insert(ctx, tx, log)
+		seen[key] = struct{}{}
+		rerourd, err := dal.NewRepo[model.CasbinRule]().Query(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(&model.CasbinRule{
+				PType: v.PType,
+				V0:    v.V0,
+				V1:    v.V1,
+			}).Where("v5 = ?", v.V5)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to add log, err: %w", err)
+			return fmt.Errorf("failed to query rule, err: %w", err)
+		}
+		if rerourd != nil {
+			return ErrDuplicateRule
 		}
+	}
 
-		for _, v := range ruleListToAdd {
-			rerourd, err := dal.NewRepo[model.CasbinRule]().Query(ctx, tx, func(db *gorm.DB) *gorm.DB {
-				return db.Where(&model.CasbinRule{
-					PType: v.PType,
-					V0:    v.V0,
-					V1:    v.V1,
-				})
-			})
-			if err != nil {
-				return fmt.Errorf("failed to query rule, err: %w", err)
-			}
-			if rerourd != nil {
-				return ErrDuplicateRule
+	err := dal.NewRepo[model.CasbinRuleLog]().Insert(ctx, tx, log)
+	if err != nil {
+		return fmt.Errorf("failed to add log, err: %w", err)
+	}
+	if err := webhook.EnqueueRules(ctx, tx, log.ID, model.OperateAdd, ruleListToAdd); err != nil {
+		return fmt.Errorf("failed to add webhook event, err: %w", err)
+	}
+	for _, v := range ruleListToAdd {
+		err = dal.NewRepo[model.CasbinRule]().Insert(ctx, tx, v)
+		if err != nil {
+			return fmt.Errorf("failed to add rule, err: %w", err)
+		}
+	}
+	return nil
+}
+
+func Delete(ctx context.Context, ruleList []Rule) error {
+	ctx, span := tracing.Start(ctx, "rule.Delete")
+	defer span.End()
+	return remove(ctx, ruleList, 0, nil)
+}
+
+// DeleteIfMatch deletes the rules like Delete if the rules of their subject are still at
+// version, see Version. It fails with ErrVersionMismatch otherwise.
+func DeleteIfMatch(ctx context.Context, ruleList []Rule, version int64) error {
+	ctx, span := tracing.Start(ctx, "rule.DeleteIfMatch")
+	defer span.End()
+	return remove(ctx, ruleList, version, nil)
+}
+
+// DeleteWith deletes the rules like Delete and calls fn, if not nil, in the same transaction
+// with the ID of the log of the change, like AddWith.
+func DeleteWith(ctx context.Context, ruleList []Rule, fn func(tx *gorm.DB, logID int64) error) error {
+	ctx, span := tracing.Start(ctx, "rule.DeleteWith")
+	defer span.End()
+	return remove(ctx, ruleList, 0, fn)
+}
+
+// ReplaceWith deletes the rules of deleteList and adds those of addList in one transaction,
+// each change with its own log, and calls fn, if not nil, in the same transaction. Either list
+// may be empty.
+func ReplaceWith(ctx context.Context, deleteList, addList []Rule, fn func(tx *gorm.DB) error) error {
+	ctx, span := tracing.Start(ctx, "rule.ReplaceWith")
+	defer span.End()
+	var ruleListToDelete, ruleListToAdd []*model.CasbinRule
+	var deleteLog, addLog *model.CasbinRuleLog
+	var err error
+	if len(deleteList) > 0 {
+		if ruleListToDelete, deleteLog, err = prepareRemove(deleteList); err != nil {
+			return err
+		}
+	}
+	if len(addList) > 0 {
+		if ruleListToAdd, addLog, err = prepareAdd(addList); err != nil {
+			return err
+		}
+	}
+	err = dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if deleteLog != nil {
+			if err := removeTx(ctx, tx, ruleListToDelete, deleteLog, 0); err != nil {
+				return err
 			}
-			err = dal.NewRepo[model.CasbinRule]().Insert(ctx, tx, v)
-			if err != nil {
-				return fmt.Errorf("failed to add rule, err: %w", err)
+		}
+		if addLog != nil {
+			if err := addTx(ctx, tx, ruleListToAdd, addLog, 0); err != nil {
+				return err
 			}
 		}
+		if fn != nil {
+			return fn(tx)
+		}
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("failed to commit rule, err: %w", err)
 	}
+	for _, v := range []*model.CasbinRuleLog{deleteLog, addLog} {
+		if v != nil {
+			changefeed.Notify(v.ID)
+		}
+	}
 	return nil
 }
 
-func Delete(ctx echo.Context, ruleList []Rule) error {
+func remove(ctx context.Context, ruleList []Rule, version int64, fn func(tx *gorm.DB, logID int64) error) error {
+	ruleListToDelete, log, err := prepareRemove(ruleList)
+	if err != nil {
+		return err
+	}
+	err = dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := removeTx(ctx, tx, ruleListToDelete, log, version); err != nil {
+			return err
+		}
+		if fn != nil {
+			return fn(tx, log.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit rule, err: %w", err)
+	}
+	changefeed.Notify(log.ID)
+	return nil
+}
+
+func prepareRemove(ruleList []Rule) ([]*model.CasbinRule, *model.CasbinRuleLog, error) {
 	ruleListToDelete := make([]*model.CasbinRule, 0, len(ruleList))
 	for _, v := range ruleList {
 		if err := v.validate(); err != nil {
-			return fmt.Errorf("rule is invalid , err: %w", err)
-		}
-		ruleListToDelete = append(ruleListToDelete, &model.CasbinRule{
-			PType: v.PType,
-			V0:    v.V0,
-			V1:    v.V1,
-			V2:    v.V2,
-			V3:    v.V3.Format(time.RFC3339),
-			V4:    v.V4.Format(time.RFC3339),
-		})
+			return nil, nil, fmt.Errorf("rule is invalid , err: %w", err)
+		}
+		ruleListToDelete = append(ruleListToDelete, v.toModel())
 	}
 	logContent, err := sonic.MarshalString(ruleListToDelete)
 	if err != nil {
-		return fmt.Errorf("failed to marshal rule list, err: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal rule list, err: %w", err)
 	}
-	now := util.UTCNow()
-	err = database.DB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
-		log := &model.CasbinRuleLog{
-			Operate:   model.OperateDelete,
-			Content:   logContent,
-			CreatedAt: now,
-		}
-		err = dal.NewRepo[model.CasbinRuleLog]().Insert(ctx, tx, log)
+	return ruleListToDelete, &model.CasbinRuleLog{
+		Operate:   model.OperateDelete,
+		Content:   logContent,
+		CreatedAt: util.UTCNow(),
+	}, nil
+}
+
+func removeTx(ctx context.Context, tx *gorm.DB, ruleListToDelete []*model.CasbinRule, log *model.CasbinRuleLog, version int64) error {
+	if err := bumpVersion(ctx, tx, ruleListToDelete, version); err != nil {
+		return err
+	}
+	// Like in add, the change is rejected before the log takes an ID
+	recordList := make([]*model.CasbinRule, 0, len(ruleListToDelete))
+	for _, v := range ruleListToDelete {
+		record, err := dal.NewRepo[model.CasbinRule]().Query(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(v).Where("v5 = ?", v.V5)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to add log, err: %w", err)
+			return fmt.Errorf("failed to query rule, err: %w", err)
 		}
-
-		for _, v := range ruleListToDelete {
-			record, err := dal.NewRepo[model.CasbinRule]().Query(ctx, tx, func(db *gorm.DB) *gorm.DB {
-				return db.Where(v)
-			})
-			if err != nil {
-				return fmt.Errorf("failed to query rule, err: %w", err)
-			}
-			if record == nil {
-				return ErrRuleNotFound
-			}
-			err = dal.NewRepo[model.CasbinRule]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
-				return db.Where(record).Limit(1)
-			})
-			if err != nil {
-				return fmt.Errorf("failed to delete rule, err: %w", err)
-			}
+		// A rule listed twice is gone by the second time
+		if record == nil || slices.ContainsFunc(recordList, func(r *model.CasbinRule) bool { return r.ID == record.ID }) {
+			return ErrRuleNotFound
 		}
+		recordList = append(recordList, record)
+	}
 
-		deletedRuleList := make([]*model.CasbinRuleDeleted, 0, len(ruleListToDelete))
-		for _, v := range ruleListToDelete {
-			deletedRuleList = append(deletedRuleList, &model.CasbinRuleDeleted{
-				LogID:     log.ID,
-				PType:     v.PType,
-				V0:        v.V0,
-				V1:        v.V1,
-				V2:        v.V2,
-				V3:        v.V3,
-				V4:        v.V4,
-				CreatedAt: now,
-			})
-		}
-		err = dal.NewRepo[model.CasbinRuleDeleted]().BatchInsert(ctx, tx, deletedRuleList, 20)
+	err := dal.NewRepo[model.CasbinRuleLog]().Insert(ctx, tx, log)
+	if err != nil {
+		return fmt.Errorf("failed to add log, err: %w", err)
+	}
+	if err := webhook.EnqueueRules(ctx, tx, log.ID, model.OperateDelete, ruleListToDelete); err != nil {
+		return fmt.Errorf("failed to add webhook event, err: %w", err)
+	}
+	for _, record := range recordList {
+		err = dal.NewRepo[model.CasbinRule]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(record).Limit(1)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to add deleted rule, err: %w", err)
+			return fmt.Errorf("failed to delete rule, err: %w", err)
 		}
-		return nil
-	})
+	}
+
+	deletedRuleList := make([]*model.CasbinRuleDeleted, 0, len(ruleListToDelete))
+	for _, v := range ruleListToDelete {
+		deletedRuleList = append(deletedRuleList, &model.CasbinRuleDeleted{
+			LogID:     log.ID,
+			PType:     v.PType,
+			V0:        v.V0,
+			V1:        v.V1,
+			V2:        v.V2,
+			V3:        v.V3,
+			V4:        v.V4,
+			V5:        v.V5,
+			CreatedAt: log.CreatedAt,
+		})
+	}
+	err = dal.NewRepo[model.CasbinRuleDeleted]().BatchInsert(ctx, tx, deletedRuleList, 20)
 	if err != nil {
-		return fmt.Errorf("failed to commit rule, err: %w", err)
+		return fmt.Errorf("failed to add deleted rule, err: %w", err)
 	}
 	return nil
 }
 
-func Set(ctx echo.Context, ruleList []Rule) error {
+func Set(ctx context.Context, ruleList []Rule) error {
+	ctx, span := tracing.Start(ctx, "rule.Set")
+	defer span.End()
 	ruleListToSet := make([]*model.CasbinRule, 0, len(ruleList))
 	now := util.UTCNow()
 	for _, v := range ruleList {
 		if err := v.validate(); err != nil {
 			return fmt.Errorf("invalid rule: %w", err)
 		}
-		ruleListToSet = append(ruleListToSet, &model.CasbinRule{
-			PType: v.PType,
-			V0:    v.V0,
-			V1:    v.V1,
-			V2:    v.V2,
-			V3:    v.V3.Format(time.RFC3339),
-			V4:    v.V4.Format(time.RFC3339),
-		})
+		ruleListToSet = append(ruleListToSet, v.toModel())
 	}
 
 	logContent, err := sonic.MarshalString(ruleListToSet)
@@ -213,16 +396,26 @@ func Set(ctx echo.Context, ruleList []Rule) error {
 		return fmt.Errorf("failed to marshal rules: %w", err)
 	}
 
-	err = database.DB.WithContext(ctx.Request().Context()).Transaction(func(tx *gorm.DB) error {
-		log := &model.CasbinRuleLog{
-			Operate:   model.OperateSet,
-			Content:   logContent,
-			CreatedAt: now,
+	log := &model.CasbinRuleLog{
+		Operate:   model.OperateSet,
+		Content:   logContent,
+		CreatedAt: now,
+	}
+	err = dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		if err := bumpVersion(ctx, tx, ruleListToSet, 0); err != nil {
+			return err
+		}
+		// Like in add, the change is rejected before the log takes an ID
+		if err := sod.Check(ctx, tx, ruleListToSet); err != nil {
+			return err
 		}
 		err = dal.NewRepo[model.CasbinRuleLog]().Insert(ctx, tx, log)
 		if err != nil {
 			return fmt.Errorf("failed to log operation: %w", err)
 		}
+		if err := webhook.EnqueueRules(ctx, tx, log.ID, model.OperateSet, ruleListToSet); err != nil {
+			return fmt.Errorf("failed to add webhook event, err: %w", err)
+		}
 
 		deletedRuleList := make([]*model.CasbinRuleDeleted, 0, len(ruleListToSet))
 
@@ -233,7 +426,7 @@ func Set(ctx echo.Context, ruleList []Rule) error {
 				V1:    v.V1,
 			}
 			record, err := dal.NewRepo[model.CasbinRule]().Query(ctx, tx, func(db *gorm.DB) *gorm.DB {
-				return db.Where(condition)
+				return db.Where(condition).Where("v5 = ?", v.V5)
 			})
 			if err != nil {
 				return fmt.Errorf("failed to query rule, err: %w", err)
@@ -244,12 +437,15 @@ func Set(ctx echo.Context, ruleList []Rule) error {
 					return fmt.Errorf("failed to add rule, err: %w", err)
 				}
 			} else {
-				err = dal.NewRepo[model.CasbinRule]().Update(ctx, tx, &model.CasbinRule{
-					V2: v.V2,
-					V3: v.V3,
-					V4: v.V4,
+				// A map, since a struct skips the empty fields, e.g. the window of a membership
+				// made permanent
+				err = dal.NewRepo[model.CasbinRule]().UpdateWithMap(ctx, tx, map[string]interface{}{
+					"v2": v.V2,
+					"v3": v.V3,
+					"v4": v.V4,
+					"v5": v.V5,
 				}, func(db *gorm.DB) *gorm.DB {
-					return db.Where(condition).Limit(1)
+					return db.Where(condition).Where("v5 = ?", v.V5).Limit(1)
 				})
 				if err != nil {
 					return fmt.Errorf("failed to update rule, err: %w", err)
@@ -262,6 +458,7 @@ func Set(ctx echo.Context, ruleList []Rule) error {
 					V2:        record.V2,
 					V3:        record.V3,
 					V4:        record.V4,
+					V5:        record.V5,
 					CreatedAt: now,
 				})
 			}
@@ -280,6 +477,7 @@ func Set(ctx echo.Context, ruleList []Rule) error {
 	if err != nil {
 		return fmt.Errorf("failed to set policies: %w", err)
 	}
+	changefeed.Notify(log.ID)
 
 	return nil
 }
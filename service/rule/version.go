@@ -0,0 +1,99 @@
+package rule
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrVersionMismatch is returned when the rules of the subject have changed since the version
+// a change was based on.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// versionColumn is the column of the subject table holding the version of its rules of pType.
+// The policies and the groupings of a subject are versioned apart, so editing the roles of a
+// user does not invalidate a read of its permissions.
+func versionColumn(pType string) string {
+	if pType == model.PTypeGroup {
+		return "role_version"
+	}
+	return "permission_version"
+}
+
+// Version returns the version of the rules of pType of the subject, 0 if it does not exist.
+func Version(ctx context.Context, systemCode, subjectCode, pType string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "rule.Version")
+	defer span.End()
+	record, err := dal.NewRepo[model.Subject]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Code: subjectCode})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query subject, err: %w", err)
+	}
+	if record == nil {
+		return 0, nil
+	}
+	if pType == model.PTypeGroup {
+		return record.RoleVersion, nil
+	}
+	return record.PermissionVersion, nil
+}
+
+// bumpVersion increments the versions of the subjects the rules belong to. When version is not
+// 0 the rules must belong to a single subject, which must have been at that version before the
+// change. The update locks the rows of the subjects until the transaction ends, so of two
+// changes based on the same version only the first one goes through.
+func bumpVersion(ctx context.Context, tx *gorm.DB, ruleList []*model.CasbinRule, version int64) error {
+	column2CodeList := make(map[string][]string)
+	for _, v := range ruleList {
+		column := versionColumn(v.PType)
+		column2CodeList[column] = append(column2CodeList[column], v.V0)
+	}
+	for column, codeList := range column2CodeList {
+		column2CodeList[column] = util.Deduplicate(codeList)
+		err := dal.NewRepo[model.Subject]().UpdateWithMap(ctx, tx, map[string]interface{}{
+			column: gorm.Expr(column + " + 1"),
+		}, func(db *gorm.DB) *gorm.DB {
+			return db.Where("code IN ?", column2CodeList[column])
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update version, err: %w", err)
+		}
+	}
+	if version == 0 {
+		return nil
+	}
+
+	if len(column2CodeList) != 1 {
+		return errors.New("the rules of a versioned change must be of one type")
+	}
+	for column, codeList := range column2CodeList {
+		if len(codeList) != 1 {
+			return errors.New("the rules of a versioned change must belong to one subject")
+		}
+		record, err := dal.NewRepo[model.Subject]().Query(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Subject{Code: codeList[0]})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query subject, err: %w", err)
+		}
+		current := int64(0)
+		if record != nil {
+			current = record.PermissionVersion
+			if column == versionColumn(model.PTypeGroup) {
+				current = record.RoleVersion
+			}
+		}
+		if current != version+1 {
+			return ErrVersionMismatch
+		}
+	}
+	return nil
+}
@@ -0,0 +1,548 @@
+package scim
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/rule"
+	"ac/service/subject"
+	"ac/service/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidMember = errors.New("invalid member")
+
+// change collects the groupings a SCIM operation adds and deletes along with the records
+// written with them, so that the whole operation commits in one transaction.
+type change struct {
+	addList    []rule.Rule
+	deleteList []rule.Rule
+	writeList  []func(tx *gorm.DB) error
+}
+
+func (c *change) write(fn func(tx *gorm.DB) error) {
+	c.writeList = append(c.writeList, fn)
+}
+
+// commit writes the change. The groupings go through the rule service so that they are
+// recorded in casbin_rule_log.
+func (c *change) commit(ctx context.Context) error {
+	fn := func(tx *gorm.DB) error {
+		for _, v := range c.writeList {
+			if err := v(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(c.addList) > 0 || len(c.deleteList) > 0 {
+		return rule.ReplaceWith(ctx, c.deleteList, c.addList, fn)
+	}
+	if err := dal.Transaction(ctx, database.DB, fn); err != nil {
+		return fmt.Errorf("failed to commit, err: %w", err)
+	}
+	return nil
+}
+
+// DeprovisionUser removes every grouping of the user, drops its group memberships and soft deletes it.
+func DeprovisionUser(ctx context.Context, systemCode, userCode string) error {
+	ctx, span := tracing.Start(ctx, "scim.DeprovisionUser")
+	defer span.End()
+	groupingList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.CasbinRule{PType: model.PTypeGroup, V0: userCode})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query groupings, err: %w", err)
+	}
+	c := &change{}
+	for _, v := range groupingList {
+		c.deleteList = append(c.deleteList, rule.Rule{PType: model.PTypeGroup, V0: v.V0, V1: v.V1})
+	}
+	now := util.UTCNow()
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimGroupMember]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimGroupMember{UserCode: userCode})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete group members, err: %w", err)
+		}
+		err = dal.NewRepo[model.ScimRoleGrant]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimRoleGrant{UserCode: userCode})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete role grants, err: %w", err)
+		}
+		err = dal.NewRepo[model.Subject]().Update(ctx, tx, &model.Subject{DeletedAt: &now}, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.Subject{SystemCode: systemCode, Code: userCode, Type: model.SubjectTypeUser}).Limit(1)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete user, err: %w", err)
+		}
+		return webhook.EnqueueSubject(ctx, tx, &model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser, Code: userCode}, webhook.ActionDeleted)
+	})
+	if err := c.commit(ctx); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "user deprovisioned, system code: %s, user code: %s, removed groupings: %d", systemCode, userCode, len(groupingList))
+	return nil
+}
+
+// CreateGroup inserts the group along with its members and grants them the role mapped to the
+// group.
+func CreateGroup(ctx context.Context, group *model.ScimGroup, userCodeList []string) error {
+	ctx, span := tracing.Start(ctx, "scim.CreateGroup")
+	defer span.End()
+	userCodeList, err := validateUserList(ctx, group.SystemCode, userCodeList)
+	if err != nil {
+		return err
+	}
+	valuesToAdd := make([]*model.ScimGroupMember, 0, len(userCodeList))
+	for _, v := range userCodeList {
+		valuesToAdd = append(valuesToAdd, &model.ScimGroupMember{GroupCode: group.Code, UserCode: v, CreatedAt: group.CreatedAt})
+	}
+	c := &change{}
+	c.write(func(tx *gorm.DB) error {
+		if err := dal.NewRepo[model.ScimGroup]().Insert(ctx, tx, group); err != nil {
+			return fmt.Errorf("failed to insert group, err: %w", err)
+		}
+		if len(valuesToAdd) == 0 {
+			return nil
+		}
+		if err := dal.NewRepo[model.ScimGroupMember]().BatchInsert(ctx, tx, valuesToAdd, 20); err != nil {
+			return fmt.Errorf("failed to add group members, err: %w", err)
+		}
+		return nil
+	})
+	if err := grantRole(ctx, c, group.SystemCode, group.DisplayName, userCodeList); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+// AddMembers adds users to the group and grants them the role mapped to the group.
+func AddMembers(ctx context.Context, systemCode string, group *model.ScimGroup, userCodeList []string) error {
+	ctx, span := tracing.Start(ctx, "scim.AddMembers")
+	defer span.End()
+	c := &change{}
+	if err := addMembers(ctx, c, systemCode, group, userCodeList); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+func addMembers(ctx context.Context, c *change, systemCode string, group *model.ScimGroup, userCodeList []string) error {
+	userCodeList, err := validateUserList(ctx, systemCode, userCodeList)
+	if err != nil || len(userCodeList) == 0 {
+		return err
+	}
+
+	memberList, err := QueryMemberList(ctx, group.Code)
+	if err != nil {
+		return err
+	}
+	now := util.UTCNow()
+	valuesToAdd := make([]*model.ScimGroupMember, 0, len(userCodeList))
+	for _, v := range userCodeList {
+		if slices.Contains(memberList, v) {
+			continue
+		}
+		valuesToAdd = append(valuesToAdd, &model.ScimGroupMember{GroupCode: group.Code, UserCode: v, CreatedAt: now})
+	}
+	if len(valuesToAdd) > 0 {
+		c.write(func(tx *gorm.DB) error {
+			if err := dal.NewRepo[model.ScimGroupMember]().BatchInsert(ctx, tx, valuesToAdd, 20); err != nil {
+				return fmt.Errorf("failed to add group members, err: %w", err)
+			}
+			return nil
+		})
+	}
+	return grantRole(ctx, c, systemCode, group.DisplayName, userCodeList)
+}
+
+// RemoveMembers removes users from the group and revokes the role mapped to the group.
+func RemoveMembers(ctx context.Context, systemCode string, group *model.ScimGroup, userCodeList []string) error {
+	ctx, span := tracing.Start(ctx, "scim.RemoveMembers")
+	defer span.End()
+	c := &change{}
+	if err := removeMembers(ctx, c, systemCode, group, userCodeList); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+func removeMembers(ctx context.Context, c *change, systemCode string, group *model.ScimGroup, userCodeList []string) error {
+	if len(userCodeList) == 0 {
+		return nil
+	}
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimGroupMember]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimGroupMember{GroupCode: group.Code}).Where("user_code IN ?", userCodeList)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete group members, err: %w", err)
+		}
+		return nil
+	})
+	return revokeRole(ctx, c, systemCode, group, userCodeList)
+}
+
+// ReplaceMembers makes userCodeList the complete member list of the group.
+func ReplaceMembers(ctx context.Context, systemCode string, group *model.ScimGroup, userCodeList []string) error {
+	ctx, span := tracing.Start(ctx, "scim.ReplaceMembers")
+	defer span.End()
+	memberList, err := QueryMemberList(ctx, group.Code)
+	if err != nil {
+		return err
+	}
+	removeList := slices.DeleteFunc(slices.Clone(memberList), func(s string) bool {
+		return slices.Contains(userCodeList, s)
+	})
+	c := &change{}
+	if err := removeMembers(ctx, c, systemCode, group, removeList); err != nil {
+		return err
+	}
+	if err := addMembers(ctx, c, systemCode, group, userCodeList); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+// RenameGroup changes the display name of the group and moves its members from the
+// previously mapped role to the newly mapped one.
+func RenameGroup(ctx context.Context, systemCode string, group *model.ScimGroup, displayName string) error {
+	ctx, span := tracing.Start(ctx, "scim.RenameGroup")
+	defer span.End()
+	if group.DisplayName == displayName {
+		return nil
+	}
+	memberList, err := QueryMemberList(ctx, group.Code)
+	if err != nil {
+		return err
+	}
+	previousRoleCode, err := mappedRole(ctx, systemCode, group.DisplayName)
+	if err != nil {
+		return err
+	}
+	roleCode, err := mappedRole(ctx, systemCode, displayName)
+	if err != nil {
+		return err
+	}
+	c := &change{}
+	// Both names mapped onto the same role leave the members as they are
+	if previousRoleCode != roleCode {
+		if previousRoleCode != "" {
+			if err := revoke(ctx, c, systemCode, previousRoleCode, []string{group.Code}, memberList); err != nil {
+				return err
+			}
+		}
+		if roleCode != "" {
+			if err := grant(ctx, c, roleCode, memberList); err != nil {
+				return err
+			}
+		}
+	}
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimGroup]().Update(ctx, tx, &model.ScimGroup{DisplayName: displayName, UpdatedAt: util.UTCNow()}, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimGroup{SystemCode: systemCode, Code: group.Code}).Limit(1)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update group, err: %w", err)
+		}
+		return nil
+	})
+	if err := c.commit(ctx); err != nil {
+		return err
+	}
+	group.DisplayName = displayName
+	return nil
+}
+
+// DeleteGroup revokes the mapped role from every member and soft deletes the group.
+func DeleteGroup(ctx context.Context, systemCode string, group *model.ScimGroup) error {
+	ctx, span := tracing.Start(ctx, "scim.DeleteGroup")
+	defer span.End()
+	memberList, err := QueryMemberList(ctx, group.Code)
+	if err != nil {
+		return err
+	}
+	c := &change{}
+	if err := removeMembers(ctx, c, systemCode, group, memberList); err != nil {
+		return err
+	}
+	now := util.UTCNow()
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimGroup]().Update(ctx, tx, &model.ScimGroup{DeletedAt: &now}, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimGroup{SystemCode: systemCode, Code: group.Code}).Limit(1)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete group, err: %w", err)
+		}
+		return nil
+	})
+	if err := c.commit(ctx); err != nil {
+		return err
+	}
+	logger.Infof(ctx, "group deprovisioned, system code: %s, group code: %s, removed members: %d", systemCode, group.Code, len(memberList))
+	return nil
+}
+
+func QueryMemberList(ctx context.Context, groupCode string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "scim.QueryMemberList")
+	defer span.End()
+	recordList, err := dal.NewRepo[model.ScimGroupMember]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimGroupMember{GroupCode: groupCode}).Order("id asc")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group members, err: %w", err)
+	}
+	list := make([]string, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, v.UserCode)
+	}
+	return list, nil
+}
+
+func validateUserList(ctx context.Context, systemCode string, userCodeList []string) ([]string, error) {
+	userCodeList = util.Deduplicate(userCodeList)
+	if len(userCodeList) == 0 {
+		return nil, nil
+	}
+	validateResult, err := subject.ValidateUserBatch(ctx, systemCode, userCodeList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate users, err: %w", err)
+	}
+	for _, v := range userCodeList {
+		if !validateResult[v] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMember, v)
+		}
+	}
+	return userCodeList, nil
+}
+
+// MapRole maps the SCIM groups named displayName onto the role and grants it to the members
+// of the groups provisioned under that name, if any.
+func MapRole(ctx context.Context, systemCode, displayName, roleCode string) error {
+	ctx, span := tracing.Start(ctx, "scim.MapRole")
+	defer span.End()
+	_, memberList, err := queryGroupsByName(ctx, systemCode, displayName)
+	if err != nil {
+		return err
+	}
+	c := &change{}
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimRoleMapping]().Insert(ctx, tx, &model.ScimRoleMapping{
+			SystemCode:  systemCode,
+			DisplayName: displayName,
+			RoleCode:    roleCode,
+			CreatedAt:   util.UTCNow(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert mapping, err: %w", err)
+		}
+		return nil
+	})
+	if err := grant(ctx, c, roleCode, memberList); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+// UnmapRole removes the mapping of displayName and revokes the role from the members of the
+// groups of that name, unless another group still grants it to them.
+func UnmapRole(ctx context.Context, systemCode, displayName string) error {
+	ctx, span := tracing.Start(ctx, "scim.UnmapRole")
+	defer span.End()
+	roleCode, err := mappedRole(ctx, systemCode, displayName)
+	if err != nil || roleCode == "" {
+		return err
+	}
+	groupCodeList, memberList, err := queryGroupsByName(ctx, systemCode, displayName)
+	if err != nil {
+		return err
+	}
+	c := &change{}
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimRoleMapping]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimRoleMapping{SystemCode: systemCode, DisplayName: displayName})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete mapping, err: %w", err)
+		}
+		return nil
+	})
+	if err := revoke(ctx, c, systemCode, roleCode, groupCodeList, memberList); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+// queryGroupsByName returns the codes of the live groups named displayName and their members.
+func queryGroupsByName(ctx context.Context, systemCode, displayName string) ([]string, []string, error) {
+	groupList, err := dal.NewRepo[model.ScimGroup]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimGroup{SystemCode: systemCode, DisplayName: displayName}).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query group, err: %w", err)
+	}
+	groupCodeList := make([]string, 0, len(groupList))
+	memberList := make([]string, 0)
+	for _, v := range groupList {
+		list, err := QueryMemberList(ctx, v.Code)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupCodeList = append(groupCodeList, v.Code)
+		memberList = append(memberList, list...)
+	}
+	return groupCodeList, util.Deduplicate(memberList), nil
+}
+
+// mappedRole returns the code of the role the groups named displayName are mapped onto, empty
+// when they are not mapped.
+func mappedRole(ctx context.Context, systemCode, displayName string) (string, error) {
+	if displayName == "" {
+		return "", nil
+	}
+	record, err := dal.NewRepo[model.ScimRoleMapping]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimRoleMapping{SystemCode: systemCode, DisplayName: displayName})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to query mapped role, err: %w", err)
+	}
+	if record == nil {
+		return "", nil
+	}
+	return record.RoleCode, nil
+}
+
+func grantRole(ctx context.Context, c *change, systemCode, displayName string, userCodeList []string) error {
+	roleCode, err := mappedRole(ctx, systemCode, displayName)
+	if err != nil || roleCode == "" {
+		return err
+	}
+	return grant(ctx, c, roleCode, userCodeList)
+}
+
+// grant adds to the change the groupings of the users missing the role and records them as
+// granted by SCIM. A user already holding the role keeps it as it is.
+func grant(ctx context.Context, c *change, roleCode string, userCodeList []string) error {
+	if len(userCodeList) == 0 {
+		return nil
+	}
+	existingList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.CasbinRule{PType: model.PTypeGroup, V1: roleCode}).Where("v0 IN ?", userCodeList)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query groupings, err: %w", err)
+	}
+	existing := util.ToMap(existingList, func(obj model.CasbinRule) string {
+		return obj.V0
+	})
+	grantList := make([]*model.ScimRoleGrant, 0, len(userCodeList))
+	grantedList := make([]string, 0, len(userCodeList))
+	now := util.UTCNow()
+	for _, v := range userCodeList {
+		if _, ok := existing[v]; ok {
+			continue
+		}
+		c.addList = append(c.addList, rule.Rule{PType: model.PTypeGroup, V0: v, V1: roleCode})
+		grantList = append(grantList, &model.ScimRoleGrant{UserCode: v, RoleCode: roleCode, CreatedAt: now})
+		grantedList = append(grantedList, v)
+	}
+	if len(grantList) == 0 {
+		return nil
+	}
+	c.write(func(tx *gorm.DB) error {
+		// A grant left behind by a grouping deleted by hand is replaced
+		err := dal.NewRepo[model.ScimRoleGrant]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimRoleGrant{RoleCode: roleCode}).Where("user_code IN ?", grantedList)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete role grants, err: %w", err)
+		}
+		return dal.NewRepo[model.ScimRoleGrant]().BatchInsert(ctx, tx, grantList, 20)
+	})
+	return nil
+}
+
+func revokeRole(ctx context.Context, c *change, systemCode string, group *model.ScimGroup, userCodeList []string) error {
+	roleCode, err := mappedRole(ctx, systemCode, group.DisplayName)
+	if err != nil || roleCode == "" {
+		return err
+	}
+	return revoke(ctx, c, systemCode, roleCode, []string{group.Code}, userCodeList)
+}
+
+// revoke adds to the change the removal of the role from the users it was granted to by SCIM,
+// except from those still members of a group outside excludeGroupCodeList mapped onto the
+// role. A role granted by an administrator is left alone.
+func revoke(ctx context.Context, c *change, systemCode, roleCode string, excludeGroupCodeList, userCodeList []string) error {
+	if len(userCodeList) == 0 {
+		return nil
+	}
+	grantList, err := dal.NewRepo[model.ScimRoleGrant]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.ScimRoleGrant{RoleCode: roleCode}).Where("user_code IN ?", userCodeList)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query role grants, err: %w", err)
+	}
+	if len(grantList) == 0 {
+		return nil
+	}
+	candidateList := make([]string, 0, len(grantList))
+	for _, v := range grantList {
+		candidateList = append(candidateList, v.UserCode)
+	}
+	keptList, err := dal.NewRepo[model.ScimGroupMember]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		db = db.Where("user_code IN ?", candidateList)
+		if len(excludeGroupCodeList) > 0 {
+			db = db.Where("group_code NOT IN ?", excludeGroupCodeList)
+		}
+		return db.Where("group_code IN (?)", database.DB.Model(&model.ScimGroup{}).Select("code").
+			Where("system_code = ? AND deleted_at IS NULL", systemCode).
+			Where("display_name IN (?)", database.DB.Model(&model.ScimRoleMapping{}).Select("display_name").
+				Where(model.ScimRoleMapping{SystemCode: systemCode, RoleCode: roleCode})))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query group members, err: %w", err)
+	}
+	kept := util.ToMap(keptList, func(obj model.ScimGroupMember) string {
+		return obj.UserCode
+	})
+	revokeList := slices.DeleteFunc(candidateList, func(s string) bool {
+		_, ok := kept[s]
+		return ok
+	})
+	if len(revokeList) == 0 {
+		return nil
+	}
+
+	existingList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.CasbinRule{PType: model.PTypeGroup, V1: roleCode}).Where("v0 IN ?", revokeList)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query groupings, err: %w", err)
+	}
+	for _, v := range existingList {
+		c.deleteList = append(c.deleteList, rule.Rule{PType: model.PTypeGroup, V0: v.V0, V1: v.V1})
+	}
+	c.write(func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.ScimRoleGrant]().Delete(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.ScimRoleGrant{RoleCode: roleCode}).Where("user_code IN ?", revokeList)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete role grants, err: %w", err)
+		}
+		return nil
+	})
+	return nil
+}
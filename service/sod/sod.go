@@ -0,0 +1,474 @@
+// Package sod enforces separation of duties: a user may hold at most one role of every
+// constraint of a system, whether the role is granted directly or inherited from another role.
+package sod
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/define"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"ac/service/casbin"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxDepth bounds the walk up the role inheritance, it also stops on a loop.
+const maxDepth = 10
+
+var ErrViolation = errors.New("separation of duties violation")
+
+type Violation struct {
+	UserCode       string   `json:"user_code"`
+	UserName       string   `json:"user_name"`
+	ConstraintCode string   `json:"constraint_code"`
+	ConstraintName string   `json:"constraint_name"`
+	RoleCodeList   []string `json:"role_code_list"`
+}
+
+func IsCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "sod.IsCodeAvailable")
+	defer span.End()
+	if code == "" {
+		return false, errors.New("code is empty")
+	}
+	if !strings.HasPrefix(code, define.PrefixSod) {
+		return false, fmt.Errorf("code must start with the prefix '%s'", define.PrefixSod)
+	}
+	record, err := dal.NewRepo[model.SodConstraint]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.SodConstraint{Code: code})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query, err: %w, code: %s", err, code)
+	}
+	return record == nil, nil
+}
+
+// Validate reports whether the constraint exists and belongs to the system.
+func Validate(ctx context.Context, systemCode, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "sod.Validate")
+	defer span.End()
+	if code == "" {
+		return false, errors.New("code is empty")
+	}
+	record, err := dal.NewRepo[model.SodConstraint]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.SodConstraint{SystemCode: systemCode, Code: code}).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query, err: %w, code: %s", err, code)
+	}
+	return record != nil, nil
+}
+
+// membership is a grouping of a user with the time window it is in force, a zero bound is
+// open like in the matcher.
+type membership struct {
+	roleCode   string
+	begin, end time.Time
+}
+
+func toMembership(v *model.CasbinRule) (membership, error) {
+	begin, end, err := casbin.ParseWindow(v.V3, v.V4)
+	if err != nil {
+		return membership{}, fmt.Errorf("failed to parse membership window, err: %w, user code: %s, role code: %s", err, v.V0, v.V1)
+	}
+	return membership{roleCode: v.V1, begin: begin, end: end}, nil
+}
+
+// expired reports whether the membership is over and can no longer be in force.
+func (m membership) expired(now time.Time) bool {
+	return !m.end.IsZero() && now.After(m.end)
+}
+
+// overlaps reports whether the two memberships are in force at the same time at some point.
+func (m membership) overlaps(o membership) bool {
+	return (m.end.IsZero() || o.begin.IsZero() || !o.begin.After(m.end)) &&
+		(o.end.IsZero() || m.begin.IsZero() || !m.begin.After(o.end))
+}
+
+// roleCodes returns the roles of the memberships overlapping target.
+func roleCodes(list []membership, target membership) []string {
+	roleCodeList := make([]string, 0, len(list))
+	for _, v := range list {
+		if v.overlaps(target) {
+			roleCodeList = append(roleCodeList, v.roleCode)
+		}
+	}
+	return roleCodeList
+}
+
+// Check is called with the rules about to be added in tx. It fails with ErrViolation when a
+// grouping makes a user hold more roles of a constraint than before, once two or more. Only
+// the memberships in force at the same time count, the expired ones never do. A grouping of a
+// role under another role is checked for every user holding the role, directly or through the
+// roles inheriting it, as they all inherit the other role from then on.
+func Check(ctx context.Context, tx *gorm.DB, ruleList []*model.CasbinRule) error {
+	ctx, span := tracing.Start(ctx, "sod.Check")
+	defer span.End()
+	now := time.Now()
+	groupingList := make([]*model.CasbinRule, 0, len(ruleList))
+	codeList := make([]string, 0, len(ruleList))
+	for _, v := range ruleList {
+		if v.PType == model.PTypeGroup {
+			groupingList = append(groupingList, v)
+			codeList = append(codeList, v.V0)
+		}
+	}
+	if len(groupingList) == 0 {
+		return nil
+	}
+	roleCodeSet, err := roleCodesOf(ctx, tx, codeList)
+	if err != nil {
+		return err
+	}
+	user2NewList := make(map[string][]membership)
+	role2NewParentList := make(map[string][]string)
+	for _, v := range groupingList {
+		if _, ok := roleCodeSet[v.V0]; ok {
+			role2NewParentList[v.V0] = append(role2NewParentList[v.V0], v.V1)
+			continue
+		}
+		m, err := toMembership(v)
+		if err != nil {
+			return err
+		}
+		user2NewList[v.V0] = append(user2NewList[v.V0], m)
+	}
+	userCodeList := make([]string, 0, len(user2NewList))
+	for k := range user2NewList {
+		userCodeList = append(userCodeList, k)
+	}
+	if len(role2NewParentList) > 0 {
+		childCodeList := make([]string, 0, len(role2NewParentList))
+		for k := range role2NewParentList {
+			childCodeList = append(childCodeList, k)
+		}
+		holderList, err := holdersOf(ctx, tx, childCodeList)
+		if err != nil {
+			return err
+		}
+		userCodeList = append(userCodeList, holderList...)
+	}
+	userCodeList = util.Deduplicate(userCodeList)
+	if len(userCodeList) == 0 {
+		return nil
+	}
+	sort.Strings(userCodeList)
+
+	// Concurrent grants to the same user are checked one after another
+	_, err = dal.NewRepo[model.Subject]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("code IN ?", userCodeList).Order("code asc").Clauses(clause.Locking{Strength: "UPDATE"})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lock user, err: %w", err)
+	}
+
+	membershipList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v0 IN ?", userCodeList)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query grouping, err: %w", err)
+	}
+	user2List := make(map[string][]membership)
+	roleCodeList := make([]string, 0)
+	for _, v := range membershipList {
+		m, err := toMembership(&v)
+		if err != nil {
+			return err
+		}
+		if m.expired(now) {
+			continue
+		}
+		user2List[v.V0] = append(user2List[v.V0], m)
+		roleCodeList = append(roleCodeList, v.V1)
+	}
+	for _, list := range user2NewList {
+		for _, v := range list {
+			roleCodeList = append(roleCodeList, v.roleCode)
+		}
+	}
+	for _, list := range role2NewParentList {
+		roleCodeList = append(roleCodeList, list...)
+	}
+	role2ParentList, err := parentRoles(ctx, tx, roleCodeList)
+	if err != nil {
+		return err
+	}
+	// The inheritance once the new groupings of roles are added
+	role2ParentListAfter := make(map[string][]string, len(role2ParentList))
+	for k, v := range role2ParentList {
+		role2ParentListAfter[k] = v
+	}
+	for k, v := range role2NewParentList {
+		role2ParentListAfter[k] = append(slices.Clone(role2ParentListAfter[k]), v...)
+	}
+
+	allRoleCodeList := make([]string, 0, len(role2ParentListAfter))
+	for k := range role2ParentListAfter {
+		allRoleCodeList = append(allRoleCodeList, k)
+	}
+	role2ConstraintList, code2Constraint, err := constraintsOf(ctx, tx, allRoleCodeList)
+	if err != nil {
+		return err
+	}
+	if len(role2ConstraintList) == 0 {
+		return nil
+	}
+
+	for _, userCode := range userCodeList {
+		// Every membership is checked against those in force at the same time
+		targetList := append(slices.Clone(user2List[userCode]), user2NewList[userCode]...)
+		for _, target := range targetList {
+			if target.expired(now) {
+				continue
+			}
+			beforeList := roleCodes(user2List[userCode], target)
+			afterList := append(slices.Clone(beforeList), roleCodes(user2NewList[userCode], target)...)
+			before := heldByConstraint(expand(beforeList, role2ParentList), role2ConstraintList)
+			after := heldByConstraint(expand(afterList, role2ParentListAfter), role2ConstraintList)
+			for constraintCode, roleList := range after {
+				if len(roleList) >= 2 && len(roleList) > len(before[constraintCode]) {
+					sort.Strings(roleList)
+					return fmt.Errorf("%w, user code: %s, constraint: %s, role codes: %s",
+						ErrViolation, userCode, code2Constraint[constraintCode].Name, strings.Join(roleList, ","))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// roleCodesOf returns which of the subjects are roles.
+func roleCodesOf(ctx context.Context, tx *gorm.DB, codeList []string) (map[string]struct{}, error) {
+	roleList, err := dal.NewRepo[model.Subject]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{Type: model.SubjectTypeRole}).Where("code IN ?", util.Deduplicate(codeList))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role, err: %w", err)
+	}
+	roleCodeSet := make(map[string]struct{}, len(roleList))
+	for _, v := range roleList {
+		roleCodeSet[v.Code] = struct{}{}
+	}
+	return roleCodeSet, nil
+}
+
+// holdersOf walks the role inheritance down from the roles and returns the users reached, those
+// holding one of the roles directly or through a role inheriting it.
+func holdersOf(ctx context.Context, tx *gorm.DB, roleCodeList []string) ([]string, error) {
+	seen := make(map[string]struct{})
+	pending := util.Deduplicate(roleCodeList)
+	for depth := 0; len(pending) > 0 && depth < maxDepth; depth++ {
+		for _, v := range pending {
+			seen[v] = struct{}{}
+		}
+		groupingList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v1 IN ?", pending)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query grouping, err: %w", err)
+		}
+		next := make([]string, 0)
+		for _, v := range groupingList {
+			if _, ok := seen[v.V0]; !ok {
+				next = append(next, v.V0)
+			}
+		}
+		pending = util.Deduplicate(next)
+	}
+	for _, v := range pending {
+		seen[v] = struct{}{}
+	}
+	codeList := make([]string, 0, len(seen))
+	for k := range seen {
+		codeList = append(codeList, k)
+	}
+	userList, err := dal.NewRepo[model.Subject]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{Type: model.SubjectTypeUser}).Where("code IN ?", codeList)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user, err: %w", err)
+	}
+	list := make([]string, 0, len(userList))
+	for _, v := range userList {
+		list = append(list, v.Code)
+	}
+	return list, nil
+}
+
+// Violations finds the users of the system that already hold more than one role of a constraint.
+func Violations(ctx context.Context, systemCode string) ([]Violation, error) {
+	ctx, span := tracing.Start(ctx, "sod.Violations")
+	defer span.End()
+	constraintList, err := dal.NewRepo[model.SodConstraint]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.SodConstraint{SystemCode: systemCode}).Where("deleted_at IS NULL").Order("id asc")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query constraint, err: %w", err)
+	}
+	if len(constraintList) == 0 {
+		return []Violation{}, nil
+	}
+	constraintCodeList := make([]string, 0, len(constraintList))
+	for _, v := range constraintList {
+		constraintCodeList = append(constraintCodeList, v.Code)
+	}
+	constraintRoleList, err := dal.NewRepo[model.SodConstraintRole]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("constraint_code IN ?", constraintCodeList)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query constraint role, err: %w", err)
+	}
+
+	enforcer, err := casbin.SharedEnforcer(ctx, database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enforcer, err: %w", err)
+	}
+	type key struct {
+		userCode       string
+		constraintCode string
+	}
+	held := make(map[key][]string)
+	userCodeList := make([]string, 0)
+	for _, v := range constraintRoleList {
+		userList, err := enforcer.GetImplicitUsersForRole(v.RoleCode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get users for role, err: %w, role code: %s", err, v.RoleCode)
+		}
+		for _, userCode := range userList {
+			k := key{userCode, v.ConstraintCode}
+			held[k] = append(held[k], v.RoleCode)
+			userCodeList = append(userCodeList, userCode)
+		}
+	}
+	if len(userCodeList) == 0 {
+		return []Violation{}, nil
+	}
+
+	userList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Type: model.SubjectTypeUser}).
+			Where("code IN ?", util.Deduplicate(userCodeList)).Where("deleted_at IS NULL").Order("code asc")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user, err: %w", err)
+	}
+	list := make([]Violation, 0)
+	for _, user := range userList {
+		for _, constraint := range constraintList {
+			roleList := held[key{user.Code, constraint.Code}]
+			if len(roleList) < 2 {
+				continue
+			}
+			sort.Strings(roleList)
+			list = append(list, Violation{
+				UserCode:       user.Code,
+				UserName:       user.Name,
+				ConstraintCode: constraint.Code,
+				ConstraintName: constraint.Name,
+				RoleCodeList:   roleList,
+			})
+		}
+	}
+	return list, nil
+}
+
+// parentRoles walks the role inheritance up from the roles, the result has an entry for every
+// role reached, with the roles it directly inherits.
+func parentRoles(ctx context.Context, tx *gorm.DB, roleCodeList []string) (map[string][]string, error) {
+	role2ParentList := make(map[string][]string)
+	pending := util.Deduplicate(roleCodeList)
+	for depth := 0; len(pending) > 0 && depth < maxDepth; depth++ {
+		for _, v := range pending {
+			role2ParentList[v] = nil
+		}
+		groupingList, err := dal.NewRepo[model.CasbinRule]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.CasbinRule{PType: model.PTypeGroup}).Where("v0 IN ?", pending)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query grouping, err: %w", err)
+		}
+		next := make([]string, 0)
+		for _, v := range groupingList {
+			role2ParentList[v.V0] = append(role2ParentList[v.V0], v.V1)
+			if _, ok := role2ParentList[v.V1]; !ok {
+				next = append(next, v.V1)
+			}
+		}
+		pending = util.Deduplicate(next)
+	}
+	return role2ParentList, nil
+}
+
+// expand returns the roles together with every role they inherit.
+func expand(roleCodeList []string, role2ParentList map[string][]string) []string {
+	seen := make(map[string]struct{})
+	pending := roleCodeList
+	for len(pending) > 0 {
+		next := make([]string, 0)
+		for _, v := range pending {
+			if _, ok := seen[v]; ok {
+				continue
+			}
+			seen[v] = struct{}{}
+			next = append(next, role2ParentList[v]...)
+		}
+		pending = next
+	}
+	list := make([]string, 0, len(seen))
+	for k := range seen {
+		list = append(list, k)
+	}
+	return list
+}
+
+func constraintsOf(ctx context.Context, tx *gorm.DB, roleCodeList []string) (map[string][]string, map[string]model.SodConstraint, error) {
+	constraintRoleList, err := dal.NewRepo[model.SodConstraintRole]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("role_code IN ?", roleCodeList)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query constraint role, err: %w", err)
+	}
+	constraintCodeList := make([]string, 0, len(constraintRoleList))
+	for _, v := range constraintRoleList {
+		constraintCodeList = append(constraintCodeList, v.ConstraintCode)
+	}
+	if len(constraintCodeList) == 0 {
+		return nil, nil, nil
+	}
+	constraintList, err := dal.NewRepo[model.SodConstraint]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("code IN ?", util.Deduplicate(constraintCodeList)).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query constraint, err: %w", err)
+	}
+	code2Constraint := util.ToMap(constraintList, func(obj model.SodConstraint) string {
+		return obj.Code
+	})
+	role2ConstraintList := make(map[string][]string)
+	for _, v := range constraintRoleList {
+		if _, ok := code2Constraint[v.ConstraintCode]; ok {
+			role2ConstraintList[v.RoleCode] = append(role2ConstraintList[v.RoleCode], v.ConstraintCode)
+		}
+	}
+	return role2ConstraintList, code2Constraint, nil
+}
+
+// heldByConstraint groups the roles by the constraints they belong to.
+func heldByConstraint(roleCodeList []string, role2ConstraintList map[string][]string) map[string][]string {
+	constraint2RoleList := make(map[string][]string)
+	for _, v := range roleCodeList {
+		for _, constraintCode := range role2ConstraintList[v] {
+			constraint2RoleList[constraintCode] = append(constraint2RoleList[constraintCode], v)
+		}
+	}
+	return constraint2RoleList
+}
@@ -0,0 +1,216 @@
+package sod
+
+import (
+	"ac/bootstrap/database"
+	"ac/model"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// The tables with MySQL-only column definitions are created by hand
+var ddlList = []string{
+	`CREATE TABLE subject (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		system_code varchar(50) NOT NULL DEFAULT '',
+		type varchar(10) NOT NULL DEFAULT 'user',
+		name varchar(50) NOT NULL DEFAULT '',
+		code varchar(50) NOT NULL DEFAULT '',
+		external_id varchar(100) NOT NULL DEFAULT '',
+		external_id_key varchar(100),
+		description varchar(50) NOT NULL DEFAULT '',
+		permission_version int NOT NULL DEFAULT 1,
+		role_version int NOT NULL DEFAULT 1,
+		modified_by varchar(50) NOT NULL DEFAULT '',
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at datetime
+	)`,
+	`CREATE TABLE sod_constraint (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		system_code varchar(50) NOT NULL DEFAULT '',
+		code varchar(50) NOT NULL DEFAULT '',
+		name varchar(50) NOT NULL DEFAULT '',
+		description varchar(200) NOT NULL DEFAULT '',
+		modified_by varchar(50) NOT NULL DEFAULT '',
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at datetime
+	)`,
+	`CREATE TABLE sod_constraint_role (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		constraint_code varchar(50) NOT NULL DEFAULT '',
+		role_code varchar(50) NOT NULL DEFAULT '',
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+}
+
+var now = time.Now().UTC()
+
+func at(d time.Duration) string {
+	return now.Add(d).Format(time.RFC3339)
+}
+
+// TestMain loads a system whose constraint keeps the buyer and the payer roles apart, the
+// shared enforcer Violations uses starts from it.
+func TestMain(m *testing.M) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	// Every connection would open a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		panic(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	for _, v := range ddlList {
+		if err := db.Exec(v).Error; err != nil {
+			panic(err)
+		}
+	}
+	if err := db.AutoMigrate(&model.CasbinRule{}); err != nil {
+		panic(err)
+	}
+
+	subjectList := make([]model.Subject, 0)
+	for _, v := range []string{"user_buyer", "user_inherit", "user_expired", "user_window", "user_both"} {
+		subjectList = append(subjectList, model.Subject{SystemCode: "sys", Type: model.SubjectTypeUser, Code: v, Name: v})
+	}
+	for _, v := range []string{"role_buy", "role_pay", "role_payer", "role_clerk", "role_sub"} {
+		subjectList = append(subjectList, model.Subject{SystemCode: "sys", Type: model.SubjectTypeRole, Code: v, Name: v})
+	}
+	ruleList := []model.CasbinRule{
+		{PType: model.PTypeGroup, V0: "role_payer", V1: "role_pay"},
+		{PType: model.PTypeGroup, V0: "role_sub", V1: "role_clerk"},
+		{PType: model.PTypeGroup, V0: "user_buyer", V1: "role_buy"},
+		{PType: model.PTypeGroup, V0: "user_inherit", V1: "role_buy"},
+		{PType: model.PTypeGroup, V0: "user_inherit", V1: "role_sub"},
+		{PType: model.PTypeGroup, V0: "user_expired", V1: "role_buy", V3: at(-2 * time.Hour), V4: at(-time.Hour)},
+		{PType: model.PTypeGroup, V0: "user_window", V1: "role_buy", V3: at(time.Hour), V4: at(2 * time.Hour)},
+		{PType: model.PTypeGroup, V0: "user_both", V1: "role_buy"},
+		{PType: model.PTypeGroup, V0: "user_both", V1: "role_payer"},
+	}
+	for _, v := range []any{
+		&subjectList,
+		&model.SodConstraint{SystemCode: "sys", Code: "sod_purchase", Name: "purchase"},
+		&[]model.SodConstraintRole{
+			{ConstraintCode: "sod_purchase", RoleCode: "role_buy"},
+			{ConstraintCode: "sod_purchase", RoleCode: "role_pay"},
+		},
+		&ruleList,
+	} {
+		if err := db.Create(v).Error; err != nil {
+			panic(err)
+		}
+	}
+	database.DB = db
+	os.Exit(m.Run())
+}
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ruleList []*model.CasbinRule
+		wantErr  error
+	}{
+		{
+			name:     "direct conflict",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "user_buyer", V1: "role_pay"}},
+			wantErr:  ErrViolation,
+		},
+		{
+			name:     "conflict through an inherited role",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "user_buyer", V1: "role_payer"}},
+			wantErr:  ErrViolation,
+		},
+		{
+			name: "conflict within the new rules",
+			ruleList: []*model.CasbinRule{
+				{PType: model.PTypeGroup, V0: "user_expired", V1: "role_buy"},
+				{PType: model.PTypeGroup, V0: "user_expired", V1: "role_pay"},
+			},
+			wantErr: ErrViolation,
+		},
+		{
+			name:     "role outside the constraint",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "user_buyer", V1: "role_clerk"}},
+		},
+		{
+			name:     "policy",
+			ruleList: []*model.CasbinRule{{PType: model.PTypePolicy, V0: "user_buyer", V1: "sys/*", V2: "manage"}},
+		},
+		{
+			name:     "role gaining a parent held by an inheriting role",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "role_clerk", V1: "role_pay"}},
+			wantErr:  ErrViolation,
+		},
+		{
+			name:     "role gaining a parent already held",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "role_clerk", V1: "role_buy"}},
+		},
+		{
+			name:     "expired membership",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "user_expired", V1: "role_pay"}},
+		},
+		{
+			name: "windows apart",
+			ruleList: []*model.CasbinRule{
+				{PType: model.PTypeGroup, V0: "user_window", V1: "role_pay", V3: at(3 * time.Hour), V4: at(4 * time.Hour)},
+			},
+		},
+		{
+			name: "windows overlapping",
+			ruleList: []*model.CasbinRule{
+				{PType: model.PTypeGroup, V0: "user_window", V1: "role_pay", V3: at(90 * time.Minute), V4: at(4 * time.Hour)},
+			},
+			wantErr: ErrViolation,
+		},
+		{
+			name:     "open window",
+			ruleList: []*model.CasbinRule{{PType: model.PTypeGroup, V0: "user_window", V1: "role_pay"}},
+			wantErr:  ErrViolation,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx := database.DB.Begin()
+			defer tx.Rollback()
+			err := Check(context.Background(), tx, tc.ruleList)
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+				t.Errorf("Check() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestViolations(t *testing.T) {
+	list, err := Violations(context.Background(), "sys")
+	if err != nil {
+		t.Fatalf("Violations() err: %v", err)
+	}
+	want := []Violation{{
+		UserCode:       "user_both",
+		UserName:       "user_both",
+		ConstraintCode: "sod_purchase",
+		ConstraintName: "purchase",
+		RoleCodeList:   []string{"role_buy", "role_pay"},
+	}}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("Violations() = %+v, want %+v", list, want)
+	}
+
+	list, err = Violations(context.Background(), "other")
+	if err != nil {
+		t.Fatalf("Violations() err: %v", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("Violations() of another system = %+v, want none", list)
+	}
+}
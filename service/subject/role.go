@@ -1,19 +1,43 @@
 package subject
 
 import (
+	"ac/custom/tracing"
 	"ac/model"
-
-	"github.com/labstack/echo/v4"
+	"context"
 )
 
-func ValidateRole(ctx echo.Context, systemCode, code string) (bool, error) {
+func ValidateRole(ctx context.Context, systemCode, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.ValidateRole")
+	defer span.End()
 	return validate(ctx, systemCode, model.SubjectTypeRole, code)
 }
 
-func ValidateRoleBatch(ctx echo.Context, systemCode string, codeList []string) (map[string]bool, error) {
+func ValidateRoleBatch(ctx context.Context, systemCode string, codeList []string) (map[string]bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.ValidateRoleBatch")
+	defer span.End()
 	return validateBatch(ctx, systemCode, model.SubjectTypeRole, codeList)
 }
 
-func IsRoleCodeAvailable(ctx echo.Context, code string) (bool, error) {
+func IsRoleCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.IsRoleCodeAvailable")
+	defer span.End()
 	return isCodeAvailable(ctx, model.SubjectTypeRole, code)
 }
+
+func IsRoleExternalIDAvailable(ctx context.Context, systemCode, externalID string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.IsRoleExternalIDAvailable")
+	defer span.End()
+	return isExternalIDAvailable(ctx, systemCode, externalID)
+}
+
+func QueryRoleByName(ctx context.Context, systemCode, name string) ([]Subject, error) {
+	ctx, span := tracing.Start(ctx, "subject.QueryRoleByName")
+	defer span.End()
+	return queryByName(ctx, systemCode, model.SubjectTypeRole, name)
+}
+
+func QueryRoleByExternalID(ctx context.Context, systemCode, externalID string) (*Subject, error) {
+	ctx, span := tracing.Start(ctx, "subject.QueryRoleByExternalID")
+	defer span.End()
+	return queryByExternalID(ctx, systemCode, model.SubjectTypeRole, externalID)
+}
@@ -4,16 +4,17 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/custom/define"
+	"ac/custom/tracing"
 	"ac/custom/util"
 	"ac/dal"
 	"ac/model"
+	"context"
 	"errors"
 	"fmt"
 	"slices"
 	"strings"
 	"time"
 
-	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
@@ -23,15 +24,18 @@ type Subject struct {
 	Description string    `json:"description"`
 	SystemCode  string    `json:"system_code"`
 	Code        string    `json:"code"`
+	ExternalID  string    `json:"external_id"`
 	ModifiedBy  string    `json:"modified_by"`
 	UpdatedAt   time.Time `json:"update_at"`
 }
 
-func Validate(ctx echo.Context, systemCode, code string) (bool, error) {
+func Validate(ctx context.Context, systemCode, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.Validate")
+	defer span.End()
 	return validate(ctx, systemCode, "", code)
 }
 
-func validate(ctx echo.Context, systemCode, subjectType, code string) (bool, error) {
+func validate(ctx context.Context, systemCode, subjectType, code string) (bool, error) {
 	if systemCode == "" || code == "" {
 		return false, errors.New("systemCode or code is empty")
 	}
@@ -59,7 +63,7 @@ func validate(ctx echo.Context, systemCode, subjectType, code string) (bool, err
 	return true, nil
 }
 
-func validateBatch(ctx echo.Context, systemCode string, subjectType string, codeList []string) (map[string]bool, error) {
+func validateBatch(ctx context.Context, systemCode string, subjectType string, codeList []string) (map[string]bool, error) {
 	if systemCode == "" || len(codeList) == 0 {
 		return nil, errors.New("systemCode or codeList is empty")
 	}
@@ -102,7 +106,7 @@ func validateBatch(ctx echo.Context, systemCode string, subjectType string, code
 	return result, nil
 }
 
-func isCodeAvailable(ctx echo.Context, subjectType, code string) (bool, error) {
+func isCodeAvailable(ctx context.Context, subjectType, code string) (bool, error) {
 	if code == "" {
 		return false, errors.New("code is empty")
 	}
@@ -120,3 +124,65 @@ func isCodeAvailable(ctx echo.Context, subjectType, code string) (bool, error) {
 	}
 	return record == nil, nil
 }
+
+// isExternalIDAvailable reports whether no subject of the system, user or role, uses the
+// external id, which is unique within the system.
+func isExternalIDAvailable(ctx context.Context, systemCode, externalID string) (bool, error) {
+	if systemCode == "" || externalID == "" {
+		return false, errors.New("systemCode or externalID is empty")
+	}
+	record, err := dal.NewRepo[model.Subject]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, ExternalID: externalID})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query, err: %w, system code: %s, external id: %s", err, systemCode, externalID)
+	}
+	return record == nil, nil
+}
+
+func queryByName(ctx context.Context, systemCode, subjectType, name string) ([]Subject, error) {
+	if systemCode == "" || name == "" {
+		return nil, errors.New("systemCode or name is empty")
+	}
+	recordList, err := dal.NewRepo[model.Subject]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, Name: name, Type: subjectType}).Order("id asc")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query, err: %w, system code: %s, name: %s", err, systemCode, name)
+	}
+	list := make([]Subject, 0, len(recordList))
+	for _, v := range recordList {
+		list = append(list, toSubject(v))
+	}
+	return list, nil
+}
+
+func queryByExternalID(ctx context.Context, systemCode, subjectType, externalID string) (*Subject, error) {
+	if systemCode == "" || externalID == "" {
+		return nil, errors.New("systemCode or externalID is empty")
+	}
+	record, err := dal.NewRepo[model.Subject]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Subject{SystemCode: systemCode, ExternalID: externalID, Type: subjectType})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query, err: %w, system code: %s, external id: %s", err, systemCode, externalID)
+	}
+	if record == nil {
+		return nil, nil
+	}
+	subject := toSubject(*record)
+	return &subject, nil
+}
+
+func toSubject(record model.Subject) Subject {
+	return Subject{
+		ID:          record.ID,
+		Name:        record.Name,
+		Description: record.Description,
+		SystemCode:  record.SystemCode,
+		Code:        record.Code,
+		ExternalID:  record.ExternalID,
+		ModifiedBy:  record.ModifiedBy,
+		UpdatedAt:   record.UpdatedAt,
+	}
+}
@@ -1,20 +1,44 @@
 package subject
 
 import (
+	"ac/custom/tracing"
 	"ac/model"
-
-	"github.com/labstack/echo/v4"
+	"context"
 )
 
-func ValidateUser(ctx echo.Context, systemCode, code string) (bool, error) {
+func ValidateUser(ctx context.Context, systemCode, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.ValidateUser")
+	defer span.End()
 	return validate(ctx, systemCode, model.SubjectTypeUser, code)
 }
 
-func ValidateUserBatch(ctx echo.Context, systemCode string, codeList []string) (map[string]bool, error) {
+func ValidateUserBatch(ctx context.Context, systemCode string, codeList []string) (map[string]bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.ValidateUserBatch")
+	defer span.End()
 	return validateBatch(ctx, systemCode, model.SubjectTypeUser, codeList)
 
 }
 
-func IsUserCodeAvailable(ctx echo.Context, code string) (bool, error) {
+func IsUserCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.IsUserCodeAvailable")
+	defer span.End()
 	return isCodeAvailable(ctx, model.SubjectTypeUser, code)
 }
+
+func IsUserExternalIDAvailable(ctx context.Context, systemCode, externalID string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "subject.IsUserExternalIDAvailable")
+	defer span.End()
+	return isExternalIDAvailable(ctx, systemCode, externalID)
+}
+
+func QueryUserByName(ctx context.Context, systemCode, name string) ([]Subject, error) {
+	ctx, span := tracing.Start(ctx, "subject.QueryUserByName")
+	defer span.End()
+	return queryByName(ctx, systemCode, model.SubjectTypeUser, name)
+}
+
+func QueryUserByExternalID(ctx context.Context, systemCode, externalID string) (*Subject, error) {
+	ctx, span := tracing.Start(ctx, "subject.QueryUserByExternalID")
+	defer span.End()
+	return queryByExternalID(ctx, systemCode, model.SubjectTypeUser, externalID)
+}
@@ -4,14 +4,15 @@ import (
 	"ac/bootstrap/database"
 	"ac/bootstrap/logger"
 	"ac/custom/define"
+	"ac/custom/tracing"
 	"ac/dal"
 	"ac/model"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
-	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
@@ -24,7 +25,9 @@ type System struct {
 	UpdatedAt   time.Time `json:"update_at"`
 }
 
-func Validate(ctx echo.Context, code string) (bool, error) {
+func Validate(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "system.Validate")
+	defer span.End()
 	if code == "" {
 		return false, errors.New("code is empty")
 	}
@@ -41,7 +44,9 @@ func Validate(ctx echo.Context, code string) (bool, error) {
 	return true, nil
 }
 
-func IsCodeAvailable(ctx echo.Context, code string) (bool, error) {
+func IsCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "system.IsCodeAvailable")
+	defer span.End()
 	if code == "" {
 		return false, errors.New("code is empty")
 	}
@@ -57,7 +62,7 @@ func IsCodeAvailable(ctx echo.Context, code string) (bool, error) {
 	return record == nil, nil
 }
 
-// func QueryByCode(ctx echo.Context, code string) (*System, error) {
+// func QueryByCode(ctx context.Context, code string) (*System, error) {
 // 	if code == "" {
 // 		return nil, errors.New("code is empty")
 // 	}
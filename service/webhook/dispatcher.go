@@ -0,0 +1,353 @@
+package webhook
+
+import (
+	"ac/bootstrap/database"
+	"ac/bootstrap/logger"
+	"ac/custom/background"
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	batchSize    = 100
+	maxAttempts  = 8
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+	claimTimeout = time.Minute
+	sendTimeout  = 10 * time.Second
+	// claimSize deliveries are claimed at a time, few enough to be sent within half the claim
+	// timeout even if every receiver times out
+	claimSize = int(claimTimeout / sendTimeout / 2)
+
+	maxErrorLength = 500
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// skipLocked lets several instances work on the same table without handing out a row twice.
+func skipLocked(db *gorm.DB) *gorm.DB {
+	return db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+}
+
+// Run fans the outbox out to the subscriptions and delivers the pending deliveries until ctx is done.
+func Run(ctx context.Context, interval time.Duration) {
+	c := background.NewContext(ctx, http.MethodPost, "webhook")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := dispatch(c); err != nil {
+			logger.Errorf(c, "failed to dispatch webhook events, err: %v", err)
+		}
+		if err := deliver(c); err != nil {
+			logger.Errorf(c, "failed to deliver webhook events, err: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch creates a delivery for every subscription of every new outbox event.
+func dispatch(ctx context.Context) error {
+	return dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		outboxList, err := dal.NewRepo[model.WebhookOutbox]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("dispatched_at IS NULL").Order("id asc").Limit(batchSize)
+		}, skipLocked)
+		if err != nil {
+			return fmt.Errorf("failed to query outbox, err: %w", err)
+		}
+		if len(outboxList) == 0 {
+			return nil
+		}
+
+		systemCodeList := make([]string, 0, len(outboxList))
+		outboxIDList := make([]int64, 0, len(outboxList))
+		for _, v := range outboxList {
+			systemCodeList = append(systemCodeList, v.SystemCode)
+			outboxIDList = append(outboxIDList, v.ID)
+		}
+		webhookList, err := dal.NewRepo[model.Webhook]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("system_code IN ?", util.Deduplicate(systemCodeList)).Where("deleted_at IS NULL")
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query webhook, err: %w", err)
+		}
+
+		now := util.UTCNow()
+		deliveryList := make([]*model.WebhookDelivery, 0)
+		for _, event := range outboxList {
+			for _, v := range webhookList {
+				if v.SystemCode != event.SystemCode || !subscribes(v, event.EventType) {
+					continue
+				}
+				deliveryList = append(deliveryList, &model.WebhookDelivery{
+					OutboxID:      event.ID,
+					WebhookCode:   v.Code,
+					NextAttemptAt: now,
+					CreatedAt:     now,
+				})
+			}
+		}
+		if len(deliveryList) > 0 {
+			if err := dal.NewRepo[model.WebhookDelivery]().BatchInsert(ctx, tx, deliveryList, 20); err != nil {
+				return fmt.Errorf("failed to add delivery, err: %w", err)
+			}
+		}
+		err = dal.NewRepo[model.WebhookOutbox]().UpdateWithMap(ctx, tx, map[string]interface{}{"dispatched_at": now}, func(db *gorm.DB) *gorm.DB {
+			return db.Where("id IN ?", outboxIDList)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update outbox, err: %w", err)
+		}
+		return nil
+	})
+}
+
+func subscribes(webhook model.Webhook, eventType string) bool {
+	if webhook.EventTypeList == "" {
+		return true
+	}
+	for _, v := range strings.Split(webhook.EventTypeList, ",") {
+		if v == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver sends up to batchSize deliveries that are due. They are claimed claimSize at a time
+// by pushing their next attempt into the future, so a crash only delays them until the claim
+// times out, and a claim does not time out while its deliveries are still being sent.
+func deliver(ctx context.Context) error {
+	for sent := 0; sent < batchSize; {
+		deliveryList, err := claim(ctx)
+		if err != nil {
+			return err
+		}
+		if err := deliverList(ctx, deliveryList); err != nil {
+			return err
+		}
+		if len(deliveryList) < claimSize {
+			return nil
+		}
+		sent += len(deliveryList)
+	}
+	return nil
+}
+
+func deliverList(ctx context.Context, deliveryList []model.WebhookDelivery) error {
+	if len(deliveryList) == 0 {
+		return nil
+	}
+
+	outboxIDList := make([]int64, 0, len(deliveryList))
+	webhookCodeList := make([]string, 0, len(deliveryList))
+	for _, v := range deliveryList {
+		outboxIDList = append(outboxIDList, v.OutboxID)
+		webhookCodeList = append(webhookCodeList, v.WebhookCode)
+	}
+	outboxList, err := dal.NewRepo[model.WebhookOutbox]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN ?", util.Deduplicate(outboxIDList))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query outbox, err: %w", err)
+	}
+	webhookList, err := dal.NewRepo[model.Webhook]().QueryList(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("code IN ?", util.Deduplicate(webhookCodeList))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query webhook, err: %w", err)
+	}
+	id2Outbox := util.ToMap(outboxList, func(obj model.WebhookOutbox) int64 {
+		return obj.ID
+	})
+	code2Webhook := util.ToMap(webhookList, func(obj model.Webhook) string {
+		return obj.Code
+	})
+
+	for _, v := range deliveryList {
+		event, ok1 := id2Outbox[v.OutboxID]
+		webhook, ok2 := code2Webhook[v.WebhookCode]
+		if !ok1 || !ok2 || webhook.DeletedAt != nil {
+			// The subscription was removed after the event was dispatched
+			if err := removeDelivery(ctx, database.DB, v.ID); err != nil {
+				logger.Errorf(ctx, "failed to remove delivery, err: %v, id: %d", err, v.ID)
+			}
+			continue
+		}
+		v.Attempt++
+		if err := send(ctx, webhook, event, v); err != nil {
+			logger.Warnf(ctx, "failed to deliver webhook event, err: %v, delivery id: %d, webhook code: %s, attempt: %d", err, v.ID, v.WebhookCode, v.Attempt)
+			if err := retryLater(ctx, v, event.SystemCode, err); err != nil {
+				logger.Errorf(ctx, "failed to reschedule delivery, err: %v, id: %d", err, v.ID)
+			}
+			continue
+		}
+		if err := removeDelivery(ctx, database.DB, v.ID); err != nil {
+			logger.Errorf(ctx, "failed to remove delivery, err: %v, id: %d", err, v.ID)
+		}
+	}
+	return nil
+}
+
+func claim(ctx context.Context) ([]model.WebhookDelivery, error) {
+	var deliveryList []model.WebhookDelivery
+	err := dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		now := util.UTCNow()
+		var err error
+		deliveryList, err = dal.NewRepo[model.WebhookDelivery]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("next_attempt_at <= ?", now).Order("id asc").Limit(claimSize)
+		}, skipLocked)
+		if err != nil {
+			return fmt.Errorf("failed to query delivery, err: %w", err)
+		}
+		if len(deliveryList) == 0 {
+			return nil
+		}
+		idList := make([]int64, 0, len(deliveryList))
+		for _, v := range deliveryList {
+			idList = append(idList, v.ID)
+		}
+		err = dal.NewRepo[model.WebhookDelivery]().UpdateWithMap(ctx, tx, map[string]interface{}{"next_attempt_at": now.Add(claimTimeout)}, func(db *gorm.DB) *gorm.DB {
+			return db.Where("id IN ?", idList)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to claim delivery, err: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveryList, nil
+}
+
+// send posts the event. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the webhook secret, receivers should also reject stale timestamps.
+func send(ctx context.Context, webhook model.Webhook, event model.WebhookOutbox, delivery model.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(event.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request, err: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-AC-Event", event.EventType)
+	req.Header.Set("X-AC-Event-ID", strconv.FormatInt(event.ID, 10))
+	req.Header.Set("X-AC-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-AC-Attempt", strconv.Itoa(delivery.Attempt))
+	req.Header.Set("X-AC-Signature", fmt.Sprintf("t=%s,v1=%s", timestamp, Sign(webhook.Secret, timestamp, event.Payload)))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request, err: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func Sign(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryLater schedules the next attempt with an exponential backoff, or moves the delivery to
+// the dead letters once it ran out of attempts.
+func retryLater(ctx context.Context, delivery model.WebhookDelivery, systemCode string, cause error) error {
+	lastError := cause.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+	now := util.UTCNow()
+	if delivery.Attempt < maxAttempts {
+		backoff := min(baseBackoff<<(delivery.Attempt-1), maxBackoff)
+		return dal.NewRepo[model.WebhookDelivery]().UpdateWithMap(ctx, database.DB, map[string]interface{}{
+			"attempt":         delivery.Attempt,
+			"last_error":      lastError,
+			"next_attempt_at": now.Add(backoff),
+		}, func(db *gorm.DB) *gorm.DB {
+			return db.Where("id = ?", delivery.ID)
+		})
+	}
+	return dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		err := dal.NewRepo[model.WebhookDeadLetter]().Insert(ctx, tx, &model.WebhookDeadLetter{
+			SystemCode:  systemCode,
+			OutboxID:    delivery.OutboxID,
+			WebhookCode: delivery.WebhookCode,
+			Attempt:     delivery.Attempt,
+			LastError:   lastError,
+			CreatedAt:   now,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add dead letter, err: %w", err)
+		}
+		return removeDelivery(ctx, tx, delivery.ID)
+	})
+}
+
+func removeDelivery(ctx context.Context, db *gorm.DB, id int64) error {
+	return dal.NewRepo[model.WebhookDelivery]().Delete(ctx, db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", id)
+	})
+}
+
+// RetryDeadLetters turns dead letters of the system back into deliveries with fresh attempts.
+func RetryDeadLetters(ctx context.Context, systemCode string, idList []int64) error {
+	ctx, span := tracing.Start(ctx, "webhook.RetryDeadLetters")
+	defer span.End()
+	return dal.Transaction(ctx, database.DB, func(tx *gorm.DB) error {
+		condition := func(db *gorm.DB) *gorm.DB {
+			return db.Where(model.WebhookDeadLetter{SystemCode: systemCode}).Where("id IN ?", idList)
+		}
+		deadLetterList, err := dal.NewRepo[model.WebhookDeadLetter]().QueryList(ctx, tx, condition, skipLocked)
+		if err != nil {
+			return fmt.Errorf("failed to query dead letter, err: %w", err)
+		}
+		if len(deadLetterList) != len(idList) {
+			return ErrDeadLetterNotFound
+		}
+		now := util.UTCNow()
+		deliveryList := make([]*model.WebhookDelivery, 0, len(deadLetterList))
+		for _, v := range deadLetterList {
+			deliveryList = append(deliveryList, &model.WebhookDelivery{
+				OutboxID:      v.OutboxID,
+				WebhookCode:   v.WebhookCode,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+		}
+		if err := dal.NewRepo[model.WebhookDelivery]().BatchInsert(ctx, tx, deliveryList, 20); err != nil {
+			return fmt.Errorf("failed to add delivery, err: %w", err)
+		}
+		if err := dal.NewRepo[model.WebhookDeadLetter]().Delete(ctx, tx, condition); err != nil {
+			return fmt.Errorf("failed to delete dead letter, err: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"ac/bootstrap/database"
+	"ac/custom/define"
+	"ac/custom/tracing"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+func IsCodeAvailable(ctx context.Context, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "webhook.IsCodeAvailable")
+	defer span.End()
+	if code == "" {
+		return false, errors.New("code is empty")
+	}
+	if !strings.HasPrefix(code, define.PrefixWebhook) {
+		return false, fmt.Errorf("code must start with the prefix '%s'", define.PrefixWebhook)
+	}
+	record, err := dal.NewRepo[model.Webhook]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Webhook{Code: code})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query, err: %w, code: %s", err, code)
+	}
+	return record == nil, nil
+}
+
+// Validate reports whether the webhook exists and belongs to the system.
+func Validate(ctx context.Context, systemCode, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "webhook.Validate")
+	defer span.End()
+	if code == "" {
+		return false, errors.New("code is empty")
+	}
+	record, err := dal.NewRepo[model.Webhook]().Query(ctx, database.DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where(model.Webhook{SystemCode: systemCode, Code: code}).Where("deleted_at IS NULL")
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to query, err: %w, code: %s", err, code)
+	}
+	return record != nil, nil
+}
+
+// GenerateSecret returns a random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to read random bytes, err: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,218 @@
+// Package webhook notifies subscribed URLs about permission, membership and lifecycle changes.
+// Events are written to webhook_outbox in the transaction of the change itself and delivered
+// by Run, so an event is sent if and only if its change was committed.
+package webhook
+
+import (
+	"ac/custom/tracing"
+	"ac/custom/util"
+	"ac/dal"
+	"ac/model"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"gorm.io/gorm"
+)
+
+const (
+	EventPolicyAdded     = "policy.added"
+	EventPolicyDeleted   = "policy.deleted"
+	EventPolicySet       = "policy.set"
+	EventUserRoleAdded   = "user_role.added"
+	EventUserRoleDeleted = "user_role.deleted"
+	EventUserCreated     = "user.created"
+	EventUserUpdated     = "user.updated"
+	EventUserDeleted     = "user.deleted"
+	EventRoleCreated     = "role.created"
+	EventRoleUpdated     = "role.updated"
+	EventRoleDeleted     = "role.deleted"
+	EventResourceCreated = "resource.created"
+	EventResourceUpdated = "resource.updated"
+	EventResourceDeleted = "resource.deleted"
+	EventBreakGlass      = "break_glass.granted"
+)
+
+// Actions of the lifecycle events, the event type is "<user|role|resource>.<action>".
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// ErrUnknownSubject is returned by EnqueueRules for a grouping whose user is not found, the
+// change is refused rather than sending its event to no system.
+var ErrUnknownSubject = errors.New("unknown subject")
+
+var EventTypeList = []string{
+	EventPolicyAdded, EventPolicyDeleted, EventPolicySet,
+	EventUserRoleAdded, EventUserRoleDeleted,
+	EventUserCreated, EventUserUpdated, EventUserDeleted,
+	EventRoleCreated, EventRoleUpdated, EventRoleDeleted,
+	EventResourceCreated, EventResourceUpdated, EventResourceDeleted,
+	EventBreakGlass,
+}
+
+func IsValidEventType(eventType string) bool {
+	return slices.Contains(EventTypeList, eventType)
+}
+
+// Event is the body posted to the webhooks.
+type Event struct {
+	Type       string      `json:"type"`
+	SystemCode string      `json:"system_code"`
+	OccurredAt string      `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+type Subject struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	ExternalID string `json:"external_id"`
+}
+
+type Resource struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	ParentCode string `json:"parent_code"`
+}
+
+type Permission struct {
+	SubjectCode   string `json:"subject_code"`
+	ResourceIndex string `json:"resource_index"`
+	Action        string `json:"action"`
+	BeginTime     string `json:"begin_time"`
+	EndTime       string `json:"end_time"`
+}
+
+type UserRole struct {
+	UserCode string `json:"user_code"`
+	RoleCode string `json:"role_code"`
+}
+
+// Enqueue writes an event to the outbox with tx, the transaction of the change it describes.
+func Enqueue(ctx context.Context, tx *gorm.DB, systemCode, eventType string, data interface{}) error {
+	ctx, span := tracing.Start(ctx, "webhook.Enqueue")
+	defer span.End()
+	now := util.UTCNow()
+	payload, err := sonic.MarshalString(Event{
+		Type:       eventType,
+		SystemCode: systemCode,
+		OccurredAt: now.Format(time.RFC3339),
+		Data:       data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event, err: %w", err)
+	}
+	err = dal.NewRepo[model.WebhookOutbox]().Insert(ctx, tx, &model.WebhookOutbox{
+		SystemCode: systemCode,
+		EventType:  eventType,
+		Payload:    payload,
+		CreatedAt:  now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox, err: %w", err)
+	}
+	return nil
+}
+
+// EnqueueSubject writes a user or role lifecycle event.
+func EnqueueSubject(ctx context.Context, tx *gorm.DB, record *model.Subject, action string) error {
+	ctx, span := tracing.Start(ctx, "webhook.EnqueueSubject")
+	defer span.End()
+	return Enqueue(ctx, tx, record.SystemCode, record.Type+"."+action, Subject{
+		Code:       record.Code,
+		Name:       record.Name,
+		ExternalID: record.ExternalID,
+	})
+}
+
+// EnqueueResource writes a resource lifecycle event.
+func EnqueueResource(ctx context.Context, tx *gorm.DB, record *model.Resource, action string) error {
+	ctx, span := tracing.Start(ctx, "webhook.EnqueueResource")
+	defer span.End()
+	return Enqueue(ctx, tx, record.SystemCode, "resource."+action, Resource{
+		Code:       record.Code,
+		Name:       record.Name,
+		ParentCode: record.ParentCode,
+	})
+}
+
+// EnqueueRules writes the events of a casbin_rule change logged as logID. The rules are split
+// by system and by type, policies and groupings make separate events.
+func EnqueueRules(ctx context.Context, tx *gorm.DB, logID int64, operate string, ruleList []*model.CasbinRule) error {
+	ctx, span := tracing.Start(ctx, "webhook.EnqueueRules")
+	defer span.End()
+	userCodeList := make([]string, 0)
+	for _, v := range ruleList {
+		if v.PType == model.PTypeGroup {
+			userCodeList = append(userCodeList, v.V0)
+		}
+	}
+	userCode2SystemCode := make(map[string]string)
+	if len(userCodeList) > 0 {
+		subjectList, err := dal.NewRepo[model.Subject]().QueryList(ctx, tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("code IN ?", util.Deduplicate(userCodeList))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query subject, err: %w", err)
+		}
+		for _, v := range subjectList {
+			userCode2SystemCode[v.Code] = v.SystemCode
+		}
+	}
+	for _, v := range userCodeList {
+		// Without its system the event would reach no subscriber
+		if _, ok := userCode2SystemCode[v]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSubject, v)
+		}
+	}
+
+	systemCodeList := make([]string, 0)
+	system2PermissionList := make(map[string][]Permission)
+	system2UserRoleList := make(map[string][]UserRole)
+	for _, v := range ruleList {
+		if v.PType == model.PTypeGroup {
+			systemCode := userCode2SystemCode[v.V0]
+			systemCodeList = append(systemCodeList, systemCode)
+			system2UserRoleList[systemCode] = append(system2UserRoleList[systemCode], UserRole{UserCode: v.V0, RoleCode: v.V1})
+			continue
+		}
+		systemCode, _, _ := strings.Cut(v.V1, "/")
+		systemCodeList = append(systemCodeList, systemCode)
+		system2PermissionList[systemCode] = append(system2PermissionList[systemCode], Permission{
+			SubjectCode:   v.V0,
+			ResourceIndex: v.V1,
+			Action:        v.V2,
+			BeginTime:     v.V3,
+			EndTime:       v.V4,
+		})
+	}
+
+	policyEvent, userRoleEvent := EventPolicyAdded, EventUserRoleAdded
+	switch operate {
+	case model.OperateDelete:
+		policyEvent, userRoleEvent = EventPolicyDeleted, EventUserRoleDeleted
+	case model.OperateSet:
+		policyEvent = EventPolicySet
+	}
+	for _, systemCode := range util.Deduplicate(systemCodeList) {
+		if list, ok := system2PermissionList[systemCode]; ok {
+			data := map[string]interface{}{"log_id": logID, "permission_list": list}
+			if err := Enqueue(ctx, tx, systemCode, policyEvent, data); err != nil {
+				return err
+			}
+		}
+		if list, ok := system2UserRoleList[systemCode]; ok {
+			data := map[string]interface{}{"log_id": logID, "user_role_list": list}
+			if err := Enqueue(ctx, tx, systemCode, userRoleEvent, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -17,6 +17,33 @@
 SET NAMES utf8mb4;
 SET FOREIGN_KEY_CHECKS = 0;
 
+-- ----------------------------
+-- Table structure for break_glass
+-- ----------------------------
+DROP TABLE IF EXISTS `break_glass`;
+CREATE TABLE `break_glass` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `code` varchar(50) NOT NULL DEFAULT '' COMMENT 'code',
+  `log_id` int NOT NULL DEFAULT '0' COMMENT 'casbin_rule_log ID',
+  `user_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'user_code',
+  `resource_index` varchar(255) NOT NULL DEFAULT '' COMMENT 'resource_index',
+  `action` varchar(50) NOT NULL DEFAULT '' COMMENT 'action',
+  `justification` varchar(500) NOT NULL DEFAULT '' COMMENT 'justification',
+  `requested_by` varchar(50) NOT NULL DEFAULT '' COMMENT 'requested_by',
+  `begin_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'begin_time',
+  `end_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'end_time',
+  `reviewed_by` varchar(50) NOT NULL DEFAULT '' COMMENT 'reviewed_by',
+  `review_note` varchar(500) NOT NULL DEFAULT '' COMMENT 'review_note',
+  `reviewed_at` datetime DEFAULT NULL COMMENT 'reviewed_at',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_code` (`code`),
+  KEY `idx_system_code` (`system_code`),
+  KEY `idx_user_code` (`user_code`),
+  KEY `idx_reviewed_at` (`reviewed_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
 -- ----------------------------
 -- Table structure for casbin_rule
 -- ----------------------------
@@ -31,7 +58,7 @@ CREATE TABLE `casbin_rule` (
   `v4` varchar(100) DEFAULT NULL,
   `v5` varchar(100) DEFAULT NULL,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `uk_ptype_v0_v1` (`ptype`,`v0`,`v1`),
+  UNIQUE KEY `uk_ptype_v0_v1_v5` (`ptype`,`v0`,`v1`,`v5`),
   KEY `idx_ptype` (`ptype`),
   KEY `idx_v0` (`v0`),
   KEY `idx_v1` (`v1`),
@@ -73,6 +100,45 @@ CREATE TABLE `casbin_rule_log` (
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
+-- ----------------------------
+-- Table structure for decision_log
+-- ----------------------------
+DROP TABLE IF EXISTS `decision_log`;
+CREATE TABLE `decision_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `user_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'user_code',
+  `resource_index` varchar(255) NOT NULL DEFAULT '' COMMENT 'resource_index',
+  `action` varchar(50) NOT NULL DEFAULT '' COMMENT 'action',
+  `result` enum('allow','deny','invalid','error') NOT NULL DEFAULT 'deny' COMMENT 'result',
+  `matched_rule` varchar(500) NOT NULL DEFAULT '' COMMENT 'matched_rule',
+  `latency_us` int NOT NULL DEFAULT '0' COMMENT 'latency in microseconds',
+  `request_id` varchar(64) NOT NULL DEFAULT '' COMMENT 'request_id',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  KEY `idx_system_code_created_at` (`system_code`,`created_at`),
+  KEY `idx_user_code` (`user_code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for idempotency_key
+-- ----------------------------
+DROP TABLE IF EXISTS `idempotency_key`;
+CREATE TABLE `idempotency_key` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `key` varchar(255) NOT NULL DEFAULT '' COMMENT 'key',
+  `path` varchar(100) NOT NULL DEFAULT '' COMMENT 'path',
+  `caller` varchar(100) NOT NULL DEFAULT '' COMMENT 'token kind and admin user of the caller',
+  `request_hash` varchar(64) NOT NULL DEFAULT '' COMMENT 'sha256 of the request body',
+  `status` int NOT NULL DEFAULT '0' COMMENT 'http status of the response',
+  `response` mediumtext COMMENT 'response body',
+  `expires_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'expires_at',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_key_path_caller` (`key`,`path`,`caller`),
+  KEY `idx_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
 -- ----------------------------
 -- Table structure for resource
 -- ----------------------------
@@ -94,6 +160,101 @@ CREATE TABLE `resource` (
   KEY `idx_parent_code` (`parent_code`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
+-- ----------------------------
+-- Table structure for scim_group
+-- ----------------------------
+DROP TABLE IF EXISTS `scim_group`;
+CREATE TABLE `scim_group` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `code` varchar(50) NOT NULL DEFAULT '' COMMENT 'code',
+  `display_name` varchar(100) NOT NULL DEFAULT '' COMMENT 'display_name',
+  `external_id` varchar(100) NOT NULL DEFAULT '' COMMENT 'external_id',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated_at',
+  `deleted_at` datetime DEFAULT NULL COMMENT 'deleted_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_system_code_code` (`system_code`,`code`),
+  KEY `idx_system_code_display_name` (`system_code`,`display_name`),
+  KEY `idx_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for scim_group_member
+-- ----------------------------
+DROP TABLE IF EXISTS `scim_group_member`;
+CREATE TABLE `scim_group_member` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `group_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'group_code',
+  `user_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'user_code',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_group_code_user_code` (`group_code`,`user_code`),
+  KEY `idx_user_code` (`user_code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for scim_role_mapping
+-- ----------------------------
+DROP TABLE IF EXISTS `scim_role_mapping`;
+CREATE TABLE `scim_role_mapping` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `display_name` varchar(100) NOT NULL DEFAULT '' COMMENT 'display_name',
+  `role_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'role_code',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_system_code_display_name` (`system_code`,`display_name`),
+  KEY `idx_system_code_role_code` (`system_code`,`role_code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for scim_role_grant
+-- ----------------------------
+DROP TABLE IF EXISTS `scim_role_grant`;
+CREATE TABLE `scim_role_grant` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `user_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'user_code',
+  `role_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'role_code',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_user_code_role_code` (`user_code`,`role_code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for sod_constraint
+-- ----------------------------
+DROP TABLE IF EXISTS `sod_constraint`;
+CREATE TABLE `sod_constraint` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `code` varchar(50) NOT NULL DEFAULT '' COMMENT 'code',
+  `name` varchar(50) NOT NULL DEFAULT '' COMMENT 'name',
+  `description` varchar(200) NOT NULL DEFAULT '' COMMENT 'description',
+  `modified_by` varchar(50) NOT NULL DEFAULT '' COMMENT 'modified_by',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated_at',
+  `deleted_at` datetime DEFAULT NULL COMMENT 'deleted_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_code` (`code`),
+  KEY `idx_system_code` (`system_code`),
+  KEY `idx_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for sod_constraint_role
+-- ----------------------------
+DROP TABLE IF EXISTS `sod_constraint_role`;
+CREATE TABLE `sod_constraint_role` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `constraint_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'constraint_code',
+  `role_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'role_code',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_constraint_code_role_code` (`constraint_code`,`role_code`),
+  KEY `idx_role_code` (`role_code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
 -- ----------------------------
 -- Table structure for subject
 -- ----------------------------
@@ -104,14 +265,19 @@ CREATE TABLE `subject` (
   `type` enum('user','role') CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT 'user' COMMENT 'type',
   `name` varchar(50) NOT NULL DEFAULT '' COMMENT 'name',
   `code` varchar(50) NOT NULL DEFAULT '' COMMENT 'code',
+  `external_id` varchar(100) NOT NULL DEFAULT '' COMMENT 'external_id',
+  `external_id_key` varchar(100) GENERATED ALWAYS AS (IF(`external_id` = '' OR `deleted_at` IS NOT NULL, NULL, `external_id`)) VIRTUAL COMMENT 'external_id_key',
   `description` varchar(50) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT '' COMMENT 'description',
+  `permission_version` int NOT NULL DEFAULT '1' COMMENT 'permission_version',
+  `role_version` int NOT NULL DEFAULT '1' COMMENT 'role_version',
   `modified_by` varchar(50) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT '' COMMENT 'modified_by',
   `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
   `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated_at',
   `deleted_at` datetime DEFAULT NULL COMMENT 'deleted_at',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_system_code_code` (`system_code`,`code`),
-  KEY `idx_system_code_name_type` (`system_code`,`name`,`type`)
+  KEY `idx_system_code_name_type` (`system_code`,`name`,`type`),
+  UNIQUE KEY `uk_system_code_external_id` (`system_code`,`external_id_key`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
 -- ----------------------------
@@ -132,4 +298,72 @@ CREATE TABLE `system` (
   KEY `idx_name` (`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
+-- ----------------------------
+-- Table structure for webhook
+-- ----------------------------
+DROP TABLE IF EXISTS `webhook`;
+CREATE TABLE `webhook` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `code` varchar(50) NOT NULL DEFAULT '' COMMENT 'code',
+  `url` varchar(500) NOT NULL DEFAULT '' COMMENT 'url',
+  `secret` varchar(100) NOT NULL DEFAULT '' COMMENT 'secret',
+  `event_type_list` varchar(500) NOT NULL DEFAULT '' COMMENT 'comma separated event types, empty means all',
+  `modified_by` varchar(50) NOT NULL DEFAULT '' COMMENT 'modified_by',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated_at',
+  `deleted_at` datetime DEFAULT NULL COMMENT 'deleted_at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_code` (`code`),
+  KEY `idx_system_code` (`system_code`),
+  KEY `idx_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for webhook_dead_letter
+-- ----------------------------
+DROP TABLE IF EXISTS `webhook_dead_letter`;
+CREATE TABLE `webhook_dead_letter` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `outbox_id` int NOT NULL DEFAULT '0' COMMENT 'webhook_outbox ID',
+  `webhook_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'webhook_code',
+  `attempt` int NOT NULL DEFAULT '0' COMMENT 'attempt',
+  `last_error` varchar(500) NOT NULL DEFAULT '' COMMENT 'last_error',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  KEY `idx_system_code` (`system_code`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for webhook_delivery
+-- ----------------------------
+DROP TABLE IF EXISTS `webhook_delivery`;
+CREATE TABLE `webhook_delivery` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `outbox_id` int NOT NULL DEFAULT '0' COMMENT 'webhook_outbox ID',
+  `webhook_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'webhook_code',
+  `attempt` int NOT NULL DEFAULT '0' COMMENT 'attempt',
+  `next_attempt_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'next_attempt_at',
+  `last_error` varchar(500) NOT NULL DEFAULT '' COMMENT 'last_error',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  PRIMARY KEY (`id`),
+  KEY `idx_next_attempt_at` (`next_attempt_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- ----------------------------
+-- Table structure for webhook_outbox
+-- ----------------------------
+DROP TABLE IF EXISTS `webhook_outbox`;
+CREATE TABLE `webhook_outbox` (
+  `id` int NOT NULL AUTO_INCREMENT COMMENT 'id',
+  `system_code` varchar(50) NOT NULL DEFAULT '' COMMENT 'system_code',
+  `event_type` varchar(50) NOT NULL DEFAULT '' COMMENT 'event_type',
+  `payload` text NOT NULL COMMENT 'payload',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created_at',
+  `dispatched_at` datetime DEFAULT NULL COMMENT 'dispatched_at',
+  PRIMARY KEY (`id`),
+  KEY `idx_dispatched_at` (`dispatched_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
 SET FOREIGN_KEY_CHECKS = 1;